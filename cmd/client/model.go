@@ -0,0 +1,133 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bhojpur/policy/pkg/model"
+	fileadapter "github.com/bhojpur/policy/pkg/persist/file-adapter"
+	"github.com/bhojpur/policy/pkg/persist/migrate"
+	"github.com/spf13/cobra"
+)
+
+var modelDiffCmdOpts struct {
+	Policy string
+}
+
+var modelMigrateCmdOpts struct {
+	History string
+}
+
+// modelCmd represents the model command
+var modelCmd = &cobra.Command{
+	Use:   "model",
+	Short: "Works with the access control models",
+}
+
+// modelDiffCmd represents the model diff command
+var modelDiffCmd = &cobra.Command{
+	Use:   "diff <old-model> <new-model>",
+	Short: "Lists the changes between two models and whether they break the existing policy rules",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldModel, err := model.NewModelFromFile(args[0])
+		if err != nil {
+			return fmt.Errorf("cannot load %s: %w", args[0], err)
+		}
+		newModel, err := model.NewModelFromFile(args[1])
+		if err != nil {
+			return fmt.Errorf("cannot load %s: %w", args[1], err)
+		}
+
+		diff := model.Diff(oldModel, newModel)
+		if modelDiffCmdOpts.Policy != "" {
+			if err := fileadapter.NewAdapter(modelDiffCmdOpts.Policy).LoadPolicy(oldModel); err != nil {
+				return fmt.Errorf("cannot load %s: %w", modelDiffCmdOpts.Policy, err)
+			}
+			diff.CheckPolicy(oldModel)
+		}
+
+		if len(diff.Changes) == 0 {
+			fmt.Println("the models are the same")
+			return nil
+		}
+		for _, change := range diff.Changes {
+			fmt.Println(change)
+			if change.Migration != "" {
+				fmt.Println("    migration: " + change.Migration)
+			}
+		}
+		if diff.Breaking() {
+			cmd.SilenceUsage, cmd.SilenceErrors = true, true
+			return errors.New("the new model breaks the existing policy rules or callers")
+		}
+		return nil
+	},
+}
+
+// modelMigrateCmd represents the model migrate command
+var modelMigrateCmd = &cobra.Command{
+	Use:   "migrate <model> <migrations> <policy>",
+	Short: "Applies the pending migrations of a YAML file to the rules of a policy CSV file",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		m, err := model.NewModelFromFile(args[0])
+		if err != nil {
+			return fmt.Errorf("cannot load %s: %w", args[0], err)
+		}
+		migrations, err := migrate.LoadMigrations(args[1])
+		if err != nil {
+			return fmt.Errorf("cannot load %s: %w", args[1], err)
+		}
+
+		history := modelMigrateCmdOpts.History
+		if history == "" {
+			history = args[2] + ".migrations"
+		}
+		runner := migrate.NewRunner(m, fileadapter.NewAdapter(args[2]), migrate.NewFileHistory(history))
+		if err := runner.Add(migrations...); err != nil {
+			return err
+		}
+		ids, err := runner.Run()
+		if err != nil {
+			return err
+		}
+
+		if len(ids) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		for _, id := range ids {
+			fmt.Println("applied " + id)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(modelCmd)
+	modelCmd.AddCommand(modelDiffCmd)
+	modelCmd.AddCommand(modelMigrateCmd)
+
+	modelDiffCmd.Flags().StringVar(&modelDiffCmdOpts.Policy, "policy", "", "policy CSV file of the old model, the changes of the policy types without rules are then compatible")
+	modelMigrateCmd.Flags().StringVar(&modelMigrateCmdOpts.History, "history", "", "file recording the applied migrations (defaults to the policy file with a .migrations extension)")
+}
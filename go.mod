@@ -12,6 +12,7 @@ require (
 	github.com/spf13/cobra v1.3.0
 	google.golang.org/grpc v1.44.0
 	google.golang.org/protobuf v1.27.1
+	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/apimachinery v0.23.6
 	k8s.io/client-go v1.5.2
 )
@@ -27,8 +27,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var (
@@ -40,8 +42,28 @@ var (
 	DEFAULT_COMMENT_SEM = []byte{';'}
 	// DEFAULT_MULTI_LINE_SEPARATOR defines what character indicates a multi-line content
 	DEFAULT_MULTI_LINE_SEPARATOR = []byte{'\\'}
+	// DEFAULT_INCLUDE defines the directive including another configuration, like `include base.conf`
+	DEFAULT_INCLUDE = "include"
 )
 
+// includes holds the texts registered by RegisterInclude, keyed by name.
+var includes sync.Map
+
+// rawSections are the sections of the models, their values are expressions which are not interpolated.
+var rawSections = map[string]bool{
+	"request_definition": true,
+	"policy_definition":  true,
+	"role_definition":    true,
+	"policy_effect":      true,
+	"matchers":           true,
+}
+
+// RegisterInclude registers the text of a configuration that the include directives can refer to by name,
+// e.g. a base model embedded in the binary. A registered name takes precedence over a file of the same name.
+func RegisterInclude(name string, text string) {
+	includes.Store(name, text)
+}
+
 // ConfigInterface defines the behavior of a Config implementation
 type ConfigInterface interface {
 	String(key string) string
@@ -51,102 +73,242 @@ type ConfigInterface interface {
 	Int64(key string) (int64, error)
 	Float64(key string) (float64, error)
 	Set(key string, value string) error
+	Sections() []string
+	Keys(section string) []string
+	WriteTo(w io.Writer) (int64, error)
 }
 
 // Config represents an implementation of the ConfigInterface
 type Config struct {
 	// Section:key=value
 	data map[string]map[string]string
+	// sections and keys are the sections and the keys of every section in definition order.
+	sections []string
+	keys     map[string][]string
+	// lines are the lines of the configuration, kept to write it back.
+	lines []textLine
 }
 
-// NewConfig create an empty configuration representation from file.
-func NewConfig(confName string) (ConfigInterface, error) {
-	c := &Config{
+// textLine is a line of the configuration, the option is empty for the comments, the blank lines, the
+// section headers and the include directives.
+type textLine struct {
+	section string
+	option  string
+	text    string
+}
+
+// newConfig creates an empty configuration.
+func newConfig() *Config {
+	return &Config{
 		data: make(map[string]map[string]string),
+		keys: make(map[string][]string),
 	}
+}
+
+// NewConfig create an empty configuration representation from file.
+//
+// A line like `include base.conf` merges the sections of another configuration, which is either a text
+// registered by RegisterInclude or a file, relative to the including file. The definitions of the including
+// configuration take precedence over the included ones wherever the directive is, and a later include
+// takes precedence over an earlier one. Including a configuration that includes itself is an error.
+//
+// The values may reference environment variables like `${HOME}`, or `${ROLE:-admin}` to default to admin
+// when ROLE is unset or empty. `$${` is written for a literal `${`. The values of the sections of the models,
+// like [matchers], are not interpolated.
+func NewConfig(confName string) (ConfigInterface, error) {
+	c := newConfig()
 	err := c.parse(confName)
 	return c, err
 }
 
 // NewConfigFromText create an empty configuration representation from text.
+// The included files are relative to the working directory.
 func NewConfigFromText(text string) (ConfigInterface, error) {
-	c := &Config{
-		data: make(map[string]map[string]string),
-	}
-	err := c.parseBuffer(bufio.NewReader(strings.NewReader(text)))
+	c := newConfig()
+	var err error
+	c.lines, err = c.parseWithIncludes(bufio.NewReader(strings.NewReader(text)), ".", nil)
 	return c, err
 }
 
 // AddConfig adds a new section->key:value to the configuration.
 func (c *Config) AddConfig(section string, option string, value string) bool {
+	added := c.addConfig(section, option, value)
+	c.setLine(section, option, option+" = "+value)
+	return added
+}
+
+// addConfig adds a new section->key:value to the data of the configuration, but not to its lines.
+func (c *Config) addConfig(section string, option string, value string) bool {
 	if section == "" {
 		section = DEFAULT_SECTION
 	}
 
 	if _, ok := c.data[section]; !ok {
 		c.data[section] = make(map[string]string)
+		c.sections = append(c.sections, section)
 	}
 
 	_, ok := c.data[section][option]
 	c.data[section][option] = value
+	if !ok {
+		c.keys[section] = append(c.keys[section], option)
+	}
 
 	return !ok
 }
 
 func (c *Config) parse(fname string) (err error) {
+	c.lines, err = c.parseFile(fname, nil)
+	return err
+}
+
+// parseFile parses the file included through the configurations of the stack and returns its lines.
+func (c *Config) parseFile(fname string, stack []string) ([]textLine, error) {
+	path, err := filepath.Abs(fname)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIncludeCycle(path, stack); err != nil {
+		return nil, err
+	}
+
 	f, err := os.Open(fname)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close()
 
 	buf := bufio.NewReader(f)
-	return c.parseBuffer(buf)
+	return c.parseWithIncludes(buf, filepath.Dir(fname), append(stack, path))
+}
+
+// parseWithIncludes parses the configuration and merges the configurations it includes before it, the
+// included files being relative to dir. It returns the lines of the configuration, without the included ones.
+func (c *Config) parseWithIncludes(buf *bufio.Reader, dir string, stack []string) ([]textLine, error) {
+	own := newConfig()
+	names, err := own.parseBuffer(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if err := c.include(name, dir, stack); err != nil {
+			return nil, fmt.Errorf("include %s: %w", name, err)
+		}
+	}
+	for _, section := range own.sections {
+		for _, option := range own.keys[section] {
+			c.addConfig(section, option, own.data[section][option])
+		}
+	}
+	return own.lines, nil
 }
 
-func (c *Config) parseBuffer(buf *bufio.Reader) error {
+// include merges the registered text or the file of the given name.
+func (c *Config) include(name string, dir string, stack []string) error {
+	if text, ok := includes.Load(name); ok {
+		key := DEFAULT_INCLUDE + " " + name
+		if err := checkIncludeCycle(key, stack); err != nil {
+			return err
+		}
+		_, err := c.parseWithIncludes(bufio.NewReader(strings.NewReader(text.(string))), dir, append(stack, key))
+		return err
+	}
+
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	_, err := c.parseFile(name, stack)
+	return err
+}
+
+// checkIncludeCycle returns an error when the configuration is already in the stack of the includes.
+func checkIncludeCycle(name string, stack []string) error {
+	for i, included := range stack {
+		if included == name {
+			return fmt.Errorf("include cycle: %s", strings.Join(append(stack[i:len(stack):len(stack)], name), " -> "))
+		}
+	}
+	return nil
+}
+
+// includeName returns the configuration included by the line, if it is an include directive.
+func includeName(line []byte) (string, bool) {
+	if i := bytes.IndexAny(line, string(DEFAULT_COMMENT)+string(DEFAULT_COMMENT_SEM)); i != -1 {
+		line = line[:i]
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) != 2 || fields[0] != DEFAULT_INCLUDE || bytes.IndexByte(line, '=') != -1 {
+		return "", false
+	}
+	return strings.Trim(fields[1], `"'`), true
+}
+
+// parseBuffer parses the configuration and returns the names of the configurations it includes.
+func (c *Config) parseBuffer(buf *bufio.Reader) ([]string, error) {
+	var includes []string
 	var section string
 	var lineNum int
 	var buffer bytes.Buffer
 	var canWrite bool
+	// pending are the lines of the option in the buffer.
+	var pending []int
+	flush := func() error {
+		option, err := c.write(section, lineNum, &buffer)
+		for _, i := range pending {
+			c.lines[i].option = option
+		}
+		pending = nil
+		return err
+	}
 	for {
 		if canWrite {
-			if err := c.write(section, lineNum, &buffer); err != nil {
-				return err
+			if err := flush(); err != nil {
+				return nil, err
 			} else {
 				canWrite = false
 			}
 		}
 		lineNum++
-		line, _, err := buf.ReadLine()
+		text, _, err := buf.ReadLine()
 		if err == io.EOF {
 			// force write when buffer is not flushed yet
 			if buffer.Len() > 0 {
-				if err := c.write(section, lineNum, &buffer); err != nil {
-					return err
+				if err := flush(); err != nil {
+					return nil, err
 				}
 			}
 			break
 		} else if err != nil {
-			return err
+			return nil, err
 		}
 
-		line = bytes.TrimSpace(line)
+		line := bytes.TrimSpace(text)
+		if name, ok := includeName(line); ok && buffer.Len() == 0 {
+			c.lines = append(c.lines, textLine{section: section, text: string(text)})
+			includes = append(includes, name)
+			continue
+		}
 		switch {
 		case bytes.Equal(line, []byte{}), bytes.HasPrefix(line, DEFAULT_COMMENT_SEM),
 			bytes.HasPrefix(line, DEFAULT_COMMENT):
+			c.lines = append(c.lines, textLine{section: section, text: string(text)})
 			canWrite = true
 			continue
 		case bytes.HasPrefix(line, []byte{'['}) && bytes.HasSuffix(line, []byte{']'}):
 			// force write when buffer is not flushed yet
 			if buffer.Len() > 0 {
-				if err := c.write(section, lineNum, &buffer); err != nil {
-					return err
+				if err := flush(); err != nil {
+					return nil, err
 				}
 				canWrite = false
 			}
 			section = string(line[1 : len(line)-1])
+			c.lines = append(c.lines, textLine{section: section, text: string(text)})
 		default:
+			pending = append(pending, len(c.lines))
+			c.lines = append(c.lines, textLine{section: section, text: string(text)})
+
 			var p []byte
 			if bytes.HasSuffix(line, DEFAULT_MULTI_LINE_SEPARATOR) {
 				p = bytes.TrimSpace(line[:len(line)-1])
@@ -164,31 +326,32 @@ func (c *Config) parseBuffer(buf *bufio.Reader) error {
 				}
 			}
 			if _, err := buffer.Write(p[:end]); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
 
-	return nil
+	return includes, nil
 }
 
-func (c *Config) write(section string, lineNum int, b *bytes.Buffer) error {
+// write adds the option in the buffer and returns its name.
+func (c *Config) write(section string, lineNum int, b *bytes.Buffer) (string, error) {
 	if b.Len() <= 0 {
-		return nil
+		return "", nil
 	}
 
 	optionVal := bytes.SplitN(b.Bytes(), []byte{'='}, 2)
 	if len(optionVal) != 2 {
-		return fmt.Errorf("parse the content error : line %d , %s = ? ", lineNum, optionVal[0])
+		return "", fmt.Errorf("parse the content error : line %d , %s = ? ", lineNum, optionVal[0])
 	}
 	option := bytes.TrimSpace(optionVal[0])
 	value := bytes.TrimSpace(optionVal[1])
-	c.AddConfig(section, string(option), string(value))
+	c.addConfig(section, string(option), string(value))
 
 	// flush buffer after adding
 	b.Reset()
 
-	return nil
+	return string(option), nil
 }
 
 // Bool lookups up the value using the provided key and converts the value to a bool
@@ -249,6 +412,75 @@ func (c *Config) Set(key string, value string) error {
 	return nil
 }
 
+// setLine replaces the lines of the option by text, or adds it after the last option of its section.
+func (c *Config) setLine(section string, option string, text string) {
+	if section == "" {
+		section = DEFAULT_SECTION
+	}
+	inSection := func(l textLine) bool {
+		return l.section == section || l.section == "" && section == DEFAULT_SECTION
+	}
+
+	lines := make([]textLine, 0, len(c.lines)+1)
+	set, last := false, -1
+	for _, l := range c.lines {
+		if inSection(l) && l.option == option {
+			if !set {
+				lines = append(lines, textLine{section: l.section, option: option, text: text})
+				set, last = true, len(lines)-1
+			}
+			continue
+		}
+		lines = append(lines, l)
+		if inSection(l) && (l.option != "" || strings.HasPrefix(strings.TrimSpace(l.text), "[")) {
+			last = len(lines) - 1
+		}
+	}
+
+	if !set {
+		switch {
+		case last != -1 || section == DEFAULT_SECTION:
+			lines = append(lines[:last+1], append([]textLine{{section: section, option: option, text: text}}, lines[last+1:]...)...)
+		default:
+			if len(lines) != 0 && strings.TrimSpace(lines[len(lines)-1].text) != "" {
+				lines = append(lines, textLine{section: section})
+			}
+			lines = append(lines,
+				textLine{section: section, text: "[" + section + "]"},
+				textLine{section: section, option: option, text: text})
+		}
+	}
+	c.lines = lines
+}
+
+// Sections returns the sections of the configuration in definition order.
+func (c *Config) Sections() []string {
+	return append([]string(nil), c.sections...)
+}
+
+// Keys returns the keys of the section in definition order, the keys outside of any section are in
+// the DEFAULT_SECTION.
+func (c *Config) Keys(section string) []string {
+	if section == "" {
+		section = DEFAULT_SECTION
+	}
+	return append([]string(nil), c.keys[section]...)
+}
+
+// WriteTo writes the configuration, with its comments and multi-line values as they were read and the
+// values changed by Set, without the included configurations.
+func (c *Config) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, l := range c.lines {
+		m, err := io.WriteString(w, l.text+"\n")
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
 // section.key or key
 func (c *Config) get(key string) string {
 	var (
@@ -266,8 +498,52 @@ func (c *Config) get(key string) string {
 	}
 
 	if value, ok := c.data[section][option]; ok {
-		return value
+		if rawSections[section] {
+			return value
+		}
+		return interpolate(value)
 	}
 
 	return ""
 }
+
+// interpolate replaces the ${VAR} references of the value by the environment variable VAR, and the
+// ${VAR:-default} references by the default value when VAR is unset or empty. $${ is a literal ${.
+func interpolate(value string) string {
+	if !strings.Contains(value, "${") {
+		return value
+	}
+
+	var res strings.Builder
+	for {
+		i := strings.Index(value, "${")
+		if i == -1 {
+			break
+		}
+		if i > 0 && value[i-1] == '$' {
+			res.WriteString(value[:i])
+			res.WriteString("{")
+			value = value[i+2:]
+			continue
+		}
+		end := strings.IndexByte(value[i:], '}')
+		if end == -1 {
+			break
+		}
+		res.WriteString(value[:i])
+
+		reference := value[i+2 : i+end]
+		name, def := reference, ""
+		if j := strings.Index(reference, ":-"); j != -1 {
+			name, def = reference[:j], reference[j+2:]
+		}
+		if env := os.Getenv(name); env != "" {
+			res.WriteString(env)
+		} else {
+			res.WriteString(def)
+		}
+		value = value[i+end+1:]
+	}
+	res.WriteString(value)
+	return res.String()
+}
@@ -21,6 +21,9 @@ package config
 // THE SOFTWARE.
 
 import (
+	"bytes"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -94,3 +97,169 @@ func TestGet(t *testing.T) {
 		t.Errorf("Get failure: expected different value for multi5::name (expected: [%#v] got: [%#v])", "r.sub==p.sub&&r.obj==p.obj", v)
 	}
 }
+
+func TestInclude(t *testing.T) {
+	config, err := NewConfig("testdata/include_service.ini")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for key, expected := range map[string]string{"section::a": "override", "section::b": "service", "section::c": "service"} {
+		if v := config.String(key); v != expected {
+			t.Errorf("Get failure: expected different value for %s (expected: [%#v] got: [%#v])", key, expected, v)
+		}
+	}
+
+	var buf bytes.Buffer
+	_, _ = config.WriteTo(&buf)
+	if !strings.Contains(buf.String(), "include include_base.ini\n") || strings.Contains(buf.String(), "a = base") {
+		t.Errorf("WriteTo failure: the included configurations should not be written, got: [%#v]", buf.String())
+	}
+
+	_, err = NewConfig("testdata/include_cycle_a.ini")
+	if err == nil || !strings.Contains(err.Error(), "include_cycle_a.ini -> ") || !strings.Contains(err.Error(), "include cycle") {
+		t.Errorf("include cycle should be detected, got %v", err)
+	}
+	if _, err = NewConfigFromText("include testdata/include_missing.ini\n"); err == nil {
+		t.Error("missing included file should be an error")
+	}
+
+	RegisterInclude("base", "[section]\na = registered\nb = registered\n")
+	config, err = NewConfigFromText("include base\n[section]\nb = text\n")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if v := config.String("section::a"); v != "registered" {
+		t.Errorf("Get failure: expected different value for section::a (expected: [%#v] got: [%#v])", "registered", v)
+	}
+	if v := config.String("section::b"); v != "text" {
+		t.Errorf("Get failure: expected different value for section::b (expected: [%#v] got: [%#v])", "text", v)
+	}
+
+	RegisterInclude("self", "include self\n")
+	if _, err = NewConfigFromText("include self\n"); err == nil || !strings.Contains(err.Error(), "include cycle: include self -> include self") {
+		t.Errorf("include cycle should be detected, got %v", err)
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	_ = os.Setenv("CONFIG_TEST_HOST", "10.0.0.2")
+	defer os.Unsetenv("CONFIG_TEST_HOST")
+	_ = os.Unsetenv("CONFIG_TEST_USER")
+
+	config, err := NewConfigFromText(`
+[mysql]
+host = ${CONFIG_TEST_HOST}:3306
+user = ${CONFIG_TEST_USER:-root}
+pass = ${CONFIG_TEST_USER}
+url = $${CONFIG_TEST_HOST} ${CONFIG_TEST_HOST:-localhost} ${CONFIG_TEST_HOST
+
+[matchers]
+m = r.obj == "${CONFIG_TEST_HOST}"
+`)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for key, expected := range map[string]string{
+		"mysql::host": "10.0.0.2:3306",
+		"mysql::user": "root",
+		"mysql::pass": "",
+		"mysql::url":  "${CONFIG_TEST_HOST} 10.0.0.2 ${CONFIG_TEST_HOST",
+		"matchers::m": `r.obj == "${CONFIG_TEST_HOST}"`,
+	} {
+		if v := config.String(key); v != expected {
+			t.Errorf("Get failure: expected different value for %s (expected: [%#v] got: [%#v])", key, expected, v)
+		}
+	}
+}
+
+func TestSectionsAndKeys(t *testing.T) {
+	config, err := NewConfig("testdata/testini.ini")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expected := "default,redis,mysql,math,multi1,multi2,multi3,multi4,multi5"
+	if v := strings.Join(config.Sections(), ","); v != expected {
+		t.Errorf("Sections failure: expected [%#v] got: [%#v]", expected, v)
+	}
+	expected = "debug,url"
+	if v := strings.Join(config.Keys(""), ","); v != expected {
+		t.Errorf("Keys failure: expected [%#v] got: [%#v]", expected, v)
+	}
+	expected = "mysql.dev.host,mysql.dev.user,mysql.dev.pass,mysql.dev.db,mysql.master.host,mysql.master.user,mysql.master.pass,mysql.master.db"
+	if v := strings.Join(config.Keys("mysql"), ","); v != expected {
+		t.Errorf("Keys failure: expected [%#v] got: [%#v]", expected, v)
+	}
+	if v := config.Keys("other"); len(v) != 0 {
+		t.Errorf("Keys failure: expected no keys got: [%#v]", v)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	text := `# test config
+debug = true
+
+; multi-line test
+[multi1]
+name = r.sub==p.sub \
+   && r.obj==p.obj
+
+[multi2]
+name = r.sub==p.sub \
+   && r.obj==p.obj
+`
+	config, err := NewConfigFromText(text)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := config.WriteTo(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if buf.String() != text {
+		t.Errorf("WriteTo failure: expected [%#v] got: [%#v]", text, buf.String())
+	}
+
+	_ = config.Set("multi1::name", "r.sub==p.sub")
+	_ = config.Set("multi1::other", "value")
+	_ = config.Set("url", "act.wiki")
+	_ = config.Set("other::key1", "test key")
+	// the values added with AddConfig are written as well.
+	config.(*Config).AddConfig("other", "key2", "added key")
+
+	expected := `# test config
+debug = true
+url = act.wiki
+
+; multi-line test
+[multi1]
+name = r.sub==p.sub
+other = value
+
+[multi2]
+name = r.sub==p.sub \
+   && r.obj==p.obj
+
+[other]
+key1 = test key
+key2 = added key
+`
+	buf.Reset()
+	if _, err := config.WriteTo(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if buf.String() != expected {
+		t.Errorf("WriteTo failure: expected [%#v] got: [%#v]", expected, buf.String())
+	}
+
+	written, err := NewConfigFromText(buf.String())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, key := range []string{"debug", "url", "multi1::name", "multi1::other", "multi2::name", "other::key1", "other::key2"} {
+		if written.String(key) != config.String(key) {
+			t.Errorf("WriteTo failure: expected different value for %s (expected: [%#v] got: [%#v])", key, config.String(key), written.String(key))
+		}
+	}
+}
@@ -20,8 +20,6 @@ package effector
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-import "errors"
-
 // DefaultEffector is default effector for Bhojpur Policy.
 type DefaultEffector struct {
 }
@@ -33,79 +31,13 @@ func NewDefaultEffector() *DefaultEffector {
 }
 
 // MergeEffects merges all matching results collected by the enforcer into a single decision.
+// The policy effect is parsed once by ParseEffect and looked up by its text afterwards.
 func (e *DefaultEffector) MergeEffects(expr string, effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int, error) {
-	result := Indeterminate
-	explainIndex := -1
-
-	switch expr {
-	case "some(where (p_eft == allow))":
-		if matches[policyIndex] == 0 {
-			break
-		}
-		// only check the current policyIndex
-		if effects[policyIndex] == Allow {
-			result = Allow
-			explainIndex = policyIndex
-			break
-		}
-	case "!some(where (p_eft == deny))":
-		// only check the current policyIndex
-		if matches[policyIndex] != 0 && effects[policyIndex] == Deny {
-			result = Deny
-			explainIndex = policyIndex
-			break
-		}
-		// if no deny rules are matched  at last, then allow
-		if policyIndex == policyLength-1 {
-			result = Allow
-		}
-	case "some(where (p_eft == allow)) && !some(where (p_eft == deny))":
-		// short-circuit if matched deny rule
-		if matches[policyIndex] != 0 && effects[policyIndex] == Deny {
-			result = Deny
-			// set hit rule to the (first) matched deny rule
-			explainIndex = policyIndex
-			break
-		}
-
-		// short-circuit some effects in the middle
-		if policyIndex < policyLength-1 {
-			// choose not to short-circuit
-			return result, explainIndex, nil
-		}
-		// merge all effects at last
-		for i, eft := range effects {
-			if matches[i] == 0 {
-				continue
-			}
-
-			if eft == Allow {
-				result = Allow
-				// set hit rule to first matched allow rule
-				explainIndex = i
-				break
-			}
-		}
-	case "priority(p_eft) || deny", "subjectPriority(p_eft) || deny":
-		// reverse merge, short-circuit may be earlier
-		for i := len(effects) - 1; i >= 0; i-- {
-			if matches[i] == 0 {
-				continue
-			}
-
-			if effects[i] != Indeterminate {
-				if effects[i] == Allow {
-					result = Allow
-				} else {
-					result = Deny
-				}
-				explainIndex = i
-				break
-			}
-		}
-	default:
-		return Deny, -1, errors.New("unsupported effect")
+	expression, err := ParseEffect(expr)
+	if err != nil {
+		return Deny, -1, err
 	}
 
+	result, explainIndex := expression.Merge(effects, matches, policyIndex, policyLength)
 	return result, explainIndex, nil
 }
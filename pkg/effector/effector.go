@@ -20,6 +20,8 @@ package effector
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
+import "fmt"
+
 // Effect is the result for a policy rule.
 type Effect int
 
@@ -28,8 +30,41 @@ const (
 	Allow Effect = iota
 	Indeterminate
 	Deny
+	// NotApplicable is the decision of the combining algorithms when no rule applies to the request,
+	// it is only returned for the last rule, as Indeterminate asks the enforcer for more rules.
+	NotApplicable
 )
 
+// String returns the name of the effect as written in policies: "allow", "indeterminate", "deny" or "not-applicable".
+func (eft Effect) String() string {
+	switch eft {
+	case Allow:
+		return "allow"
+	case Deny:
+		return "deny"
+	case NotApplicable:
+		return "not-applicable"
+	default:
+		return "indeterminate"
+	}
+}
+
+// MarshalText encodes the effect by its name, so that it reads like the policies in JSON.
+func (eft Effect) MarshalText() ([]byte, error) {
+	return []byte(eft.String()), nil
+}
+
+// UnmarshalText decodes the effect from its name.
+func (eft *Effect) UnmarshalText(text []byte) error {
+	for _, effect := range []Effect{Allow, Indeterminate, Deny, NotApplicable} {
+		if string(text) == effect.String() {
+			*eft = effect
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid effect %q", text)
+}
+
 // Effector is the interface for Bhojpur Policy effectors.
 type Effector interface {
 	// MergeEffects merges all matching results collected by the enforcer into a single decision.
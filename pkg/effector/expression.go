@@ -0,0 +1,516 @@
+package effector
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// CombiningAlgorithm merges the effects of the rules evaluated so far, it is called by the policy
+// effect for every evaluated rule as "name(p_eft)" or just "name". It returns the decided effect and
+// the index of the deciding rule, or Indeterminate to see more rules. For the last rule, it returns
+// NotApplicable when no rule applies and Indeterminate when the rules can't be combined.
+type CombiningAlgorithm func(effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int)
+
+var algorithms = struct {
+	sync.RWMutex
+	m map[string]CombiningAlgorithm
+}{m: map[string]CombiningAlgorithm{
+	"priority":               priorityAlgorithm,
+	"subjectPriority":        priorityAlgorithm,
+	"first-applicable":       firstApplicable,
+	"deny-unless-permit":     denyUnlessPermit,
+	"permit-unless-deny":     permitUnlessDeny,
+	"only-one-applicable":    onlyOneApplicable,
+	"ordered-deny-overrides": orderedDenyOverrides,
+}}
+
+// expressions caches the parsed policy effects by their text.
+var expressions sync.Map
+
+// RegisterCombiningAlgorithm registers a combining algorithm that policy effects can call by name.
+// It must be registered before the models using it are loaded.
+func RegisterCombiningAlgorithm(name string, fn CombiningAlgorithm) {
+	algorithms.Lock()
+	algorithms.m[name] = fn
+	algorithms.Unlock()
+
+	expressions.Range(func(key, value interface{}) bool {
+		expressions.Delete(key)
+		return true
+	})
+}
+
+func getCombiningAlgorithm(name string) (CombiningAlgorithm, bool) {
+	algorithms.RLock()
+	defer algorithms.RUnlock()
+	fn, ok := algorithms.m[name]
+	return fn, ok
+}
+
+// priorityAlgorithm lets the first matched rule with an allow or deny effect decide, the rules are
+// sorted by priority when the policy is loaded.
+func priorityAlgorithm(effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int) {
+	for i := 0; i <= policyIndex; i++ {
+		if matches[i] != 0 && effects[i] != Indeterminate {
+			return effects[i], i
+		}
+	}
+	return Indeterminate, -1
+}
+
+// Expression is a parsed policy effect, such as "some(where (p_eft == allow)) && !some(where (p_eft == deny))".
+type Expression struct {
+	text string
+	root effectNode
+}
+
+// ParseEffect parses a policy effect. It supports the some(where (p_eft == allow|deny)) and
+// all(where (p_eft == allow|deny)) quantifiers, the registered combining algorithms such as
+// priority(p_eft) or first-applicable, the allow and deny constants, and the !, && and || operators
+// with parentheses.
+func ParseEffect(text string) (*Expression, error) {
+	if expr, ok := expressions.Load(text); ok {
+		return expr.(*Expression), nil
+	}
+
+	p := &effectParser{text: text}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, p.errorf("unexpected %q", p.tokens[p.pos])
+	}
+
+	expr := &Expression{text: text, root: root}
+	expressions.Store(text, expr)
+	return expr, nil
+}
+
+// Uses reports whether the expression calls the combining algorithm name.
+func (expr *Expression) Uses(name string) bool {
+	return expr.root.uses(name)
+}
+
+// String returns the text the expression was parsed from.
+func (expr *Expression) String() string {
+	return expr.text
+}
+
+// Merge merges the effects of the rules evaluated so far like Effector.MergeEffects.
+func (expr *Expression) Merge(effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int) {
+	v := expr.root.eval(&mergeState{
+		effects:     effects,
+		matches:     matches,
+		policyIndex: policyIndex,
+		final:       policyIndex == policyLength-1,
+		length:      policyLength,
+	})
+	switch {
+	case !v.known:
+		return Indeterminate, -1
+	case v.truth:
+		return Allow, v.index
+	case v.deny:
+		return Deny, v.index
+	case v.notApplicable:
+		return NotApplicable, v.index
+	default:
+		return Indeterminate, v.index
+	}
+}
+
+type mergeState struct {
+	effects     []Effect
+	matches     []float64
+	policyIndex int
+	final       bool
+	length      int
+}
+
+// value is the three-valued result of a node: unknown until enough rules are evaluated. A false
+// value caused by a deny rule makes the decision Deny rather than Indeterminate, and one caused by
+// no rule applying makes it NotApplicable.
+type value struct {
+	known         bool
+	truth         bool
+	deny          bool
+	notApplicable bool
+	index         int
+}
+
+var unknown = value{index: -1}
+
+type effectNode interface {
+	eval(s *mergeState) value
+	uses(name string) bool
+}
+
+// quantifierNode is some(where (p_eft == effect)) or all(where (p_eft == effect)). Until the last
+// rule only the current rule is looked at, so that merging stays linear in the number of rules.
+type quantifierNode struct {
+	all    bool
+	effect Effect
+}
+
+func (n *quantifierNode) eval(s *mergeState) value {
+	if !s.final {
+		i := s.policyIndex
+		if s.matches[i] == 0 {
+			return unknown
+		}
+		if !n.all && s.effects[i] == n.effect {
+			return value{known: true, truth: true, deny: n.effect == Deny, index: i}
+		}
+		if n.all && s.effects[i] != n.effect {
+			return value{known: true, deny: s.effects[i] == Deny, index: i}
+		}
+		return unknown
+	}
+
+	first := -1
+	for i := 0; i < s.length; i++ {
+		if s.matches[i] == 0 {
+			continue
+		}
+		if s.effects[i] == n.effect {
+			if !n.all {
+				return value{known: true, truth: true, deny: n.effect == Deny, index: i}
+			}
+			if first == -1 {
+				first = i
+			}
+		} else if n.all {
+			return value{known: true, deny: s.effects[i] == Deny, index: i}
+		}
+	}
+	if first != -1 {
+		return value{known: true, truth: true, deny: n.effect == Deny, index: first}
+	}
+	return value{known: true, index: -1}
+}
+
+func (n *quantifierNode) uses(name string) bool {
+	return false
+}
+
+type algorithmNode struct {
+	name string
+	fn   CombiningAlgorithm
+}
+
+func (n *algorithmNode) eval(s *mergeState) value {
+	effect, index := n.fn(s.effects, s.matches, s.policyIndex, s.length)
+	switch effect {
+	case Allow:
+		return value{known: true, truth: true, index: index}
+	case Deny:
+		return value{known: true, deny: true, index: index}
+	}
+	if s.final {
+		return value{known: true, notApplicable: effect == NotApplicable, index: index}
+	}
+	return unknown
+}
+
+func (n *algorithmNode) uses(name string) bool {
+	return n.name == name
+}
+
+type constantNode struct {
+	effect Effect
+}
+
+func (n *constantNode) eval(s *mergeState) value {
+	return value{known: true, truth: n.effect == Allow, deny: n.effect == Deny, index: -1}
+}
+
+func (n *constantNode) uses(name string) bool {
+	return false
+}
+
+type notNode struct {
+	node effectNode
+}
+
+func (n *notNode) eval(s *mergeState) value {
+	v := n.node.eval(s)
+	if !v.known {
+		return v
+	}
+	if v.truth {
+		return value{known: true, deny: v.deny, index: v.index}
+	}
+	return value{known: true, truth: true, index: v.index}
+}
+
+func (n *notNode) uses(name string) bool {
+	return n.node.uses(name)
+}
+
+type andNode struct {
+	left, right effectNode
+}
+
+func (n *andNode) eval(s *mergeState) value {
+	l, r := n.left.eval(s), n.right.eval(s)
+	switch {
+	case l.known && !l.truth:
+		return l
+	case r.known && !r.truth:
+		return r
+	case l.known && r.known:
+		return value{known: true, truth: true, index: firstIndex(l.index, r.index)}
+	}
+	return unknown
+}
+
+func (n *andNode) uses(name string) bool {
+	return n.left.uses(name) || n.right.uses(name)
+}
+
+type orNode struct {
+	left, right effectNode
+}
+
+func (n *orNode) eval(s *mergeState) value {
+	l, r := n.left.eval(s), n.right.eval(s)
+	switch {
+	case l.known && l.truth:
+		return l
+	case r.known && r.truth:
+		return r
+	case l.known && r.known:
+		return value{known: true, deny: l.deny || r.deny, notApplicable: l.notApplicable && r.notApplicable, index: firstIndex(l.index, r.index)}
+	}
+	return unknown
+}
+
+func (n *orNode) uses(name string) bool {
+	return n.left.uses(name) || n.right.uses(name)
+}
+
+func firstIndex(a, b int) int {
+	if a != -1 {
+		return a
+	}
+	return b
+}
+
+type effectParser struct {
+	text   string
+	tokens []string
+	pos    int
+}
+
+func (p *effectParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid policy effect %q: %s", p.text, fmt.Sprintf(format, args...))
+}
+
+func (p *effectParser) tokenize() error {
+	s := p.text
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')' || c == '!':
+			if strings.HasPrefix(s[i:], "!=") {
+				return p.errorf("unexpected \"!=\"")
+			}
+			p.tokens = append(p.tokens, string(c))
+			i++
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||") || strings.HasPrefix(s[i:], "=="):
+			p.tokens = append(p.tokens, s[i:i+2])
+			i += 2
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			// names of combining algorithms may contain hyphens, e.g. "first-applicable".
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] == '-' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			p.tokens = append(p.tokens, s[i:j])
+			i = j
+		default:
+			return p.errorf("unexpected %q", string(c))
+		}
+	}
+	if len(p.tokens) == 0 {
+		return p.errorf("empty expression")
+	}
+	return nil
+}
+
+func (p *effectParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *effectParser) next() string {
+	token := p.peek()
+	if token != "" {
+		p.pos++
+	}
+	return token
+}
+
+func (p *effectParser) expect(token string) error {
+	if got := p.next(); got != token {
+		if got == "" {
+			return p.errorf("expected %q at end", token)
+		}
+		return p.errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+func (p *effectParser) parseOr() (effectNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *effectParser) parseAnd() (effectNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *effectParser) parseUnary() (effectNode, error) {
+	token := p.next()
+	switch token {
+	case "!":
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node: node}, nil
+	case "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	case "allow":
+		return &constantNode{effect: Allow}, nil
+	case "deny":
+		return &constantNode{effect: Deny}, nil
+	case "some", "all":
+		return p.parseQuantifier(token == "all")
+	case "":
+		return nil, p.errorf("unexpected end")
+	}
+
+	if !isIdentifier(token) {
+		return nil, p.errorf("unexpected %q", token)
+	}
+	fn, ok := getCombiningAlgorithm(token)
+	if !ok {
+		return nil, p.errorf("unknown combining algorithm %q", token)
+	}
+	node := &algorithmNode{name: token, fn: fn}
+	if p.peek() != "(" {
+		return node, nil
+	}
+	p.next()
+	if err := p.parseEft(); err != nil {
+		return nil, err
+	}
+	return node, p.expect(")")
+}
+
+// parseQuantifier parses the rest of some(where (p_eft == allow)), the parentheses around the
+// condition are optional.
+func (p *effectParser) parseQuantifier(all bool) (effectNode, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if err := p.expect("where"); err != nil {
+		return nil, err
+	}
+	parenthesized := p.peek() == "("
+	if parenthesized {
+		p.next()
+	}
+	if err := p.parseEft(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("=="); err != nil {
+		return nil, err
+	}
+	node := &quantifierNode{all: all}
+	switch effect := p.next(); effect {
+	case "allow":
+		node.effect = Allow
+	case "deny":
+		node.effect = Deny
+	default:
+		return nil, p.errorf("expected allow or deny, got %q", effect)
+	}
+	if parenthesized {
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	return node, p.expect(")")
+}
+
+// parseEft parses the effect token of a policy type, e.g. "p_eft" or "p2_eft".
+func (p *effectParser) parseEft() error {
+	token := p.next()
+	if !strings.HasSuffix(token, "_eft") || !isIdentifier(token) {
+		return p.errorf("expected the effect of a policy type, got %q", token)
+	}
+	return nil
+}
+
+func isIdentifier(token string) bool {
+	return token != "" && (token[0] == '_' || unicode.IsLetter(rune(token[0])))
+}
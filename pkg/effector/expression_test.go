@@ -0,0 +1,121 @@
+package effector
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"testing"
+)
+
+// testMerge merges the matched rules one by one like the enforcer does, until a decision is made.
+func testMerge(t *testing.T, expr string, effects []Effect, matches []float64, res Effect, explainIndex int) {
+	t.Helper()
+	e := NewDefaultEffector()
+	effect, index := Indeterminate, -1
+	for i := range effects {
+		var err error
+		effect, index, err = e.MergeEffects(expr, effects, matches, i, len(effects))
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		if effect != Indeterminate {
+			break
+		}
+	}
+	if effect != res || index != explainIndex {
+		t.Errorf("%s: %v, %d, supposed to be %v, %d", expr, effect, index, res, explainIndex)
+	}
+}
+
+func TestMergeEffects(t *testing.T) {
+	effects := []Effect{Allow, Deny, Allow, Deny}
+
+	testMerge(t, "some(where (p_eft == allow))", effects, []float64{0, 0, 1, 0}, Allow, 2)
+	testMerge(t, "some(where (p_eft == allow))", effects, []float64{0, 1, 0, 0}, Indeterminate, -1)
+	testMerge(t, "!some(where (p_eft == deny))", effects, []float64{1, 0, 0, 0}, Allow, -1)
+	testMerge(t, "!some(where (p_eft == deny))", effects, []float64{1, 0, 0, 1}, Deny, 3)
+	testMerge(t, "some(where (p_eft == allow)) && !some(where (p_eft == deny))", effects, []float64{1, 0, 1, 0}, Allow, 0)
+	testMerge(t, "some(where (p_eft == allow)) && !some(where (p_eft == deny))", effects, []float64{1, 0, 0, 1}, Deny, 3)
+	testMerge(t, "some(where (p_eft == allow)) && !some(where (p_eft == deny))", effects, []float64{0, 0, 0, 0}, Indeterminate, -1)
+	testMerge(t, "priority(p_eft) || deny", effects, []float64{0, 1, 1, 0}, Deny, 1)
+	testMerge(t, "priority(p_eft) || deny", effects, []float64{0, 0, 1, 1}, Allow, 2)
+	testMerge(t, "all(where (p_eft == allow))", effects, []float64{1, 0, 1, 0}, Allow, 0)
+	testMerge(t, "all(where (p_eft == allow))", effects, []float64{1, 1, 1, 0}, Deny, 1)
+
+	// whitespace and parentheses don't matter.
+	testMerge(t, "  some( where(p2_eft==allow) )&&!(some(where p2_eft == deny))", effects, []float64{1, 0, 1, 0}, Allow, 0)
+	testMerge(t, "(some(where (p_eft == allow)) || some(where (p_eft == deny))) && !all(where (p_eft == deny))", effects, []float64{0, 1, 0, 0}, Deny, 1)
+}
+
+func TestParseEffect(t *testing.T) {
+	for _, expr := range []string{"", "some(where (p_eft == allow)", "some(where (p_eft = allow))", "some(p_eft == allow)",
+		"some(where (p_eft == maybe))", "some(where (p_sub == allow))", "unknown(p_eft)", "allow deny", "allow ||", "!"} {
+		if _, err := ParseEffect(expr); err == nil {
+			t.Errorf("%q should not be parsed", expr)
+		}
+	}
+
+	expr, err := ParseEffect("subjectPriority(p_eft) || deny")
+	if err != nil || !expr.Uses("subjectPriority") || expr.Uses("priority") {
+		t.Errorf("subjectPriority(p_eft) || deny: %v", err)
+	}
+}
+
+func TestRegisterCombiningAlgorithm(t *testing.T) {
+	lastApplicable := func(effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int) {
+		if policyIndex != policyLength-1 {
+			return Indeterminate, -1
+		}
+		for i := policyLength - 1; i >= 0; i-- {
+			if matches[i] != 0 {
+				return effects[i], i
+			}
+		}
+		return Indeterminate, -1
+	}
+	RegisterCombiningAlgorithm("lastApplicable", lastApplicable)
+
+	testMerge(t, "lastApplicable(p_eft)", []Effect{Allow, Deny, Allow, Deny}, []float64{1, 1, 1, 0}, Allow, 2)
+	testMerge(t, "lastApplicable(p_eft) || deny", []Effect{Allow, Deny, Allow, Deny}, []float64{0, 0, 0, 0}, Deny, -1)
+}
+
+func TestCombiningAlgorithms(t *testing.T) {
+	effects := []Effect{Allow, Deny, Indeterminate, Allow}
+
+	testMerge(t, "first-applicable", effects, []float64{0, 1, 0, 1}, Deny, 1)
+	testMerge(t, "first-applicable", effects, []float64{0, 0, 0, 1}, Allow, 3)
+	testMerge(t, "first-applicable", effects, []float64{0, 0, 1, 1}, Indeterminate, 2)
+	testMerge(t, "first-applicable", effects, []float64{0, 0, 0, 0}, NotApplicable, -1)
+
+	testMerge(t, "deny-unless-permit", effects, []float64{0, 1, 0, 1}, Allow, 3)
+	testMerge(t, "deny-unless-permit", effects, []float64{0, 0, 0, 0}, Deny, -1)
+
+	testMerge(t, "permit-unless-deny", effects, []float64{1, 1, 0, 0}, Deny, 1)
+	testMerge(t, "permit-unless-deny(p_eft)", effects, []float64{0, 0, 0, 0}, Allow, -1)
+
+	testMerge(t, "only-one-applicable", effects, []float64{0, 1, 0, 0}, Deny, 1)
+	testMerge(t, "only-one-applicable", effects, []float64{1, 0, 0, 1}, Indeterminate, -1)
+	testMerge(t, "only-one-applicable", effects, []float64{0, 0, 0, 0}, NotApplicable, -1)
+
+	testMerge(t, "ordered-deny-overrides", effects, []float64{1, 1, 0, 1}, Deny, 1)
+	testMerge(t, "ordered-deny-overrides", effects, []float64{0, 0, 0, 1}, Allow, 3)
+	testMerge(t, "ordered-deny-overrides", effects, []float64{1, 0, 1, 0}, Indeterminate, -1)
+	testMerge(t, "ordered-deny-overrides", effects, []float64{0, 0, 0, 0}, NotApplicable, -1)
+}
@@ -0,0 +1,124 @@
+package effector
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// The XACML combining algorithms below merge the matched rules in policy order. A matched rule
+// whose effect is neither allow nor deny evaluates to Indeterminate.
+
+// firstApplicable lets the first matched rule decide.
+func firstApplicable(effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int) {
+	final := policyIndex == policyLength-1
+	if !final && matches[policyIndex] == 0 {
+		return Indeterminate, -1
+	}
+	for i := 0; i <= policyIndex; i++ {
+		if matches[i] != 0 {
+			return effects[i], i
+		}
+	}
+	return NotApplicable, -1
+}
+
+// denyUnlessPermit allows if any matched rule allows, and denies otherwise.
+func denyUnlessPermit(effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int) {
+	if matches[policyIndex] != 0 && effects[policyIndex] == Allow {
+		return Allow, policyIndex
+	}
+	if policyIndex != policyLength-1 {
+		return Indeterminate, -1
+	}
+	if i := firstMatched(effects, matches, Allow); i != -1 {
+		return Allow, i
+	}
+	return Deny, -1
+}
+
+// permitUnlessDeny denies if any matched rule denies, and allows otherwise.
+func permitUnlessDeny(effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int) {
+	if matches[policyIndex] != 0 && effects[policyIndex] == Deny {
+		return Deny, policyIndex
+	}
+	if policyIndex != policyLength-1 {
+		return Indeterminate, -1
+	}
+	if i := firstMatched(effects, matches, Deny); i != -1 {
+		return Deny, i
+	}
+	return Allow, -1
+}
+
+// onlyOneApplicable takes the effect of the only matched rule, and is Indeterminate when several rules match.
+func onlyOneApplicable(effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int) {
+	if policyIndex != policyLength-1 {
+		return Indeterminate, -1
+	}
+	applicable := -1
+	for i := 0; i < policyLength; i++ {
+		if matches[i] == 0 {
+			continue
+		}
+		if applicable != -1 {
+			return Indeterminate, -1
+		}
+		applicable = i
+	}
+	if applicable == -1 {
+		return NotApplicable, -1
+	}
+	return effects[applicable], applicable
+}
+
+// orderedDenyOverrides denies on the first matched deny rule, and otherwise allows on the first
+// matched allow rule. A matched Indeterminate rule makes the decision Indeterminate unless a rule denies.
+func orderedDenyOverrides(effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int) {
+	if matches[policyIndex] != 0 && effects[policyIndex] == Deny {
+		return Deny, policyIndex
+	}
+	if policyIndex != policyLength-1 {
+		return Indeterminate, -1
+	}
+	if i := firstMatched(effects, matches, Deny); i != -1 {
+		return Deny, i
+	}
+	result, index := NotApplicable, -1
+	for i := 0; i < policyLength; i++ {
+		if matches[i] == 0 {
+			continue
+		}
+		switch {
+		case effects[i] == Indeterminate:
+			result, index = Indeterminate, -1
+		case effects[i] == Allow && result == NotApplicable:
+			result, index = Allow, i
+		}
+	}
+	return result, index
+}
+
+// firstMatched returns the index of the first matched rule with the given effect, or -1.
+func firstMatched(effects []Effect, matches []float64, effect Effect) int {
+	for i := range effects {
+		if matches[i] != 0 && effects[i] == effect {
+			return i
+		}
+	}
+	return -1
+}
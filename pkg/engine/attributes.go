@@ -0,0 +1,187 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// AttributeProvider returns the attributes of the request value identified by id, it is a Policy Information Point.
+type AttributeProvider func(ctx context.Context, id string) (map[string]interface{}, error)
+
+// AddAttributeProvider registers the provider of the attributes of the request token, like "sub".
+// A matcher referencing an attribute of a string request value, like "r.sub.region", gets the attributes
+// of the value from the provider. They are fetched once per Enforce call, and the errors of the provider
+// are the errors of Enforce. The decisions of the CachedEnforcer don't follow the changes of the attributes.
+func (e *Enforcer) AddAttributeProvider(token string, provider AttributeProvider) {
+	if e.attributeProviders == nil {
+		e.attributeProviders = make(map[string]AttributeProvider)
+	}
+	e.attributeProviders[token] = provider
+}
+
+// fetchedAttributes is the result of an attribute provider.
+type fetchedAttributes struct {
+	attributes map[string]interface{}
+	err        error
+}
+
+// provideAttributes returns the attributes of the request value id of the request token from its provider,
+// memoised in the parameters. ok is false when the token has no provider.
+func (p enforceParameters) provideAttributes(token string, id string) (attributes map[string]interface{}, ok bool, err error) {
+	name := token[strings.IndexByte(token, '_')+1:]
+	provider, ok := p.providers[name]
+	if !ok {
+		return nil, false, nil
+	}
+
+	key := name + "$$" + id
+	fetched, ok := p.fetched[key]
+	if !ok {
+		fetched.attributes, fetched.err = provider(p.ctx, id)
+		if fetched.err != nil {
+			fetched.err = fmt.Errorf("attribute provider of %s: %w", name, fetched.err)
+		}
+		p.fetched[key] = fetched
+	}
+	return fetched.attributes, true, fetched.err
+}
+
+// jsonData returns the JSON document of a request value: a string holding a JSON object, []byte or json.RawMessage.
+func jsonData(rval interface{}) ([]byte, bool) {
+	switch typedVal := rval.(type) {
+	case string:
+		if !strings.HasPrefix(strings.TrimSpace(typedVal), "{") {
+			return nil, false
+		}
+		return []byte(typedVal), true
+	case json.RawMessage:
+		return typedVal, true
+	case []byte:
+		return typedVal, true
+	default:
+		return nil, false
+	}
+}
+
+// newRequestObjects returns the memo of the decoded JSON values of the request, it is nil when the request has no JSON value.
+func newRequestObjects(rvals []interface{}) map[string]interface{} {
+	for _, rval := range rvals {
+		if _, ok := jsonData(rval); ok {
+			return make(map[string]interface{})
+		}
+	}
+	return nil
+}
+
+// requestObject returns the decoded JSON value of the request token. The value is decoded on the first
+// access to one of its attributes and memoised in the parameters. ok is false when it isn't a JSON object.
+func (p enforceParameters) requestObject(token string, rval interface{}) (object interface{}, ok bool) {
+	if p.rObjects == nil {
+		return nil, false
+	}
+
+	object, ok = p.rObjects[token]
+	if !ok {
+		if data, isJSON := jsonData(rval); isJSON {
+			var value map[string]interface{}
+			if err := json.Unmarshal(data, &value); err == nil && value != nil {
+				object = value
+			}
+		}
+		p.rObjects[token] = object
+	}
+	return object, object != nil
+}
+
+// getAttribute returns the attribute at path of a request value, the name of the value is used in the errors.
+// Maps are looked up by key, a missing key gives nil, and structs by field name or JSON name.
+// Numbers are returned as float64, like the numbers of the matcher.
+func getAttribute(name string, value interface{}, path []string) (interface{}, error) {
+	attribute := func(n int) string {
+		return strings.Join(append([]string{name}, path[:n]...), ".")
+	}
+	for i, key := range path {
+		v := reflect.ValueOf(value)
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, fmt.Errorf("%s is nil", attribute(i))
+			}
+			v = v.Elem()
+		}
+
+		switch v.Kind() {
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return nil, fmt.Errorf("%s has no string keys", attribute(i))
+			}
+			field := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+			if !field.IsValid() {
+				return nil, nil
+			}
+			value = field.Interface()
+		case reflect.Struct:
+			field, ok := structField(v, key)
+			if !ok {
+				return nil, fmt.Errorf("%s has no attribute %s", attribute(i), key)
+			}
+			value = field.Interface()
+		default:
+			return nil, fmt.Errorf("%s has no attributes", attribute(i))
+		}
+	}
+
+	return attributeValue(value), nil
+}
+
+// structField returns the exported field of the struct with the given name or JSON name.
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	if f, ok := t.FieldByName(name); ok && f.PkgPath == "" {
+		return v.FieldByIndex(f.Index), true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath == "" && strings.Split(f.Tag.Get("json"), ",")[0] == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// attributeValue converts the numbers to float64.
+func attributeValue(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32:
+		return v.Float()
+	default:
+		return value
+	}
+}
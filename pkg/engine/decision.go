@@ -0,0 +1,103 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bhojpur/policy/pkg/effector"
+)
+
+// Decision is the full outcome of an enforcement.
+type Decision struct {
+	// Effect is Allow or Deny when a rule decided the request, NotApplicable when no rule matched it,
+	// and Indeterminate when the rules matched could not decide it or the evaluation failed.
+	Effect effector.Effect `json:"effect"`
+	// Rule is the policy rule that decided the request, if any.
+	Rule []string `json:"rule,omitempty"`
+	// Matched holds the policy rules found to match the request, in policy order. The evaluation stops
+	// once the policy effect is decided, so it doesn't list the matching rules that were not evaluated.
+	Matched [][]string `json:"matched,omitempty"`
+	// Obligations holds the distinct obligations of the matched rules that have the effect of the decision.
+	Obligations []string `json:"obligations,omitempty"`
+	// Reason explains the effect.
+	Reason string `json:"reason"`
+}
+
+// Allowed returns whether the decision allows the request, the result Enforce returns.
+func (d *Decision) Allowed() bool {
+	return d.Effect == effector.Allow
+}
+
+// newDecision builds the decision of an enforcement from the merged effect and the per rule results.
+// effects and matches are nil when the matcher doesn't use the policy.
+func newDecision(policy [][]string, obligationsIndex int, effect effector.Effect, explainIndex int, effects []effector.Effect, matches []float64) Decision {
+	d := Decision{Effect: effect}
+	for i, match := range matches {
+		if match != 0 {
+			d.Matched = append(d.Matched, policy[i])
+		}
+	}
+	if explainIndex != -1 && explainIndex < len(policy) && matches != nil {
+		d.Rule = policy[explainIndex]
+	}
+	if obligationsIndex != -1 && (effect == effector.Allow || effect == effector.Deny) {
+		d.Obligations = collectObligations(policy, obligationsIndex, effects, matches, effect)
+	}
+
+	switch {
+	case effect == effector.Indeterminate && matches == nil:
+		d.Effect = effector.NotApplicable
+		d.Reason = "the matcher did not match the request"
+	case effect == effector.Indeterminate && len(d.Matched) == 0:
+		d.Effect = effector.NotApplicable
+		d.Reason = "no policy rule matched the request"
+	case effect == effector.Indeterminate:
+		d.Reason = fmt.Sprintf("%d matched policy rules did not decide the request", len(d.Matched))
+	case effect == effector.NotApplicable:
+		d.Reason = "no policy rule applies to the request"
+	case d.Rule != nil:
+		d.Reason = fmt.Sprintf("%s by policy rule %v", effect, d.Rule)
+	default:
+		d.Reason = fmt.Sprintf("%s by the matcher", effect)
+	}
+	return d
+}
+
+// collectObligations returns the distinct obligations of the matched rules with the given effect, in policy order.
+func collectObligations(policy [][]string, index int, effects []effector.Effect, matches []float64, effect effector.Effect) []string {
+	obligations := []string{}
+	seen := make(map[string]bool)
+	for i, match := range matches {
+		if match == 0 || effects[i] != effect {
+			continue
+		}
+		for _, obligation := range strings.Split(policy[i][index], ";") {
+			obligation = strings.TrimSpace(obligation)
+			if obligation != "" && !seen[obligation] {
+				seen[obligation] = true
+				obligations = append(obligations, obligation)
+			}
+		}
+	}
+	return obligations
+}
@@ -21,9 +21,14 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/Knetic/govaluate"
 	"github.com/bhojpur/policy/pkg/effector"
@@ -53,10 +58,41 @@ type Enforcer struct {
 	autoBuildRoleLinks   bool
 	autoNotifyWatcher    bool
 	autoNotifyDispatcher bool
+	policyIndex          bool
+
+	// matcherMap caches compiled matcher expressions, keyed by the escaped matcher text.
+	matcherMap sync.Map
+	// evalMap caches compiled matchers with eval() sub-rules, keyed by evalKey.
+	evalMap sync.Map
+	// equalityMap caches the policy tokens a matcher compares for equality, keyed by equalityKey.
+	equalityMap sync.Map
+	// compiled counts the entries stored in the three maps above, it is bounded by maxCompiledMatchers.
+	compiled int32
+
+	// attributeProviders are the attribute providers of the request tokens, keyed by token name.
+	attributeProviders map[string]AttributeProvider
+	// clock is the clock of the time functions, the system clock when nil.
+	clock util.Clock
+	// strict rejects the models with validation errors.
+	strict bool
 
 	logger log.Logger
 }
 
+// evalKey identifies a matcher compiled for a single policy row.
+type evalKey struct {
+	ptype   string
+	rule    string
+	matcher string
+}
+
+// equalityKey identifies the equality comparisons of a matcher between a request and a policy type.
+type equalityKey struct {
+	rtype   string
+	ptype   string
+	matcher string
+}
+
 // EnforceContext is used as the first element of the parameter "rvals" in method "enforce"
 type EnforceContext struct {
 	RType string
@@ -65,6 +101,11 @@ type EnforceContext struct {
 	MType string
 }
 
+type strictMode struct{}
+
+// Strict enables the strict mode of the enforcer when given to NewEnforcer, see EnableStrictMode.
+var Strict interface{} = strictMode{}
+
 // NewEnforcer creates an enforcer via file or DB.
 //
 // File:
@@ -76,9 +117,21 @@ type EnforceContext struct {
 // 	a := mysqladapter.NewDBAdapter("mysql", "mysql_username:mysql_password@tcp(127.0.0.1:3306)/")
 // 	e := bhojpur.NewEnforcer("path/to/basic_model.conf", a)
 //
+// Strict mode, rejecting a model with validation errors:
+//
+// 	e := bhojpur.NewEnforcer("path/to/basic_model.conf", "path/to/basic_policy.csv", bhojpur.Strict)
+//
 func NewEnforcer(params ...interface{}) (*Enforcer, error) {
 	e := &Enforcer{logger: &log.DefaultLogger{}}
 
+	for i := 0; i < len(params); i++ {
+		if _, ok := params[i].(strictMode); ok {
+			e.strict = true
+			params = append(params[:i:i], params[i+1:]...)
+			i--
+		}
+	}
+
 	parsedParamLen := 0
 	paramLen := len(params)
 	if paramLen >= 1 {
@@ -171,10 +224,15 @@ func (e *Enforcer) InitWithAdapter(modelPath string, adapter persist.Adapter) er
 func (e *Enforcer) InitWithModelAndAdapter(m model.Model, adapter persist.Adapter) error {
 	e.adapter = adapter
 
+	fm := e.loadFunctionMap()
+	if err := e.checkModel(m, fm); err != nil {
+		return err
+	}
+
 	e.model = m
 	m.SetLogger(e.logger)
 	e.model.PrintModel()
-	e.fm = model.LoadFunctionMap()
+	e.fm = fm
 
 	e.initialize()
 
@@ -210,20 +268,29 @@ func (e *Enforcer) initialize() {
 	e.autoNotifyWatcher = true
 	e.autoNotifyDispatcher = true
 	e.initRmMap()
+	e.invalidateMatcherMap()
+	if e.policyIndex {
+		e.buildPolicyIndex()
+	}
 }
 
 // LoadModel reloads the model from the model CONF file.
 // Because the policy is attached to a model, so the policy is invalidated and needs to be reloaded by calling LoadPolicy().
 func (e *Enforcer) LoadModel() error {
-	var err error
-	e.model, err = model.NewModelFromFile(e.modelPath)
+	m, err := model.NewModelFromFile(e.modelPath)
 	if err != nil {
 		return err
 	}
+	fm := e.loadFunctionMap()
+	if err := e.checkModel(m, fm); err != nil {
+		return err
+	}
+
+	e.model = m
 	e.model.SetLogger(e.logger)
 
 	e.model.PrintModel()
-	e.fm = model.LoadFunctionMap()
+	e.fm = fm
 
 	e.initialize()
 
@@ -236,14 +303,51 @@ func (e *Enforcer) GetModel() model.Model {
 }
 
 // SetModel sets the current model.
+// Unlike LoadModel, it doesn't type-check the matchers, use CheckModel to check the model beforehand.
 func (e *Enforcer) SetModel(m model.Model) {
 	e.model = m
-	e.fm = model.LoadFunctionMap()
+	e.fm = e.loadFunctionMap()
 
 	e.model.SetLogger(e.logger)
 	e.initialize()
 }
 
+// CheckModel type-checks the function calls of the matchers of m against the functions SetModel would
+// set along with it, and validates the whole model in strict mode.
+func (e *Enforcer) CheckModel(m model.Model) error {
+	return e.checkModel(m, e.loadFunctionMap())
+}
+
+// checkModel type-checks the function calls of the matchers of the model, and validates the whole model
+// in strict mode.
+func (e *Enforcer) checkModel(m model.Model, fm model.FunctionMap) error {
+	if e.strict {
+		return m.ValidateWithFunctions(fm).Err()
+	}
+	return m.CheckFunctionCalls(fm.GetSignature)
+}
+
+// Validate validates the current model with the functions of the enforcer, see model.Validate.
+func (e *Enforcer) Validate() model.Diagnostics {
+	return e.model.ValidateWithFunctions(e.fm)
+}
+
+// loadFunctionMap loads the initial function map, with the time functions reading the clock of the enforcer.
+func (e *Enforcer) loadFunctionMap() model.FunctionMap {
+	fm := model.LoadFunctionMap()
+	if e.clock != nil {
+		fm.SetClock(e.clock)
+	}
+	return fm
+}
+
+// SetClock sets the clock of the time functions of the matchers, like timeInRange, so that tests can freeze time.
+func (e *Enforcer) SetClock(clock util.Clock) {
+	e.clock = clock
+	e.fm.SetClock(clock)
+	e.invalidateMatcherMap()
+}
+
 // GetAdapter gets the current adapter.
 func (e *Enforcer) GetAdapter() persist.Adapter {
 	return e.adapter
@@ -268,6 +372,7 @@ func (e *Enforcer) GetRoleManager() rbac.RoleManager {
 // SetRoleManager sets the current role manager.
 func (e *Enforcer) SetRoleManager(rm rbac.RoleManager) {
 	e.rmMap["g"] = rm
+	e.invalidateMatcherMap()
 }
 
 // SetEffector sets the current effector.
@@ -282,6 +387,7 @@ func (e *Enforcer) ClearPolicy() {
 		return
 	}
 	e.model.ClearPolicy()
+	e.invalidateMatcherMap()
 }
 
 // LoadPolicy reloads the policy from file/database.
@@ -325,6 +431,10 @@ func (e *Enforcer) LoadPolicy() error {
 		}
 	}
 	e.model = newModel
+	e.invalidateMatcherMap()
+	if e.policyIndex {
+		e.buildPolicyIndex()
+	}
 	return nil
 }
 
@@ -347,6 +457,10 @@ func (e *Enforcer) loadFilteredPolicy(filter interface{}) error {
 	}
 
 	e.initRmMap()
+	e.invalidateMatcherMap()
+	if e.policyIndex {
+		e.buildPolicyIndex()
+	}
 	e.model.PrintPolicy()
 	if e.autoBuildRoleLinks {
 		err := e.BuildRoleLinks()
@@ -413,6 +527,12 @@ func (e *Enforcer) EnableEnforce(enable bool) {
 	e.enabled = enable
 }
 
+// EnableStrictMode controls whether the models are validated when they are loaded, a model with
+// validation errors is then rejected. See Strict to enable it when creating the enforcer.
+func (e *Enforcer) EnableStrictMode(enable bool) {
+	e.strict = enable
+}
+
 // EnableLog changes whether Bhojpur Policy will log messages to the Logger.
 func (e *Enforcer) EnableLog(enable bool) {
 	e.logger.EnableLog(enable)
@@ -438,6 +558,67 @@ func (e *Enforcer) EnableAutoSave(autoSave bool) {
 	e.autoSave = autoSave
 }
 
+// EnablePolicyIndex controls whether the policy rules are indexed by the tokens the matchers compare
+// for equality with the request, e.g. "r.obj == p.obj", so that only the candidate rules are evaluated.
+func (e *Enforcer) EnablePolicyIndex(enable bool) {
+	e.policyIndex = enable
+	if enable {
+		e.buildPolicyIndex()
+		return
+	}
+	for _, ast := range e.model["p"] {
+		ast.ClearIndex()
+	}
+}
+
+// buildPolicyIndex indexes every policy type by the equality tokens of its matcher.
+func (e *Enforcer) buildPolicyIndex() {
+	for mType, ast := range e.model["m"] {
+		suffix := strings.TrimPrefix(mType, "m")
+		pAst, ok := e.model["p"]["p"+suffix]
+		if !ok {
+			continue
+		}
+		var tokens []string
+		for token := range util.GetEqualityTokens(ast.Value, "r"+suffix, "p"+suffix) {
+			tokens = append(tokens, token)
+		}
+		if len(tokens) == 0 {
+			pAst.ClearIndex()
+			continue
+		}
+		_ = pAst.BuildIndex(tokens...)
+	}
+}
+
+// getIndexedPolicy returns the positions of the rules of pType that can match the request, using
+// the policy index. ok is false if the index cannot be used for the matcher or the request.
+func (e *Enforcer) getIndexedPolicy(expString string, rType string, pType string, rTokens map[string]int, rvals []interface{}) ([]int, bool) {
+	if !e.policyIndex {
+		return nil, false
+	}
+
+	key := equalityKey{rtype: rType, ptype: pType, matcher: expString}
+	tokens, ok := e.equalityMap.Load(key)
+	if !ok {
+		tokens = util.GetEqualityTokens(expString, rType, pType)
+		e.storeCompiled(&e.equalityMap, key, tokens)
+	}
+
+	values := make(map[string]string)
+	for pToken, rToken := range tokens.(map[string]string) {
+		i, found := rTokens[rToken]
+		if !found {
+			continue
+		}
+		// only string request values compare equal to a policy value
+		if value, isString := rvals[i].(string); isString {
+			values[pToken] = value
+		}
+	}
+	return e.model["p"][pType].GetIndexedPolicy(values)
+}
+
 // EnableAutoBuildRoleLinks controls whether to rebuild the role inheritance relations when a role is added or deleted.
 func (e *Enforcer) EnableAutoBuildRoleLinks(autoBuildRoleLinks bool) {
 	e.autoBuildRoleLinks = autoBuildRoleLinks
@@ -452,14 +633,166 @@ func (e *Enforcer) BuildRoleLinks() error {
 		}
 	}
 
+	defer e.invalidateChangedRoleManagers(e.roleManagers())
 	return e.model.BuildRoleLinks(e.rmMap)
 }
 
 // BuildIncrementalRoleLinks provides incremental build the role inheritance relations.
 func (e *Enforcer) BuildIncrementalRoleLinks(op model.PolicyOp, ptype string, rules [][]string) error {
+	defer e.invalidateChangedRoleManagers(e.roleManagers())
 	return e.model.BuildIncrementalRoleLinks(e.rmMap, op, "g", ptype, rules)
 }
 
+// roleManagers returns the role managers the g() functions of the model are bound to.
+func (e *Enforcer) roleManagers() map[string]rbac.RoleManager {
+	rms := make(map[string]rbac.RoleManager, len(e.model["g"]))
+	for ptype, ast := range e.model["g"] {
+		rms[ptype] = ast.RM
+	}
+	return rms
+}
+
+// invalidateChangedRoleManagers drops the compiled matchers if the g() functions of the model were bound
+// to other role managers than rms. The links themselves are looked up at every enforcement.
+func (e *Enforcer) invalidateChangedRoleManagers(rms map[string]rbac.RoleManager) {
+	for ptype, ast := range e.model["g"] {
+		if ast.RM != rms[ptype] {
+			e.invalidateMatcherMap()
+			return
+		}
+	}
+}
+
+// maxCompiledMatchers bounds the number of compiled matchers an enforcer keeps, since the matchers of
+// EnforceWithMatcher may be built for every request. Once it is reached, they are all dropped.
+const maxCompiledMatchers = 10000
+
+// storeCompiled caches a compiled matcher in m, dropping the cached ones first when there are too many.
+func (e *Enforcer) storeCompiled(m *sync.Map, key interface{}, value interface{}) {
+	if atomic.AddInt32(&e.compiled, 1) > maxCompiledMatchers {
+		e.invalidateMatcherMap()
+		atomic.AddInt32(&e.compiled, 1)
+	}
+	m.Store(key, value)
+}
+
+// invalidateMatcherMap drops all compiled matchers. The compiled expressions capture the function
+// map and the role managers of the g() functions, so they must be rebuilt whenever either changes.
+func (e *Enforcer) invalidateMatcherMap() {
+	atomic.StoreInt32(&e.compiled, 0)
+	e.matcherMap.Range(func(key, value interface{}) bool {
+		e.matcherMap.Delete(key)
+		return true
+	})
+	e.evalMap.Range(func(key, value interface{}) bool {
+		e.evalMap.Delete(key)
+		return true
+	})
+	e.equalityMap.Range(func(key, value interface{}) bool {
+		e.equalityMap.Delete(key)
+		return true
+	})
+}
+
+// invalidateEvalRules drops the compiled eval() matchers of the given policy rows.
+func (e *Enforcer) invalidateEvalRules(ptype string, rules [][]string) {
+	if len(rules) == 0 {
+		return
+	}
+	keys := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		keys[strings.Join(rule, model.DefaultSep)] = struct{}{}
+	}
+	e.evalMap.Range(func(key, value interface{}) bool {
+		k := key.(evalKey)
+		if _, ok := keys[k.rule]; ok && k.ptype == ptype {
+			e.evalMap.Delete(key)
+			atomic.AddInt32(&e.compiled, -1)
+		}
+		return true
+	})
+}
+
+// getMatcherExpression returns the compiled matcher for expString, compiling and caching it on a miss.
+func (e *Enforcer) getMatcherExpression(expString string) (*govaluate.EvaluableExpression, error) {
+	if expression, ok := e.matcherMap.Load(expString); ok {
+		return expression.(*govaluate.EvaluableExpression), nil
+	}
+
+	expression, err := govaluate.NewEvaluableExpressionWithFunctions(e.withGMemo(expString), e.getFunctions())
+	if err != nil {
+		return nil, err
+	}
+	e.storeCompiled(&e.matcherMap, expString, expression)
+	return expression, nil
+}
+
+// getEvalExpression returns the matcher compiled with the eval() sub-rules of a single policy row.
+func (e *Enforcer) getEvalExpression(ptype string, expString string, pTokens map[string]int, pvals []string) (*govaluate.EvaluableExpression, error) {
+	key := evalKey{ptype: ptype, rule: strings.Join(pvals, model.DefaultSep), matcher: expString}
+	if expression, ok := e.evalMap.Load(key); ok {
+		return expression.(*govaluate.EvaluableExpression), nil
+	}
+
+	expression, err := compileEvalExpression(e.withGMemo(expString), pTokens, pvals, e.getFunctions())
+	if err != nil {
+		return nil, err
+	}
+	e.storeCompiled(&e.evalMap, key, expression)
+	return expression, nil
+}
+
+// compileEvalExpression replaces the eval() calls of the matcher by the sub-rules of a policy row and compiles the result.
+func compileEvalExpression(expString string, pTokens map[string]int, pvals []string, functions map[string]govaluate.ExpressionFunction) (*govaluate.EvaluableExpression, error) {
+	ruleNames := util.GetEvalValue(expString)
+	replacements := make(map[string]string)
+	for _, ruleName := range ruleNames {
+		if j, ok := pTokens[ruleName]; ok {
+			rule := util.EscapeAttributes(util.EscapeAssertion(pvals[j]))
+			// Increase the evaluate priority of the rule
+			replacements[ruleName] = "(" + rule + ")"
+		} else {
+			return nil, errors.New("please make sure rule exists in policy when using eval() in matcher")
+		}
+	}
+	expWithRule := util.ReplaceEvalWithMap(expString, replacements)
+	expression, err := govaluate.NewEvaluableExpressionWithFunctions(expWithRule, functions)
+	if err != nil {
+		return nil, fmt.Errorf("p.sub_rule should satisfy the syntax of matcher: %s", err)
+	}
+	return expression, nil
+}
+
+// getFunctions returns the custom functions together with the g() functions of the role definitions,
+// for the matchers rewritten by withGMemo.
+func (e *Enforcer) getFunctions() map[string]govaluate.ExpressionFunction {
+	functions := e.fm.GetFunctions()
+	if _, ok := e.model["g"]; ok {
+		for key, ast := range e.model["g"] {
+			rm := ast.RM
+			functions[key] = util.GenerateGFunctionWithMemo(rm)
+		}
+	}
+	return functions
+}
+
+// gMemoParameter is the matcher variable holding the g() memo of an enforcement.
+const gMemoParameter = "gMemo_"
+
+var gCallReg = regexp.MustCompile(`\b(g[0-9]*)\(`)
+
+// withGMemo passes the g() memo of the enforcement to the g() calls of the matcher, so that a matcher
+// compiled once memorizes the role links of a single enforcement and sees the changes of the role
+// managers at the next one.
+func (e *Enforcer) withGMemo(expString string) string {
+	return gCallReg.ReplaceAllStringFunc(expString, func(call string) string {
+		if _, ok := e.model["g"][call[:len(call)-1]]; !ok {
+			return call
+		}
+		return call + gMemoParameter + ", "
+	})
+}
+
 // NewEnforceContext Create a default structure based on the suffix
 func NewEnforceContext(suffix string) EnforceContext {
 	return EnforceContext{
@@ -471,7 +804,21 @@ func NewEnforceContext(suffix string) EnforceContext {
 }
 
 // enforce use a custom matcher to decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (matcher, sub, obj, act), use model matcher by default when matcher is "".
-func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interface{}) (ok bool, err error) {
+// The evaluation stops with the context's error as soon as ctx is done, and is recorded into trace when it is not nil.
+// The full outcome is written into decision when it is not nil.
+func (e *Enforcer) enforce(ctx context.Context, matcher string, explains *[]string, trace *DecisionTrace, decision *Decision, rvals ...interface{}) (ok bool, err error) {
+	// the policy rule being evaluated, to report where an error happened.
+	row := -1
+	if decision != nil {
+		defer func() {
+			if err != nil {
+				*decision = Decision{Effect: effector.Indeterminate, Reason: err.Error()}
+				if row != -1 {
+					decision.Reason = fmt.Sprintf("policy rule %d: %v", row, err)
+				}
+			}
+		}()
+	}
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("panic: %v", r)
@@ -479,15 +826,17 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 	}()
 
 	if !e.enabled {
+		if trace != nil {
+			trace.Effect, trace.Result = effector.Allow.String(), true
+		}
+		if decision != nil {
+			*decision = Decision{Effect: effector.Allow, Reason: "enforcement is disabled"}
+		}
 		return true, nil
 	}
 
-	functions := e.fm.GetFunctions()
-	if _, ok := e.model["g"]; ok {
-		for key, ast := range e.model["g"] {
-			rm := ast.RM
-			functions[key] = util.GenerateGFunction(rm)
-		}
+	if err := ctx.Err(); err != nil {
+		return false, err
 	}
 
 	var (
@@ -514,14 +863,25 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 	if matcher == "" {
 		expString = e.model["m"][mType].Value
 	} else {
-		expString = util.RemoveComments(util.EscapeAssertion(matcher))
+		expString = util.EscapeAttributes(util.RemoveComments(util.EscapeAssertion(matcher)))
 	}
 
 	var expression *govaluate.EvaluableExpression
+	var traceFunctions map[string]govaluate.ExpressionFunction
 	hasEval := util.HasEval(expString)
 
+	// traced matchers are compiled apart, so that the g() lookups they make are recorded.
+	if trace != nil {
+		trace.begin(expString, e.model["r"][rType].Tokens, rvals, e.model["e"][eType].Value)
+		traceFunctions = e.getTraceFunctions(trace)
+	}
+
 	if !hasEval {
-		expression, err = govaluate.NewEvaluableExpressionWithFunctions(expString, functions)
+		if trace != nil {
+			expression, err = govaluate.NewEvaluableExpressionWithFunctions(expString, traceFunctions)
+		} else {
+			expression, err = e.getMatcherExpression(expString)
+		}
 		if err != nil {
 			return false, err
 		}
@@ -537,11 +897,18 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 	}
 
 	parameters := enforceParameters{
-		rTokens: rTokens,
-		rVals:   rvals,
+		ctx:   ctx,
+		gMemo: util.GMemo{},
+
+		rTokens:  rTokens,
+		rVals:    rvals,
+		rObjects: newRequestObjects(rvals),
 
 		pTokens: pTokens,
 	}
+	if len(e.attributeProviders) != 0 {
+		parameters.providers, parameters.fetched = e.attributeProviders, make(map[string]fetchedAttributes)
+	}
 
 	if len(e.model["r"][rType].Tokens) != len(rvals) {
 		return false, fmt.Errorf(
@@ -557,11 +924,36 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 	var effect effector.Effect
 	var explainIndex int
 
-	if policyLen := len(e.model["p"][pType].Policy); policyLen != 0 && strings.Contains(expString, pType+"_") {
+	policyLen := len(e.model["p"][pType].Policy)
+	usesPolicy := policyLen != 0 && strings.Contains(expString, pType+"_")
+	if usesPolicy {
 		policyEffects = make([]effector.Effect, policyLen)
 		matcherResults = make([]float64, policyLen)
 
-		for policyIndex, pvals := range e.model["p"][pType].Policy {
+		// rules outside of the candidates can't match, so they are left as no-match.
+		candidates, indexed := e.getIndexedPolicy(expString, rType, pType, rTokens, rvals)
+		evaluated := -1
+		effect, explainIndex = effector.Indeterminate, -1
+
+		if trace != nil {
+			trace.Rules = make([]RuleTrace, policyLen)
+			for i, rule := range e.model["p"][pType].Policy {
+				trace.Rules[i] = RuleTrace{Index: i, Rule: rule}
+			}
+		}
+
+		for n := 0; n < policyLen; n++ {
+			policyIndex := n
+			if indexed {
+				if n >= len(candidates) {
+					break
+				}
+				policyIndex = candidates[n]
+			}
+
+			pvals := e.model["p"][pType].Policy[policyIndex]
+			evaluated, row = policyIndex, policyIndex
+
 			// log.LogPrint("Policy Rule: ", pvals)
 			if len(e.model["p"][pType].Tokens) != len(pvals) {
 				return false, fmt.Errorf(
@@ -574,21 +966,13 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 			parameters.pVals = pvals
 
 			if hasEval {
-				ruleNames := util.GetEvalValue(expString)
-				replacements := make(map[string]string)
-				for _, ruleName := range ruleNames {
-					if j, ok := parameters.pTokens[ruleName]; ok {
-						rule := util.EscapeAssertion(pvals[j])
-						// Increase the evaluate priority of the rule
-						replacements[ruleName] = "(" + rule + ")"
-					} else {
-						return false, errors.New("please make sure rule exists in policy when using eval() in matcher")
-					}
+				if trace != nil {
+					expression, err = compileEvalExpression(expString, parameters.pTokens, pvals, traceFunctions)
+				} else {
+					expression, err = e.getEvalExpression(pType, expString, parameters.pTokens, pvals)
 				}
-				expWithRule := util.ReplaceEvalWithMap(expString, replacements)
-				expression, err = govaluate.NewEvaluableExpressionWithFunctions(expWithRule, functions)
 				if err != nil {
-					return false, fmt.Errorf("p.sub_rule should satisfy the syntax of matcher: %s", err)
+					return false, err
 				}
 			}
 
@@ -598,6 +982,10 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 			if err != nil {
 				return false, err
 			}
+			// the functions may have returned early because of the context.
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
 
 			// set to no-match at first
 			matcherResults[policyIndex] = 0
@@ -627,6 +1015,12 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 				policyEffects[policyIndex] = effector.Allow
 			}
 
+			if trace != nil {
+				trace.Rules[policyIndex].Evaluated = true
+				trace.Rules[policyIndex].Matched = matcherResults[policyIndex] != 0
+				trace.Rules[policyIndex].Effect = policyEffects[policyIndex].String()
+			}
+
 			//if e.model["e"]["e"].Value == "priority(p_eft) || deny" {
 			//	break
 			//}
@@ -639,6 +1033,14 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 				break
 			}
 		}
+
+		// let the effector see the end of the policy as the full scan does
+		if indexed && effect == effector.Indeterminate && evaluated != policyLen-1 {
+			effect, explainIndex, err = e.eft.MergeEffects(e.model["e"][eType].Value, policyEffects, matcherResults, policyLen-1, policyLen)
+			if err != nil {
+				return false, err
+			}
+		}
 	} else {
 
 		if hasEval && len(e.model["p"][pType].Policy) == 0 {
@@ -656,6 +1058,9 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 		if err != nil {
 			return false, err
 		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
 
 		if result.(bool) {
 			policyEffects[0] = effector.Allow
@@ -663,6 +1068,10 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 			policyEffects[0] = effector.Indeterminate
 		}
 
+		if trace != nil {
+			trace.Rules = []RuleTrace{{Index: -1, Evaluated: true, Matched: result.(bool), Effect: policyEffects[0].String()}}
+		}
+
 		effect, explainIndex, err = e.eft.MergeEffects(e.model["e"][eType].Value, policyEffects, matcherResults, 0, 1)
 		if err != nil {
 			return false, err
@@ -687,40 +1096,116 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 	if effect == effector.Allow {
 		result = true
 	}
-	e.logger.LogEnforce(expString, rvals, result, logExplains)
+	row = -1
+
+	logger, logDecision := e.logger.(log.DecisionLogger)
+	logDecision = logDecision && logger.IsEnabled()
+	if decision != nil || logDecision {
+		if !usesPolicy {
+			policyEffects, matcherResults = nil, nil
+		}
+		obligationsIndex := -1
+		if j, ok := pTokens[pType+"_obligations"]; ok {
+			obligationsIndex = j
+		}
+		d := newDecision(e.model["p"][pType].Policy, obligationsIndex, effect, explainIndex, policyEffects, matcherResults)
+		if decision != nil {
+			*decision = d
+		}
+		if logDecision {
+			logger.LogDecision(expString, rvals, d.Effect.String(), d.Matched, d.Reason)
+		}
+	}
+	if !logDecision {
+		e.logger.LogEnforce(expString, rvals, result, logExplains)
+	}
+
+	if trace != nil {
+		trace.Effect, trace.Result = effect.String(), result
+		if explainIndex != -1 && len(e.model["p"][pType].Policy) > explainIndex {
+			trace.Explain = e.model["p"][pType].Policy[explainIndex]
+		}
+	}
 
 	return result, nil
 }
 
 // Enforce decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (sub, obj, act).
 func (e *Enforcer) Enforce(rvals ...interface{}) (bool, error) {
-	return e.enforce("", nil, rvals...)
+	return e.enforce(context.Background(), "", nil, nil, nil, rvals...)
+}
+
+// EnforceCtx decides like Enforce, but gives up with the context's error once ctx is cancelled or its deadline passes.
+// The context is available to custom functions as the "ctx" variable of the matcher, e.g. "lookup(ctx, r.sub)".
+func (e *Enforcer) EnforceCtx(ctx context.Context, rvals ...interface{}) (bool, error) {
+	return e.enforce(ctx, "", nil, nil, nil, rvals...)
 }
 
 // EnforceWithMatcher use a custom matcher to decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (matcher, sub, obj, act), use model matcher by default when matcher is "".
 func (e *Enforcer) EnforceWithMatcher(matcher string, rvals ...interface{}) (bool, error) {
-	return e.enforce(matcher, nil, rvals...)
+	return e.enforce(context.Background(), matcher, nil, nil, nil, rvals...)
 }
 
 // EnforceEx explain enforcement by informing matched rules
 func (e *Enforcer) EnforceEx(rvals ...interface{}) (bool, []string, error) {
 	explain := []string{}
-	result, err := e.enforce("", &explain, rvals...)
+	result, err := e.enforce(context.Background(), "", &explain, nil, nil, rvals...)
+	return result, explain, err
+}
+
+// EnforceExCtx explain enforcement by informing matched rules, giving up once ctx is done.
+func (e *Enforcer) EnforceExCtx(ctx context.Context, rvals ...interface{}) (bool, []string, error) {
+	explain := []string{}
+	result, err := e.enforce(ctx, "", &explain, nil, nil, rvals...)
 	return result, explain, err
 }
 
 // EnforceExWithMatcher use a custom matcher and explain enforcement by informing matched rules
 func (e *Enforcer) EnforceExWithMatcher(matcher string, rvals ...interface{}) (bool, []string, error) {
 	explain := []string{}
-	result, err := e.enforce(matcher, &explain, rvals...)
+	result, err := e.enforce(context.Background(), matcher, &explain, nil, nil, rvals...)
 	return result, explain, err
 }
 
+// EnforceWithTrace decides like Enforce and returns how the decision was reached: the result of
+// every policy rule, the role links consulted by g() and the merged effect.
+func (e *Enforcer) EnforceWithTrace(rvals ...interface{}) (bool, *DecisionTrace, error) {
+	trace := &DecisionTrace{}
+	result, err := e.enforce(context.Background(), "", nil, trace, nil, rvals...)
+	return result, trace, err
+}
+
+// EnforceWithObligations decides like Enforce and returns the obligations of the matched rules that
+// have the effect of the decision, e.g. the obligations of the deny rules when the request is denied.
+// The obligations of a rule are read from the "obligations" token of the policy definition, separated by ";".
+func (e *Enforcer) EnforceWithObligations(rvals ...interface{}) (bool, []string, error) {
+	decision := &Decision{}
+	result, err := e.enforce(context.Background(), "", nil, nil, decision, rvals...)
+	if decision.Obligations == nil {
+		decision.Obligations = []string{}
+	}
+	return result, decision.Obligations, err
+}
+
+// Decide decides like Enforce and returns the full decision: the effect, which tells a request that
+// no rule matches from an explicitly denied one, the deciding rule, the matched rules and the reason.
+// An error leaves the decision Indeterminate, with the error and the policy rule it happened at as reason.
+func (e *Enforcer) Decide(rvals ...interface{}) (*Decision, error) {
+	decision := &Decision{}
+	_, err := e.enforce(context.Background(), "", nil, nil, decision, rvals...)
+	return decision, err
+}
+
 // BatchEnforce enforce in batches
 func (e *Enforcer) BatchEnforce(requests [][]interface{}) ([]bool, error) {
+	return e.BatchEnforceCtx(context.Background(), requests)
+}
+
+// BatchEnforceCtx enforce in batches, giving up once ctx is done.
+func (e *Enforcer) BatchEnforceCtx(ctx context.Context, requests [][]interface{}) ([]bool, error) {
 	var results []bool
 	for _, request := range requests {
-		result, err := e.enforce("", nil, request...)
+		result, err := e.enforce(ctx, "", nil, nil, nil, request...)
 		if err != nil {
 			return results, err
 		}
@@ -733,7 +1218,7 @@ func (e *Enforcer) BatchEnforce(requests [][]interface{}) ([]bool, error) {
 func (e *Enforcer) BatchEnforceWithMatcher(matcher string, requests [][]interface{}) ([]bool, error) {
 	var results []bool
 	for _, request := range requests {
-		result, err := e.enforce(matcher, nil, request...)
+		result, err := e.enforce(context.Background(), matcher, nil, nil, nil, request...)
 		if err != nil {
 			return results, err
 		}
@@ -742,10 +1227,53 @@ func (e *Enforcer) BatchEnforceWithMatcher(matcher string, requests [][]interfac
 	return results, nil
 }
 
+// BatchEnforceParallel enforce in batches with a pool of workers goroutines, runtime.NumCPU() when workers <= 0.
+// The results keep the order of the requests, and a failing request only reports its own error in errs.
+func (e *Enforcer) BatchEnforceParallel(requests [][]interface{}, workers int) ([]bool, []error) {
+	return e.batchEnforceParallel("", requests, workers)
+}
+
+// BatchEnforceWithMatcherParallel enforce with matcher in batches with a pool of workers goroutines.
+func (e *Enforcer) BatchEnforceWithMatcherParallel(matcher string, requests [][]interface{}, workers int) ([]bool, []error) {
+	return e.batchEnforceParallel(matcher, requests, workers)
+}
+
+func (e *Enforcer) batchEnforceParallel(matcher string, requests [][]interface{}, workers int) ([]bool, []error) {
+	results := make([]bool, len(requests))
+	errs := make([]error, len(requests))
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(requests) {
+		workers = len(requests)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range indexes {
+				results[j], errs[j] = e.enforce(context.Background(), matcher, nil, nil, nil, requests[j]...)
+			}
+		}()
+	}
+	for j := range requests {
+		indexes <- j
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results, errs
+}
+
 // AddNamedMatchingFunc add MatchingFunc by ptype RoleManager
 func (e *Enforcer) AddNamedMatchingFunc(ptype, name string, fn defaultrolemanager.MatchingFunc) bool {
 	if rm, ok := e.rmMap[ptype]; ok {
 		rm.(*defaultrolemanager.RoleManager).AddMatchingFunc(name, fn)
+		e.invalidateMatcherMap()
 		return true
 	}
 	return false
@@ -755,6 +1283,7 @@ func (e *Enforcer) AddNamedMatchingFunc(ptype, name string, fn defaultrolemanage
 func (e *Enforcer) AddNamedDomainMatchingFunc(ptype, name string, fn defaultrolemanager.MatchingFunc) bool {
 	if rm, ok := e.rmMap[ptype]; ok {
 		rm.(*defaultrolemanager.RoleManager).AddDomainMatchingFunc(name, fn)
+		e.invalidateMatcherMap()
 		return true
 	}
 	return false
@@ -762,8 +1291,16 @@ func (e *Enforcer) AddNamedDomainMatchingFunc(ptype, name string, fn defaultrole
 
 // assumes bounds have already been checked
 type enforceParameters struct {
+	ctx   context.Context
+	gMemo util.GMemo
+
 	rTokens map[string]int
 	rVals   []interface{}
+	// rObjects memoises the decoded JSON values of the request by token, it is nil when there is none.
+	rObjects map[string]interface{}
+	// providers are the attribute providers of the request tokens, their results are memoised in fetched.
+	providers map[string]AttributeProvider
+	fetched   map[string]fetchedAttributes
 
 	pTokens map[string]int
 	pVals   []string
@@ -775,6 +1312,13 @@ func (p enforceParameters) Get(name string) (interface{}, error) {
 		return nil, nil
 	}
 
+	if name == "ctx" {
+		return p.ctx, nil
+	}
+	if name == gMemoParameter {
+		return p.gMemo, nil
+	}
+
 	switch name[0] {
 	case 'p':
 		i, ok := p.pTokens[name]
@@ -783,11 +1327,28 @@ func (p enforceParameters) Get(name string) (interface{}, error) {
 		}
 		return p.pVals[i], nil
 	case 'r':
-		i, ok := p.rTokens[name]
-		if !ok {
-			return nil, errors.New("No parameter '" + name + "' found.")
+		if i, ok := p.rTokens[name]; ok {
+			return p.rVals[i], nil
 		}
-		return p.rVals[i], nil
+		// attributes of a request value, like "r_sub.department".
+		if dot := strings.IndexByte(name, '.'); dot != -1 {
+			if i, ok := p.rTokens[name[:dot]]; ok {
+				value := p.rVals[i]
+				if object, ok := p.requestObject(name[:dot], value); ok {
+					value = object
+				} else if id, ok := value.(string); ok && p.providers != nil {
+					attributes, ok, err := p.provideAttributes(name[:dot], id)
+					if err != nil {
+						return nil, err
+					}
+					if ok {
+						value = attributes
+					}
+				}
+				return getAttribute(name[:dot], value, strings.Split(name[dot+1:], "."))
+			}
+		}
+		return nil, errors.New("No parameter '" + name + "' found.")
 	default:
 		return nil, errors.New("No parameter '" + name + "' found.")
 	}
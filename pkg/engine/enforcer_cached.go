@@ -21,6 +21,8 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
+	"encoding/json"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -35,8 +37,15 @@ type CachedEnforcer struct {
 	cache       cache.Cache
 	enableCache int32
 	locker      *sync.RWMutex
+	// decisions holds the full decisions of the cached results, they are only used while the result is
+	// cached. It is guarded by locker and holds maxCachedDecisions decisions at most.
+	decisions map[string]*Decision
 }
 
+// maxCachedDecisions bounds the number of full decisions kept by a CachedEnforcer. Once it is reached,
+// the decisions whose result left the cache are dropped, or all of them if none did.
+const maxCachedDecisions = 10000
+
 type CacheableParam interface {
 	GetCacheKey() string
 }
@@ -54,6 +63,7 @@ func NewCachedEnforcer(params ...interface{}) (*CachedEnforcer, error) {
 	cache := cache.DefaultCache(make(map[string]bool))
 	e.cache = &cache
 	e.locker = new(sync.RWMutex)
+	e.decisions = make(map[string]*Decision)
 	return e, nil
 }
 
@@ -67,15 +77,21 @@ func (e *CachedEnforcer) EnableCache(enableCache bool) {
 }
 
 // Enforce decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (sub, obj, act).
-// if rvals is not string , ingore the cache
+// if rvals is not string, CacheableParam, map[string]interface{} or JSON, ingore the cache
 func (e *CachedEnforcer) Enforce(rvals ...interface{}) (bool, error) {
+	return e.EnforceCtx(context.Background(), rvals...)
+}
+
+// EnforceCtx decides like Enforce, but gives up with the context's error once ctx is cancelled or its deadline passes.
+// A result found in the cache is returned without evaluation.
+func (e *CachedEnforcer) EnforceCtx(ctx context.Context, rvals ...interface{}) (bool, error) {
 	if atomic.LoadInt32(&e.enableCache) == 0 {
-		return e.Enforcer.Enforce(rvals...)
+		return e.Enforcer.EnforceCtx(ctx, rvals...)
 	}
 
 	key, ok := e.getKey(rvals...)
 	if !ok {
-		return e.Enforcer.Enforce(rvals...)
+		return e.Enforcer.EnforceCtx(ctx, rvals...)
 	}
 
 	if res, err := e.getCachedResult(key); err == nil {
@@ -84,7 +100,7 @@ func (e *CachedEnforcer) Enforce(rvals ...interface{}) (bool, error) {
 		return res, err
 	}
 
-	res, err := e.Enforcer.Enforce(rvals...)
+	res, err := e.Enforcer.EnforceCtx(ctx, rvals...)
 	if err != nil {
 		return false, err
 	}
@@ -93,11 +109,55 @@ func (e *CachedEnforcer) Enforce(rvals ...interface{}) (bool, error) {
 	return res, err
 }
 
+// EnforceWithObligations decides like Enforce and returns the obligations of the matched rules that have the effect of the decision.
+// The obligations are cached together with the decision.
+func (e *CachedEnforcer) EnforceWithObligations(rvals ...interface{}) (bool, []string, error) {
+	decision, err := e.Decide(rvals...)
+	if err != nil {
+		return false, nil, err
+	}
+	obligations := decision.Obligations
+	if obligations == nil {
+		obligations = []string{}
+	}
+	return decision.Allowed(), obligations, nil
+}
+
+// Decide decides like Enforce and returns the full decision.
+// The decision is cached together with its result, a result cached by Enforce is decided again to get its decision.
+func (e *CachedEnforcer) Decide(rvals ...interface{}) (*Decision, error) {
+	if atomic.LoadInt32(&e.enableCache) == 0 {
+		return e.Enforcer.Decide(rvals...)
+	}
+
+	key, ok := e.getKey(rvals...)
+	if !ok {
+		return e.Enforcer.Decide(rvals...)
+	}
+
+	if decision, err := e.getCachedDecision(key); err == nil && decision != nil {
+		return decision, nil
+	} else if err != nil && err != cache.ErrNoSuchKey {
+		return nil, err
+	}
+
+	decision, err := e.Enforcer.Decide(rvals...)
+	if err != nil {
+		return decision, err
+	}
+
+	err = e.setCachedDecision(key, decision, e.expireTime)
+	return decision, err
+}
+
 func (e *CachedEnforcer) LoadPolicy() error {
 	if atomic.LoadInt32(&e.enableCache) != 0 {
 		if err := e.cache.Clear(); err != nil {
 			return err
 		}
+		e.locker.Lock()
+		e.clearDecisions()
+		e.locker.Unlock()
 	}
 	return e.Enforcer.LoadPolicy()
 }
@@ -109,6 +169,7 @@ func (e *CachedEnforcer) RemovePolicy(params ...interface{}) (bool, error) {
 			if err := e.cache.Delete(key); err != nil && err != cache.ErrNoSuchKey {
 				return false, err
 			}
+			e.deleteCachedDecision(key)
 		}
 	}
 	return e.Enforcer.RemovePolicy(params...)
@@ -126,12 +187,25 @@ func (e *CachedEnforcer) RemovePolicies(rules [][]string) (bool, error) {
 				if err := e.cache.Delete(key); err != nil && err != cache.ErrNoSuchKey {
 					return false, err
 				}
+				e.deleteCachedDecision(key)
 			}
 		}
 	}
 	return e.Enforcer.RemovePolicies(rules)
 }
 
+// Transaction applies the policy changes made by fn atomically, see Enforcer.Transaction. The cached
+// decisions are invalidated when the transaction is committed.
+func (e *CachedEnforcer) Transaction(fn func(tx *Transaction) error) error {
+	if err := e.Enforcer.Transaction(fn); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&e.enableCache) != 0 {
+		return e.InvalidateCache()
+	}
+	return nil
+}
+
 func (e *CachedEnforcer) getCachedResult(key string) (res bool, err error) {
 	e.locker.RLock()
 	defer e.locker.RUnlock()
@@ -149,17 +223,70 @@ func (e *CachedEnforcer) SetCache(c cache.Cache) {
 func (e *CachedEnforcer) setCachedResult(key string, res bool, extra ...interface{}) error {
 	e.locker.Lock()
 	defer e.locker.Unlock()
+	// the decision cached for the key, if any, may not be the one of this result.
+	delete(e.decisions, key)
 	return e.cache.Set(key, res, extra...)
 }
 
+// getCachedDecision returns the decision cached for key, which is nil if only its result is cached.
+func (e *CachedEnforcer) getCachedDecision(key string) (*Decision, error) {
+	e.locker.RLock()
+	defer e.locker.RUnlock()
+	if _, err := e.cache.Get(key); err != nil {
+		return nil, err
+	}
+	return e.decisions[key], nil
+}
+
+func (e *CachedEnforcer) setCachedDecision(key string, decision *Decision, extra ...interface{}) error {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	if len(e.decisions) >= maxCachedDecisions {
+		for k := range e.decisions {
+			if _, err := e.cache.Get(k); err != nil {
+				delete(e.decisions, k)
+			}
+		}
+		if len(e.decisions) >= maxCachedDecisions {
+			e.clearDecisions()
+		}
+	}
+	e.decisions[key] = decision
+	return e.cache.Set(key, decision.Allowed(), extra...)
+}
+
+func (e *CachedEnforcer) deleteCachedDecision(key string) {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	delete(e.decisions, key)
+}
+
+// getKey returns the cache key of a request. Every value is prefixed with its kind, so that values of
+// different kinds holding the same text, e.g. a string and a JSON map, don't share a key.
 func (e *CachedEnforcer) getKey(params ...interface{}) (string, bool) {
 	key := strings.Builder{}
 	for _, param := range params {
 		switch typedParam := param.(type) {
 		case string:
+			key.WriteString("s:")
 			key.WriteString(typedParam)
 		case CacheableParam:
+			key.WriteString("c:")
 			key.WriteString(typedParam.GetCacheKey())
+		case json.RawMessage:
+			key.WriteString("j:")
+			key.Write(canonicalJSON(typedParam))
+		case []byte:
+			key.WriteString("b:")
+			key.Write(canonicalJSON(typedParam))
+		case map[string]interface{}:
+			// the keys of the maps are sorted, so equal maps give the same key.
+			data, err := json.Marshal(typedParam)
+			if err != nil {
+				return "", false
+			}
+			key.WriteString("m:")
+			key.Write(data)
 		default:
 			return "", false
 		}
@@ -168,9 +295,29 @@ func (e *CachedEnforcer) getKey(params ...interface{}) (string, bool) {
 	return key.String(), true
 }
 
+// canonicalJSON returns data encoded again without spaces and with sorted keys, so that equal JSON
+// documents give the same key. Data which is not JSON is returned as it is.
+func canonicalJSON(data []byte) []byte {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return data
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return data
+	}
+	return canonical
+}
+
 // InvalidateCache deletes all the existing cached decisions.
 func (e *CachedEnforcer) InvalidateCache() error {
 	e.locker.Lock()
 	defer e.locker.Unlock()
+	e.clearDecisions()
 	return e.cache.Clear()
 }
+
+// clearDecisions drops the cached decisions, the caller holds the lock.
+func (e *CachedEnforcer) clearDecisions() {
+	e.decisions = make(map[string]*Decision)
+}
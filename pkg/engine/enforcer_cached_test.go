@@ -20,7 +20,14 @@ package engine
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-import "testing"
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/bhojpur/policy/pkg/effector"
+	"github.com/bhojpur/policy/pkg/util"
+)
 
 func testEnforceCache(t *testing.T, e *CachedEnforcer, sub string, obj interface{}, act string, res bool) {
 	t.Helper()
@@ -64,3 +71,91 @@ func TestCache(t *testing.T) {
 	testEnforceCache(t, e, "alice", "data2", "read", true)
 	testEnforceCache(t, e, "alice", "data2", "write", true)
 }
+
+func TestCacheWithObligations(t *testing.T) {
+	e, _ := NewCachedEnforcer("../../examples/obligations_model.conf", "../../examples/obligations_policy.csv")
+	testEnforceWithObligations(t, e, "alice", "record", "write", true, []string{"require mfa"})
+
+	// the cached decision keeps its obligations.
+	e.Enforcer.ClearPolicy()
+	testEnforceWithObligations(t, e, "alice", "record", "write", true, []string{"require mfa"})
+
+	_ = e.InvalidateCache()
+	testEnforceWithObligations(t, e, "alice", "record", "write", false, []string{})
+
+	_, _ = e.AddPolicy("alice", "record", "write", "allow", "notify owner")
+	if res, _ := e.Enforce("alice", "record", "write"); res {
+		t.Error("alice, record, write: the cached decision should be false")
+	}
+	_ = e.InvalidateCache()
+	if res, obligations, _ := e.EnforceWithObligations("alice", "record", "write"); !res || !util.ArrayEquals(obligations, []string{"notify owner"}) {
+		t.Errorf("alice, record, write: %t, %v", res, obligations)
+	}
+}
+
+func TestCacheDecide(t *testing.T) {
+	e, _ := NewCachedEnforcer("../../examples/obligations_model.conf", "../../examples/obligations_policy.csv")
+	testDecide(t, e, "bob", "record", "read", effector.Deny, []string{"bob", "record", "read", "deny", "log audit"})
+
+	// the cached result keeps its decision.
+	e.Enforcer.ClearPolicy()
+	testDecide(t, e, "bob", "record", "read", effector.Deny, []string{"bob", "record", "read", "deny", "log audit"})
+	if res, _ := e.Enforce("bob", "record", "read"); res {
+		t.Error("bob, record, read: the cached result should be false")
+	}
+
+	_ = e.InvalidateCache()
+	testDecide(t, e, "bob", "record", "read", effector.NotApplicable, nil)
+
+	// the decisions are bounded: those whose result left the cache are dropped first.
+	for i := 0; i < maxCachedDecisions; i++ {
+		e.decisions[fmt.Sprint(i)] = &Decision{}
+	}
+	_ = e.setCachedResult("live", true)
+	e.decisions["live"] = &Decision{}
+	testDecide(t, e, "alice", "record", "read", effector.NotApplicable, nil)
+	if len(e.decisions) != 3 {
+		t.Errorf("cached decisions: %d, supposed to be 3", len(e.decisions))
+	}
+	for i := 0; i < maxCachedDecisions; i++ {
+		key := fmt.Sprint(i)
+		_ = e.setCachedResult(key, true)
+		e.decisions[key] = &Decision{}
+	}
+	testDecide(t, e, "bob", "record", "write", effector.NotApplicable, nil)
+	if len(e.decisions) != 1 {
+		t.Errorf("cached decisions: %d, supposed to be 1", len(e.decisions))
+	}
+}
+
+func TestCacheMapRequest(t *testing.T) {
+	e, _ := NewCachedEnforcer("../../examples/abac_model.conf")
+	data1 := map[string]interface{}{"Name": "data1", "Owner": "alice"}
+
+	key1, ok := e.getKey("alice", data1, "read")
+	key2, _ := e.getKey("alice", map[string]interface{}{"Owner": "alice", "Name": "data1"}, "read")
+	if !ok || key1 != key2 {
+		t.Errorf("the keys of equal maps should be equal: %q, %q", key1, key2)
+	}
+	key1, _ = e.getKey("alice", json.RawMessage(`{"Owner": "alice", "Name": "data1"}`), "read")
+	key2, _ = e.getKey("alice", json.RawMessage(`{"Name":"data1","Owner":"alice"}`), "read")
+	if key1 != key2 {
+		t.Errorf("the keys of equal JSON documents should be equal: %q, %q", key1, key2)
+	}
+	keys := make(map[string]bool)
+	for _, obj := range []interface{}{data1, `{"Name":"data1","Owner":"alice"}`, json.RawMessage(`{"Name":"data1","Owner":"alice"}`), []byte(`{"Name":"data1","Owner":"alice"}`)} {
+		key, _ := e.getKey("alice", obj, "read")
+		if keys[key] {
+			t.Errorf("the keys of values of different kinds should differ: %q", key)
+		}
+		keys[key] = true
+	}
+
+	testEnforceCache(t, e, "alice", data1, "read", true)
+	testEnforceCache(t, e, "alice", `{"Owner": "bob"}`, "read", false)
+
+	// the decisions are cached.
+	e.GetModel()["m"]["m"].Value = "r_sub == r_act"
+	testEnforceCache(t, e, "alice", map[string]interface{}{"Owner": "alice", "Name": "data1"}, "read", true)
+	testEnforceCache(t, e, "alice", `{"Owner": "bob"}`, "read", false)
+}
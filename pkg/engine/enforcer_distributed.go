@@ -88,6 +88,7 @@ func (d *DistributedEnforcer) RemovePoliciesSelf(shouldPersist func() bool, sec
 	}
 
 	effected = d.model.RemovePoliciesWithEffected(sec, ptype, rules)
+	d.invalidateEvalRules(ptype, effected)
 
 	if sec == "g" {
 		err := d.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, effected)
@@ -111,6 +112,7 @@ func (d *DistributedEnforcer) RemoveFilteredPolicySelf(shouldPersist func() bool
 	}
 
 	_, effected = d.model.RemoveFilteredPolicy(sec, ptype, fieldIndex, fieldValues...)
+	d.invalidateEvalRules(ptype, effected)
 
 	if sec == "g" {
 		err := d.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, effected)
@@ -132,6 +134,7 @@ func (d *DistributedEnforcer) ClearPolicySelf(shouldPersist func() bool) error {
 	}
 
 	d.model.ClearPolicy()
+	d.invalidateMatcherMap()
 
 	return nil
 }
@@ -149,6 +152,7 @@ func (d *DistributedEnforcer) UpdatePolicySelf(shouldPersist func() bool, sec st
 	if !ruleUpdated {
 		return ruleUpdated, nil
 	}
+	d.invalidateEvalRules(ptype, [][]string{oldRule})
 
 	if sec == "g" {
 		err := d.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, [][]string{oldRule}) // remove the old rule
@@ -177,6 +181,7 @@ func (d *DistributedEnforcer) UpdatePoliciesSelf(shouldPersist func() bool, sec
 	if !ruleUpdated {
 		return ruleUpdated, nil
 	}
+	d.invalidateEvalRules(ptype, oldRules)
 
 	if sec == "g" {
 		err := d.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, oldRules) // remove the old rule
@@ -206,6 +211,7 @@ func (d *DistributedEnforcer) UpdateFilteredPoliciesSelf(shouldPersist func() bo
 	}
 
 	ruleChanged := !d.model.RemovePolicies(sec, ptype, oldRules)
+	d.invalidateEvalRules(ptype, oldRules)
 	d.model.AddPolicies(sec, ptype, newRules)
 	ruleChanged = ruleChanged && len(newRules) != 0
 	if !ruleChanged {
@@ -225,3 +231,8 @@ func (d *DistributedEnforcer) UpdateFilteredPoliciesSelf(shouldPersist func() bo
 
 	return true, nil
 }
+
+// ApplyPolicyChangesSelf provides a method for dispatcher to apply the changes of a transaction to the current policy.
+func (d *DistributedEnforcer) ApplyPolicyChangesSelf(shouldPersist func() bool, changes []persist.PolicyChange) error {
+	return d.applyPolicyChanges(shouldPersist != nil && shouldPersist(), changes)
+}
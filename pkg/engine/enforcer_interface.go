@@ -21,16 +21,20 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
+
 	"github.com/Knetic/govaluate"
 	"github.com/bhojpur/policy/pkg/effector"
 	"github.com/bhojpur/policy/pkg/model"
 	"github.com/bhojpur/policy/pkg/persist"
 	"github.com/bhojpur/policy/pkg/rbac"
+	"github.com/bhojpur/policy/pkg/util"
 )
 
 var _ IEnforcer = &Enforcer{}
 var _ IEnforcer = &SyncedEnforcer{}
 var _ IEnforcer = &CachedEnforcer{}
+var _ IEnforcer = &SnapshotEnforcer{}
 
 // IEnforcer is the API interface of Enforcer
 type IEnforcer interface {
@@ -41,6 +45,7 @@ type IEnforcer interface {
 	LoadModel() error
 	GetModel() model.Model
 	SetModel(m model.Model)
+	CheckModel(m model.Model) error
 	GetAdapter() persist.Adapter
 	SetAdapter(adapter persist.Adapter)
 	SetWatcher(watcher persist.Watcher) error
@@ -58,13 +63,23 @@ type IEnforcer interface {
 	EnableAutoNotifyWatcher(enable bool)
 	EnableAutoSave(autoSave bool)
 	EnableAutoBuildRoleLinks(autoBuildRoleLinks bool)
+	EnableStrictMode(enable bool)
 	BuildRoleLinks() error
 	Enforce(rvals ...interface{}) (bool, error)
+	EnforceCtx(ctx context.Context, rvals ...interface{}) (bool, error)
 	EnforceWithMatcher(matcher string, rvals ...interface{}) (bool, error)
 	EnforceEx(rvals ...interface{}) (bool, []string, error)
+	EnforceExCtx(ctx context.Context, rvals ...interface{}) (bool, []string, error)
 	EnforceExWithMatcher(matcher string, rvals ...interface{}) (bool, []string, error)
+	EnforceWithTrace(rvals ...interface{}) (bool, *DecisionTrace, error)
+	EnforceWithObligations(rvals ...interface{}) (bool, []string, error)
+	Decide(rvals ...interface{}) (*Decision, error)
+	PartialEnforce(rvals ...interface{}) (*PartialResult, error)
 	BatchEnforce(requests [][]interface{}) ([]bool, error)
+	BatchEnforceCtx(ctx context.Context, requests [][]interface{}) ([]bool, error)
 	BatchEnforceWithMatcher(matcher string, requests [][]interface{}) ([]bool, error)
+	BatchEnforceParallel(requests [][]interface{}, workers int) ([]bool, []error)
+	BatchEnforceWithMatcherParallel(matcher string, requests [][]interface{}, workers int) ([]bool, []error)
 
 	/* RBAC API */
 	GetRolesForUser(name string, domain ...string) ([]string, error)
@@ -135,10 +150,17 @@ type IEnforcer interface {
 	RemoveNamedGroupingPolicies(ptype string, rules [][]string) (bool, error)
 	RemoveFilteredNamedGroupingPolicy(ptype string, fieldIndex int, fieldValues ...string) (bool, error)
 	AddFunction(name string, function govaluate.ExpressionFunction)
+	AddFunctionWithSignature(name string, signature model.Signature, function govaluate.ExpressionFunction) error
+	ListFunctions() []model.FunctionInfo
+	Validate() model.Diagnostics
+	AddAttributeProvider(token string, provider AttributeProvider)
+	SetClock(clock util.Clock)
 
 	UpdatePolicy(oldPolicy []string, newPolicy []string) (bool, error)
 	UpdatePolicies(oldPolicies [][]string, newPolicies [][]string) (bool, error)
 	UpdateFilteredPolicies(newPolicies [][]string, fieldIndex int, fieldValues ...string) (bool, error)
+
+	Transaction(fn func(tx *Transaction) error) error
 }
 
 var _ IDistributedEnforcer = &DistributedEnforcer{}
@@ -155,4 +177,5 @@ type IDistributedEnforcer interface {
 	UpdatePolicySelf(shouldPersist func() bool, sec string, ptype string, oldRule, newRule []string) (effected bool, err error)
 	UpdatePoliciesSelf(shouldPersist func() bool, sec string, ptype string, oldRules, newRules [][]string) (effected bool, err error)
 	UpdateFilteredPoliciesSelf(shouldPersist func() bool, sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) (bool, error)
+	ApplyPolicyChangesSelf(shouldPersist func() bool, changes []persist.PolicyChange) error
 }
@@ -0,0 +1,898 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Knetic/govaluate"
+
+	"github.com/bhojpur/policy/pkg/model"
+	"github.com/bhojpur/policy/pkg/persist"
+	"github.com/bhojpur/policy/pkg/rbac"
+	defaultrolemanager "github.com/bhojpur/policy/pkg/rbac/default-role-manager"
+	"github.com/bhojpur/policy/pkg/util"
+)
+
+// SnapshotEnforcer wraps Enforcer for read-heavy workloads. Mutations are serialised on a working
+// copy, and every mutation publishes an immutable copy of the model and role managers that the
+// Enforce and Get* methods read without taking any lock, so that they never wait for writers.
+//
+// Role managers other than the default one are shared by the snapshots and must be safe for
+// concurrent use. Setters of the embedded Enforcer that are not wrapped here take effect once
+// they are applied through Update.
+type SnapshotEnforcer struct {
+	*Enforcer
+	m        sync.Mutex
+	snapshot atomic.Value
+}
+
+// NewSnapshotEnforcer creates a snapshot enforcer via file or DB.
+func NewSnapshotEnforcer(params ...interface{}) (*SnapshotEnforcer, error) {
+	e := &SnapshotEnforcer{}
+	var err error
+	e.Enforcer, err = NewEnforcer(params...)
+	if err != nil {
+		return nil, err
+	}
+
+	e.publish()
+	return e, nil
+}
+
+// load returns the enforcer published last.
+func (e *SnapshotEnforcer) load() *Enforcer {
+	return e.snapshot.Load().(*Enforcer)
+}
+
+// publish replaces the snapshot with a copy of the working enforcer, e.m must be held.
+func (e *SnapshotEnforcer) publish() {
+	e.snapshot.Store(e.Enforcer.snapshot())
+}
+
+// snapshot returns a copy of the enforcer that shares nothing mutable with it, for enforcement only.
+func (e *Enforcer) snapshot() *Enforcer {
+	m := e.model.Copy()
+	rmMap := make(map[string]rbac.RoleManager, len(e.rmMap))
+	for ptype, rm := range e.rmMap {
+		if drm, ok := rm.(*defaultrolemanager.RoleManager); ok {
+			rmMap[ptype] = drm.Copy()
+		} else {
+			rmMap[ptype] = rm
+		}
+	}
+	for ptype, ast := range m["g"] {
+		ast.RM = rmMap[ptype]
+	}
+	var attributeProviders map[string]AttributeProvider
+	if e.attributeProviders != nil {
+		attributeProviders = make(map[string]AttributeProvider, len(e.attributeProviders))
+		for token, provider := range e.attributeProviders {
+			attributeProviders[token] = provider
+		}
+	}
+
+	return &Enforcer{
+		modelPath:   e.modelPath,
+		model:       m,
+		fm:          e.fm,
+		eft:         e.eft,
+		rmMap:       rmMap,
+		enabled:     e.enabled,
+		policyIndex: e.policyIndex,
+		logger:      e.logger,
+
+		attributeProviders: attributeProviders,
+		clock:              e.clock,
+	}
+}
+
+// Update applies fn to the working enforcer and publishes the result once, so that a batch of
+// changes is seen by Enforce all at once and is copied a single time.
+func (e *SnapshotEnforcer) Update(fn func(e *Enforcer) error) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return fn(e.Enforcer)
+}
+
+// SetWatcher sets the current watcher.
+func (e *SnapshotEnforcer) SetWatcher(watcher persist.Watcher) error {
+	e.watcher = watcher
+	return watcher.SetUpdateCallback(func(string) { _ = e.LoadPolicy() })
+}
+
+// SavePolicy saves the current policy (usually after changed with Bhojpur Policy API) back to file/database.
+func (e *SnapshotEnforcer) SavePolicy() error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.SavePolicy()
+}
+
+// SetModel sets the current model.
+func (e *SnapshotEnforcer) SetModel(m model.Model) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	e.Enforcer.SetModel(m)
+}
+
+// CheckModel type-checks the matchers of m against the functions SetModel would set along with it.
+func (e *SnapshotEnforcer) CheckModel(m model.Model) error {
+	return e.load().CheckModel(m)
+}
+
+// EnableEnforce changes the enforcing state of policy, when Bhojpur Policy is disabled, all access will be allowed by the Enforce() function.
+func (e *SnapshotEnforcer) EnableEnforce(enable bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	e.Enforcer.EnableEnforce(enable)
+}
+
+// EnablePolicyIndex controls whether the policy rules are indexed by the tokens the matchers compare for equality with the request.
+func (e *SnapshotEnforcer) EnablePolicyIndex(enable bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	e.Enforcer.EnablePolicyIndex(enable)
+}
+
+// AddNamedMatchingFunc add MatchingFunc by ptype RoleManager
+func (e *SnapshotEnforcer) AddNamedMatchingFunc(ptype, name string, fn defaultrolemanager.MatchingFunc) bool {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddNamedMatchingFunc(ptype, name, fn)
+}
+
+// AddNamedDomainMatchingFunc add MatchingFunc by ptype to RoleManager
+func (e *SnapshotEnforcer) AddNamedDomainMatchingFunc(ptype, name string, fn defaultrolemanager.MatchingFunc) bool {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddNamedDomainMatchingFunc(ptype, name, fn)
+}
+
+// LoadModel reloads the model from the model CONF file.
+func (e *SnapshotEnforcer) LoadModel() error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.LoadModel()
+}
+
+// ClearPolicy clears all policy.
+func (e *SnapshotEnforcer) ClearPolicy() {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	e.Enforcer.ClearPolicy()
+}
+
+// LoadPolicy reloads the policy from file/database.
+func (e *SnapshotEnforcer) LoadPolicy() error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.LoadPolicy()
+}
+
+// LoadFilteredPolicy reloads a filtered policy from file/database.
+func (e *SnapshotEnforcer) LoadFilteredPolicy(filter interface{}) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.LoadFilteredPolicy(filter)
+}
+
+// LoadIncrementalFilteredPolicy reloads a filtered policy from file/database.
+func (e *SnapshotEnforcer) LoadIncrementalFilteredPolicy(filter interface{}) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.LoadIncrementalFilteredPolicy(filter)
+}
+
+// BuildRoleLinks manually rebuild the role inheritance relations.
+func (e *SnapshotEnforcer) BuildRoleLinks() error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.BuildRoleLinks()
+}
+
+// Enforce decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (sub, obj, act).
+func (e *SnapshotEnforcer) Enforce(rvals ...interface{}) (bool, error) {
+	return e.load().Enforce(rvals...)
+}
+
+// EnforceCtx decides like Enforce, but gives up with the context's error once ctx is cancelled or its deadline passes.
+func (e *SnapshotEnforcer) EnforceCtx(ctx context.Context, rvals ...interface{}) (bool, error) {
+	return e.load().EnforceCtx(ctx, rvals...)
+}
+
+// EnforceWithMatcher use a custom matcher to decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (matcher, sub, obj, act), use model matcher by default when matcher is "".
+func (e *SnapshotEnforcer) EnforceWithMatcher(matcher string, rvals ...interface{}) (bool, error) {
+	return e.load().EnforceWithMatcher(matcher, rvals...)
+}
+
+// EnforceEx explain enforcement by informing matched rules
+func (e *SnapshotEnforcer) EnforceEx(rvals ...interface{}) (bool, []string, error) {
+	return e.load().EnforceEx(rvals...)
+}
+
+// EnforceExCtx explain enforcement by informing matched rules, giving up once ctx is done.
+func (e *SnapshotEnforcer) EnforceExCtx(ctx context.Context, rvals ...interface{}) (bool, []string, error) {
+	return e.load().EnforceExCtx(ctx, rvals...)
+}
+
+// EnforceExWithMatcher use a custom matcher and explain enforcement by informing matched rules
+func (e *SnapshotEnforcer) EnforceExWithMatcher(matcher string, rvals ...interface{}) (bool, []string, error) {
+	return e.load().EnforceExWithMatcher(matcher, rvals...)
+}
+
+// EnforceWithTrace decides like Enforce and returns how the decision was reached.
+func (e *SnapshotEnforcer) EnforceWithTrace(rvals ...interface{}) (bool, *DecisionTrace, error) {
+	return e.load().EnforceWithTrace(rvals...)
+}
+
+// EnforceWithObligations decides like Enforce and returns the obligations of the matched rules that have the effect of the decision.
+func (e *SnapshotEnforcer) EnforceWithObligations(rvals ...interface{}) (bool, []string, error) {
+	return e.load().EnforceWithObligations(rvals...)
+}
+
+// Decide decides like Enforce and returns the full decision.
+func (e *SnapshotEnforcer) Decide(rvals ...interface{}) (*Decision, error) {
+	return e.load().Decide(rvals...)
+}
+
+// PartialEnforce answers which values of the free request tokens could be allowed.
+func (e *SnapshotEnforcer) PartialEnforce(rvals ...interface{}) (*PartialResult, error) {
+	return e.load().PartialEnforce(rvals...)
+}
+
+// BatchEnforce enforce in batches
+func (e *SnapshotEnforcer) BatchEnforce(requests [][]interface{}) ([]bool, error) {
+	return e.load().BatchEnforce(requests)
+}
+
+// BatchEnforceCtx enforce in batches, giving up once ctx is done.
+func (e *SnapshotEnforcer) BatchEnforceCtx(ctx context.Context, requests [][]interface{}) ([]bool, error) {
+	return e.load().BatchEnforceCtx(ctx, requests)
+}
+
+// BatchEnforceWithMatcher enforce with matcher in batches
+func (e *SnapshotEnforcer) BatchEnforceWithMatcher(matcher string, requests [][]interface{}) ([]bool, error) {
+	return e.load().BatchEnforceWithMatcher(matcher, requests)
+}
+
+// BatchEnforceParallel enforce in batches with a pool of workers, the read lock is held once for the whole batch.
+func (e *SnapshotEnforcer) BatchEnforceParallel(requests [][]interface{}, workers int) ([]bool, []error) {
+	return e.load().BatchEnforceParallel(requests, workers)
+}
+
+// BatchEnforceWithMatcherParallel enforce with matcher in batches with a pool of workers, the read lock is held once for the whole batch.
+func (e *SnapshotEnforcer) BatchEnforceWithMatcherParallel(matcher string, requests [][]interface{}, workers int) ([]bool, []error) {
+	return e.load().BatchEnforceWithMatcherParallel(matcher, requests, workers)
+}
+
+// GetAllSubjects gets the list of subjects that show up in the current policy.
+func (e *SnapshotEnforcer) GetAllSubjects() []string {
+	return e.load().GetAllSubjects()
+}
+
+// GetAllNamedSubjects gets the list of subjects that show up in the current named policy.
+func (e *SnapshotEnforcer) GetAllNamedSubjects(ptype string) []string {
+	return e.load().GetAllNamedSubjects(ptype)
+}
+
+// GetAllObjects gets the list of objects that show up in the current policy.
+func (e *SnapshotEnforcer) GetAllObjects() []string {
+	return e.load().GetAllObjects()
+}
+
+// GetAllNamedObjects gets the list of objects that show up in the current named policy.
+func (e *SnapshotEnforcer) GetAllNamedObjects(ptype string) []string {
+	return e.load().GetAllNamedObjects(ptype)
+}
+
+// GetAllActions gets the list of actions that show up in the current policy.
+func (e *SnapshotEnforcer) GetAllActions() []string {
+	return e.load().GetAllActions()
+}
+
+// GetAllNamedActions gets the list of actions that show up in the current named policy.
+func (e *SnapshotEnforcer) GetAllNamedActions(ptype string) []string {
+	return e.load().GetAllNamedActions(ptype)
+}
+
+// GetAllRoles gets the list of roles that show up in the current policy.
+func (e *SnapshotEnforcer) GetAllRoles() []string {
+	return e.load().GetAllRoles()
+}
+
+// GetAllNamedRoles gets the list of roles that show up in the current named policy.
+func (e *SnapshotEnforcer) GetAllNamedRoles(ptype string) []string {
+	return e.load().GetAllNamedRoles(ptype)
+}
+
+// GetPolicy gets all the authorization rules in the policy.
+func (e *SnapshotEnforcer) GetPolicy() [][]string {
+	return e.load().GetPolicy()
+}
+
+// GetFilteredPolicy gets all the authorization rules in the policy, field filters can be specified.
+func (e *SnapshotEnforcer) GetFilteredPolicy(fieldIndex int, fieldValues ...string) [][]string {
+	return e.load().GetFilteredPolicy(fieldIndex, fieldValues...)
+}
+
+// GetNamedPolicy gets all the authorization rules in the named policy.
+func (e *SnapshotEnforcer) GetNamedPolicy(ptype string) [][]string {
+	return e.load().GetNamedPolicy(ptype)
+}
+
+// GetFilteredNamedPolicy gets all the authorization rules in the named policy, field filters can be specified.
+func (e *SnapshotEnforcer) GetFilteredNamedPolicy(ptype string, fieldIndex int, fieldValues ...string) [][]string {
+	return e.load().GetFilteredNamedPolicy(ptype, fieldIndex, fieldValues...)
+}
+
+// GetGroupingPolicy gets all the role inheritance rules in the policy.
+func (e *SnapshotEnforcer) GetGroupingPolicy() [][]string {
+	return e.load().GetGroupingPolicy()
+}
+
+// GetFilteredGroupingPolicy gets all the role inheritance rules in the policy, field filters can be specified.
+func (e *SnapshotEnforcer) GetFilteredGroupingPolicy(fieldIndex int, fieldValues ...string) [][]string {
+	return e.load().GetFilteredGroupingPolicy(fieldIndex, fieldValues...)
+}
+
+// GetNamedGroupingPolicy gets all the role inheritance rules in the policy.
+func (e *SnapshotEnforcer) GetNamedGroupingPolicy(ptype string) [][]string {
+	return e.load().GetNamedGroupingPolicy(ptype)
+}
+
+// GetFilteredNamedGroupingPolicy gets all the role inheritance rules in the policy, field filters can be specified.
+func (e *SnapshotEnforcer) GetFilteredNamedGroupingPolicy(ptype string, fieldIndex int, fieldValues ...string) [][]string {
+	return e.load().GetFilteredNamedGroupingPolicy(ptype, fieldIndex, fieldValues...)
+}
+
+// HasPolicy determines whether an authorization rule exists.
+func (e *SnapshotEnforcer) HasPolicy(params ...interface{}) bool {
+	return e.load().HasPolicy(params...)
+}
+
+// HasNamedPolicy determines whether a named authorization rule exists.
+func (e *SnapshotEnforcer) HasNamedPolicy(ptype string, params ...interface{}) bool {
+	return e.load().HasNamedPolicy(ptype, params...)
+}
+
+// AddPolicy adds an authorization rule to the current policy.
+// If the rule already exists, the function returns false and the rule will not be added.
+// Otherwise the function returns true by adding the new rule.
+func (e *SnapshotEnforcer) AddPolicy(params ...interface{}) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddPolicy(params...)
+}
+
+// AddPolicies adds authorization rules to the current policy.
+// If the rule already exists, the function returns false for the corresponding rule and the rule will not be added.
+// Otherwise the function returns true for the corresponding rule by adding the new rule.
+func (e *SnapshotEnforcer) AddPolicies(rules [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddPolicies(rules)
+}
+
+// AddNamedPolicy adds an authorization rule to the current named policy.
+// If the rule already exists, the function returns false and the rule will not be added.
+// Otherwise the function returns true by adding the new rule.
+func (e *SnapshotEnforcer) AddNamedPolicy(ptype string, params ...interface{}) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddNamedPolicy(ptype, params...)
+}
+
+// AddNamedPolicies adds authorization rules to the current named policy.
+// If the rule already exists, the function returns false for the corresponding rule and the rule will not be added.
+// Otherwise the function returns true for the corresponding by adding the new rule.
+func (e *SnapshotEnforcer) AddNamedPolicies(ptype string, rules [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddNamedPolicies(ptype, rules)
+}
+
+// RemovePolicy removes an authorization rule from the current policy.
+func (e *SnapshotEnforcer) RemovePolicy(params ...interface{}) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemovePolicy(params...)
+}
+
+// UpdatePolicy updates an authorization rule from the current policy.
+func (e *SnapshotEnforcer) UpdatePolicy(oldPolicy []string, newPolicy []string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.UpdatePolicy(oldPolicy, newPolicy)
+}
+
+func (e *SnapshotEnforcer) UpdateNamedPolicy(ptype string, p1 []string, p2 []string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.UpdateNamedPolicy(ptype, p1, p2)
+}
+
+// UpdatePolicies updates authorization rules from the current policies.
+func (e *SnapshotEnforcer) UpdatePolicies(oldPolices [][]string, newPolicies [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.UpdatePolicies(oldPolices, newPolicies)
+}
+
+func (e *SnapshotEnforcer) UpdateNamedPolicies(ptype string, p1 [][]string, p2 [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.UpdateNamedPolicies(ptype, p1, p2)
+}
+
+func (e *SnapshotEnforcer) UpdateFilteredPolicies(newPolicies [][]string, fieldIndex int, fieldValues ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.UpdateFilteredPolicies(newPolicies, fieldIndex, fieldValues...)
+}
+
+func (e *SnapshotEnforcer) UpdateFilteredNamedPolicies(ptype string, newPolicies [][]string, fieldIndex int, fieldValues ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.UpdateFilteredNamedPolicies(ptype, newPolicies, fieldIndex, fieldValues...)
+}
+
+// Transaction applies the policy changes made by fn atomically and publishes them once, see
+// Enforcer.Transaction.
+func (e *SnapshotEnforcer) Transaction(fn func(tx *Transaction) error) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.Transaction(fn)
+}
+
+// RemovePolicies removes authorization rules from the current policy.
+func (e *SnapshotEnforcer) RemovePolicies(rules [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemovePolicies(rules)
+}
+
+// RemoveFilteredPolicy removes an authorization rule from the current policy, field filters can be specified.
+func (e *SnapshotEnforcer) RemoveFilteredPolicy(fieldIndex int, fieldValues ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemoveFilteredPolicy(fieldIndex, fieldValues...)
+}
+
+// RemoveNamedPolicy removes an authorization rule from the current named policy.
+func (e *SnapshotEnforcer) RemoveNamedPolicy(ptype string, params ...interface{}) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemoveNamedPolicy(ptype, params...)
+}
+
+// RemoveNamedPolicies removes authorization rules from the current named policy.
+func (e *SnapshotEnforcer) RemoveNamedPolicies(ptype string, rules [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemoveNamedPolicies(ptype, rules)
+}
+
+// RemoveFilteredNamedPolicy removes an authorization rule from the current named policy, field filters can be specified.
+func (e *SnapshotEnforcer) RemoveFilteredNamedPolicy(ptype string, fieldIndex int, fieldValues ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemoveFilteredNamedPolicy(ptype, fieldIndex, fieldValues...)
+}
+
+// HasGroupingPolicy determines whether a role inheritance rule exists.
+func (e *SnapshotEnforcer) HasGroupingPolicy(params ...interface{}) bool {
+	return e.load().HasGroupingPolicy(params...)
+}
+
+// HasNamedGroupingPolicy determines whether a named role inheritance rule exists.
+func (e *SnapshotEnforcer) HasNamedGroupingPolicy(ptype string, params ...interface{}) bool {
+	return e.load().HasNamedGroupingPolicy(ptype, params...)
+}
+
+// AddGroupingPolicy adds a role inheritance rule to the current policy.
+// If the rule already exists, the function returns false and the rule will not be added.
+// Otherwise the function returns true by adding the new rule.
+func (e *SnapshotEnforcer) AddGroupingPolicy(params ...interface{}) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddGroupingPolicy(params...)
+}
+
+// AddGroupingPolicies adds role inheritance rulea to the current policy.
+// If the rule already exists, the function returns false for the corresponding policy rule and the rule will not be added.
+// Otherwise the function returns true for the corresponding policy rule by adding the new rule.
+func (e *SnapshotEnforcer) AddGroupingPolicies(rules [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddGroupingPolicies(rules)
+}
+
+// AddNamedGroupingPolicy adds a named role inheritance rule to the current policy.
+// If the rule already exists, the function returns false and the rule will not be added.
+// Otherwise the function returns true by adding the new rule.
+func (e *SnapshotEnforcer) AddNamedGroupingPolicy(ptype string, params ...interface{}) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddNamedGroupingPolicy(ptype, params...)
+}
+
+// AddNamedGroupingPolicies adds named role inheritance rules to the current policy.
+// If the rule already exists, the function returns false for the corresponding policy rule and the rule will not be added.
+// Otherwise the function returns true for the corresponding policy rule by adding the new rule.
+func (e *SnapshotEnforcer) AddNamedGroupingPolicies(ptype string, rules [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddNamedGroupingPolicies(ptype, rules)
+}
+
+// RemoveGroupingPolicy removes a role inheritance rule from the current policy.
+func (e *SnapshotEnforcer) RemoveGroupingPolicy(params ...interface{}) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemoveGroupingPolicy(params...)
+}
+
+// RemoveGroupingPolicies removes role inheritance rules from the current policy.
+func (e *SnapshotEnforcer) RemoveGroupingPolicies(rules [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemoveGroupingPolicies(rules)
+}
+
+// RemoveFilteredGroupingPolicy removes a role inheritance rule from the current policy, field filters can be specified.
+func (e *SnapshotEnforcer) RemoveFilteredGroupingPolicy(fieldIndex int, fieldValues ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemoveFilteredGroupingPolicy(fieldIndex, fieldValues...)
+}
+
+// RemoveNamedGroupingPolicy removes a role inheritance rule from the current named policy.
+func (e *SnapshotEnforcer) RemoveNamedGroupingPolicy(ptype string, params ...interface{}) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemoveNamedGroupingPolicy(ptype, params...)
+}
+
+// RemoveNamedGroupingPolicies removes role inheritance rules from the current named policy.
+func (e *SnapshotEnforcer) RemoveNamedGroupingPolicies(ptype string, rules [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemoveNamedGroupingPolicies(ptype, rules)
+}
+
+func (e *SnapshotEnforcer) UpdateGroupingPolicy(oldRule []string, newRule []string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.UpdateGroupingPolicy(oldRule, newRule)
+}
+
+func (e *SnapshotEnforcer) UpdateGroupingPolicies(oldRules [][]string, newRules [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.UpdateGroupingPolicies(oldRules, newRules)
+}
+
+func (e *SnapshotEnforcer) UpdateNamedGroupingPolicy(ptype string, oldRule []string, newRule []string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.UpdateNamedGroupingPolicy(ptype, oldRule, newRule)
+}
+
+func (e *SnapshotEnforcer) UpdateNamedGroupingPolicies(ptype string, oldRules [][]string, newRules [][]string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.UpdateNamedGroupingPolicies(ptype, oldRules, newRules)
+}
+
+// RemoveFilteredNamedGroupingPolicy removes a role inheritance rule from the current named policy, field filters can be specified.
+func (e *SnapshotEnforcer) RemoveFilteredNamedGroupingPolicy(ptype string, fieldIndex int, fieldValues ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.RemoveFilteredNamedGroupingPolicy(ptype, fieldIndex, fieldValues...)
+}
+
+// AddFunction adds a customized function.
+func (e *SnapshotEnforcer) AddFunction(name string, function govaluate.ExpressionFunction) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	e.Enforcer.AddFunction(name, function)
+}
+
+// AddFunctionWithSignature adds a customized function with its signature.
+func (e *SnapshotEnforcer) AddFunctionWithSignature(name string, signature model.Signature, function govaluate.ExpressionFunction) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddFunctionWithSignature(name, signature, function)
+}
+
+// ListFunctions returns the functions available to the matchers sorted by name, with their signatures.
+func (e *SnapshotEnforcer) ListFunctions() []model.FunctionInfo {
+	return e.load().ListFunctions()
+}
+
+// Validate validates the current model with the functions of the enforcer.
+func (e *SnapshotEnforcer) Validate() model.Diagnostics {
+	return e.load().Validate()
+}
+
+// EnableStrictMode controls whether the models are validated when they are loaded.
+func (e *SnapshotEnforcer) EnableStrictMode(enable bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.Enforcer.EnableStrictMode(enable)
+}
+
+// SetClock sets the clock of the time functions of the matchers.
+func (e *SnapshotEnforcer) SetClock(clock util.Clock) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	e.Enforcer.SetClock(clock)
+}
+
+// AddAttributeProvider registers the provider of the attributes of the request token.
+func (e *SnapshotEnforcer) AddAttributeProvider(token string, provider AttributeProvider) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	e.Enforcer.AddAttributeProvider(token, provider)
+}
+
+// GetRolesForUser gets the roles that a user has.
+func (e *SnapshotEnforcer) GetRolesForUser(name string, domain ...string) ([]string, error) {
+	return e.load().GetRolesForUser(name, domain...)
+}
+
+// GetUsersForRole gets the users that has a role.
+func (e *SnapshotEnforcer) GetUsersForRole(name string, domain ...string) ([]string, error) {
+	return e.load().GetUsersForRole(name, domain...)
+}
+
+// HasRoleForUser determines whether a user has a role.
+func (e *SnapshotEnforcer) HasRoleForUser(name string, role string, domain ...string) (bool, error) {
+	return e.load().HasRoleForUser(name, role, domain...)
+}
+
+// AddRoleForUser adds a role for a user.
+// Returns false if the user already has the role (aka not affected).
+func (e *SnapshotEnforcer) AddRoleForUser(user string, role string, domain ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddRoleForUser(user, role, domain...)
+}
+
+// AddRolesForUser adds roles for a user.
+// Returns false if the user already has the roles (aka not affected).
+func (e *SnapshotEnforcer) AddRolesForUser(user string, roles []string, domain ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddRolesForUser(user, roles, domain...)
+}
+
+// DeleteRoleForUser deletes a role for a user.
+// Returns false if the user does not have the role (aka not affected).
+func (e *SnapshotEnforcer) DeleteRoleForUser(user string, role string, domain ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.DeleteRoleForUser(user, role, domain...)
+}
+
+// DeleteRolesForUser deletes all roles for a user.
+// Returns false if the user does not have any roles (aka not affected).
+func (e *SnapshotEnforcer) DeleteRolesForUser(user string, domain ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.DeleteRolesForUser(user, domain...)
+}
+
+// DeleteUser deletes a user.
+// Returns false if the user does not exist (aka not affected).
+func (e *SnapshotEnforcer) DeleteUser(user string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.DeleteUser(user)
+}
+
+// DeleteRole deletes a role.
+// Returns false if the role does not exist (aka not affected).
+func (e *SnapshotEnforcer) DeleteRole(role string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.DeleteRole(role)
+}
+
+// DeletePermission deletes a permission.
+// Returns false if the permission does not exist (aka not affected).
+func (e *SnapshotEnforcer) DeletePermission(permission ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.DeletePermission(permission...)
+}
+
+// AddPermissionForUser adds a permission for a user or role.
+// Returns false if the user or role already has the permission (aka not affected).
+func (e *SnapshotEnforcer) AddPermissionForUser(user string, permission ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddPermissionForUser(user, permission...)
+}
+
+// DeletePermissionForUser deletes a permission for a user or role.
+// Returns false if the user or role does not have the permission (aka not affected).
+func (e *SnapshotEnforcer) DeletePermissionForUser(user string, permission ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.DeletePermissionForUser(user, permission...)
+}
+
+// DeletePermissionsForUser deletes permissions for a user or role.
+// Returns false if the user or role does not have any permissions (aka not affected).
+func (e *SnapshotEnforcer) DeletePermissionsForUser(user string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.DeletePermissionsForUser(user)
+}
+
+// GetPermissionsForUser gets permissions for a user or role.
+func (e *SnapshotEnforcer) GetPermissionsForUser(user string, domain ...string) [][]string {
+	return e.load().GetPermissionsForUser(user, domain...)
+}
+
+// HasPermissionForUser determines whether a user has a permission.
+func (e *SnapshotEnforcer) HasPermissionForUser(user string, permission ...string) bool {
+	return e.load().HasPermissionForUser(user, permission...)
+}
+
+// GetImplicitRolesForUser gets implicit roles that a user has.
+// Compared to GetRolesForUser(), this function retrieves indirect roles besides direct roles.
+// For example:
+// g, alice, role:admin
+// g, role:admin, role:user
+//
+// GetRolesForUser("alice") can only get: ["role:admin"].
+// But GetImplicitRolesForUser("alice") will get: ["role:admin", "role:user"].
+func (e *SnapshotEnforcer) GetImplicitRolesForUser(name string, domain ...string) ([]string, error) {
+	return e.load().GetImplicitRolesForUser(name, domain...)
+}
+
+// GetImplicitPermissionsForUser gets implicit permissions for a user or role.
+// Compared to GetPermissionsForUser(), this function retrieves permissions for inherited roles.
+// For example:
+// p, admin, data1, read
+// p, alice, data2, read
+// g, alice, admin
+//
+// GetPermissionsForUser("alice") can only get: [["alice", "data2", "read"]].
+// But GetImplicitPermissionsForUser("alice") will get: [["admin", "data1", "read"], ["alice", "data2", "read"]].
+func (e *SnapshotEnforcer) GetImplicitPermissionsForUser(user string, domain ...string) ([][]string, error) {
+	return e.load().GetImplicitPermissionsForUser(user, domain...)
+}
+
+// GetImplicitUsersForPermission gets implicit users for a permission.
+// For example:
+// p, admin, data1, read
+// p, bob, data1, read
+// g, alice, admin
+//
+// GetImplicitUsersForPermission("data1", "read") will get: ["alice", "bob"].
+// Note: only users will be returned, roles (2nd arg in "g") will be excluded.
+func (e *SnapshotEnforcer) GetImplicitUsersForPermission(permission ...string) ([]string, error) {
+	return e.load().GetImplicitUsersForPermission(permission...)
+}
+
+// GetUsersForRoleInDomain gets the users that has a role inside a domain. Add by Gordon
+func (e *SnapshotEnforcer) GetUsersForRoleInDomain(name string, domain string) []string {
+	return e.load().GetUsersForRoleInDomain(name, domain)
+}
+
+// GetRolesForUserInDomain gets the roles that a user has inside a domain.
+func (e *SnapshotEnforcer) GetRolesForUserInDomain(name string, domain string) []string {
+	return e.load().GetRolesForUserInDomain(name, domain)
+}
+
+// GetPermissionsForUserInDomain gets permissions for a user or role inside a domain.
+func (e *SnapshotEnforcer) GetPermissionsForUserInDomain(user string, domain string) [][]string {
+	return e.load().GetPermissionsForUserInDomain(user, domain)
+}
+
+// AddRoleForUserInDomain adds a role for a user inside a domain.
+// Returns false if the user already has the role (aka not affected).
+func (e *SnapshotEnforcer) AddRoleForUserInDomain(user string, role string, domain string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.AddRoleForUserInDomain(user, role, domain)
+}
+
+// DeleteRoleForUserInDomain deletes a role for a user inside a domain.
+// Returns false if the user does not have the role (aka not affected).
+func (e *SnapshotEnforcer) DeleteRoleForUserInDomain(user string, role string, domain string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.DeleteRoleForUserInDomain(user, role, domain)
+}
+
+// DeleteRolesForUserInDomain deletes all roles for a user inside a domain.
+// Returns false if the user does not have any roles (aka not affected).
+func (e *SnapshotEnforcer) DeleteRolesForUserInDomain(user string, domain string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.publish()
+	return e.Enforcer.DeleteRolesForUserInDomain(user, domain)
+}
@@ -0,0 +1,101 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/bhojpur/policy/pkg/util"
+)
+
+func testEnforceSnapshot(t *testing.T, e *SnapshotEnforcer, sub string, obj interface{}, act string, res bool) {
+	t.Helper()
+	if myRes, _ := e.Enforce(sub, obj, act); myRes != res {
+		t.Errorf("%s, %v, %s: %t, supposed to be %t", sub, obj, act, myRes, res)
+	}
+}
+
+func TestSnapshotEnforcer(t *testing.T) {
+	e, _ := NewSnapshotEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+
+	testEnforceSnapshot(t, e, "alice", "data2", "read", true)
+	testEnforceSnapshot(t, e, "bob", "data1", "read", false)
+
+	_, _ = e.AddPolicy("bob", "data1", "read")
+	_, _ = e.DeleteRoleForUser("alice", "data2_admin")
+	testEnforceSnapshot(t, e, "alice", "data2", "read", false)
+	testEnforceSnapshot(t, e, "bob", "data1", "read", true)
+
+	// a published snapshot isn't changed by later mutations.
+	snapshot := e.load()
+	_, _ = e.AddRoleForUser("alice", "data2_admin")
+	if res, _ := snapshot.Enforce("alice", "data2", "read"); res {
+		t.Error("the snapshot should not see the new role")
+	}
+	testEnforceSnapshot(t, e, "alice", "data2", "read", true)
+
+	if roles, _ := e.GetRolesForUser("alice"); !util.ArrayEquals(roles, []string{"data2_admin"}) {
+		t.Errorf("roles of alice: %v", roles)
+	}
+
+	_ = e.Update(func(e *Enforcer) error {
+		_, _ = e.RemovePolicy("bob", "data1", "read")
+		_, _ = e.AddPolicy("carol", "data1", "read")
+		return nil
+	})
+	testEnforceSnapshot(t, e, "bob", "data1", "read", false)
+	testEnforceSnapshot(t, e, "carol", "data1", "read", true)
+
+	e.EnableEnforce(false)
+	testEnforceSnapshot(t, e, "bob", "data1", "write", true)
+}
+
+func TestSnapshotEnforcerConcurrent(t *testing.T) {
+	e, _ := NewSnapshotEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, _ = e.AddRoleForUser(fmt.Sprintf("user%d", i), "data2_admin")
+			_, _ = e.AddPolicy(fmt.Sprintf("user%d", i), "data3", "read")
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if res, err := e.Enforce("alice", "data2", "read"); !res || err != nil {
+					t.Errorf("alice, data2, read: %t, %v, supposed to be true", res, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	testEnforceSnapshot(t, e, "user99", "data2", "write", true)
+	testEnforceSnapshot(t, e, "user99", "data3", "read", true)
+}
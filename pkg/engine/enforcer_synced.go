@@ -21,13 +21,16 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/Knetic/govaluate"
 
+	"github.com/bhojpur/policy/pkg/model"
 	"github.com/bhojpur/policy/pkg/persist"
+	"github.com/bhojpur/policy/pkg/util"
 )
 
 // SyncedEnforcer wraps Enforcer and provides synchronized access
@@ -155,6 +158,13 @@ func (e *SyncedEnforcer) Enforce(rvals ...interface{}) (bool, error) {
 	return e.Enforcer.Enforce(rvals...)
 }
 
+// EnforceCtx decides like Enforce, but gives up with the context's error once ctx is cancelled or its deadline passes.
+func (e *SyncedEnforcer) EnforceCtx(ctx context.Context, rvals ...interface{}) (bool, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.EnforceCtx(ctx, rvals...)
+}
+
 // EnforceWithMatcher use a custom matcher to decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (matcher, sub, obj, act), use model matcher by default when matcher is "".
 func (e *SyncedEnforcer) EnforceWithMatcher(matcher string, rvals ...interface{}) (bool, error) {
 	e.m.RLock()
@@ -169,6 +179,13 @@ func (e *SyncedEnforcer) EnforceEx(rvals ...interface{}) (bool, []string, error)
 	return e.Enforcer.EnforceEx(rvals...)
 }
 
+// EnforceExCtx explain enforcement by informing matched rules, giving up once ctx is done.
+func (e *SyncedEnforcer) EnforceExCtx(ctx context.Context, rvals ...interface{}) (bool, []string, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.EnforceExCtx(ctx, rvals...)
+}
+
 // EnforceExWithMatcher use a custom matcher and explain enforcement by informing matched rules
 func (e *SyncedEnforcer) EnforceExWithMatcher(matcher string, rvals ...interface{}) (bool, []string, error) {
 	e.m.RLock()
@@ -176,6 +193,34 @@ func (e *SyncedEnforcer) EnforceExWithMatcher(matcher string, rvals ...interface
 	return e.Enforcer.EnforceExWithMatcher(matcher, rvals...)
 }
 
+// EnforceWithTrace decides like Enforce and returns how the decision was reached.
+func (e *SyncedEnforcer) EnforceWithTrace(rvals ...interface{}) (bool, *DecisionTrace, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.EnforceWithTrace(rvals...)
+}
+
+// EnforceWithObligations decides like Enforce and returns the obligations of the matched rules that have the effect of the decision.
+func (e *SyncedEnforcer) EnforceWithObligations(rvals ...interface{}) (bool, []string, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.EnforceWithObligations(rvals...)
+}
+
+// Decide decides like Enforce and returns the full decision.
+func (e *SyncedEnforcer) Decide(rvals ...interface{}) (*Decision, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.Decide(rvals...)
+}
+
+// PartialEnforce answers which values of the free request tokens could be allowed.
+func (e *SyncedEnforcer) PartialEnforce(rvals ...interface{}) (*PartialResult, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.PartialEnforce(rvals...)
+}
+
 // BatchEnforce enforce in batches
 func (e *SyncedEnforcer) BatchEnforce(requests [][]interface{}) ([]bool, error) {
 	e.m.RLock()
@@ -183,6 +228,13 @@ func (e *SyncedEnforcer) BatchEnforce(requests [][]interface{}) ([]bool, error)
 	return e.Enforcer.BatchEnforce(requests)
 }
 
+// BatchEnforceCtx enforce in batches, giving up once ctx is done.
+func (e *SyncedEnforcer) BatchEnforceCtx(ctx context.Context, requests [][]interface{}) ([]bool, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.BatchEnforceCtx(ctx, requests)
+}
+
 // BatchEnforceWithMatcher enforce with matcher in batches
 func (e *SyncedEnforcer) BatchEnforceWithMatcher(matcher string, requests [][]interface{}) ([]bool, error) {
 	e.m.RLock()
@@ -190,6 +242,20 @@ func (e *SyncedEnforcer) BatchEnforceWithMatcher(matcher string, requests [][]in
 	return e.Enforcer.BatchEnforceWithMatcher(matcher, requests)
 }
 
+// BatchEnforceParallel enforce in batches with a pool of workers, the read lock is held once for the whole batch.
+func (e *SyncedEnforcer) BatchEnforceParallel(requests [][]interface{}, workers int) ([]bool, []error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.BatchEnforceParallel(requests, workers)
+}
+
+// BatchEnforceWithMatcherParallel enforce with matcher in batches with a pool of workers, the read lock is held once for the whole batch.
+func (e *SyncedEnforcer) BatchEnforceWithMatcherParallel(matcher string, requests [][]interface{}, workers int) ([]bool, []error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.BatchEnforceWithMatcherParallel(matcher, requests, workers)
+}
+
 // GetAllSubjects gets the list of subjects that show up in the current policy.
 func (e *SyncedEnforcer) GetAllSubjects() []string {
 	e.m.RLock()
@@ -397,6 +463,14 @@ func (e *SyncedEnforcer) UpdateFilteredNamedPolicies(ptype string, newPolicies [
 	return e.Enforcer.UpdateFilteredNamedPolicies(ptype, newPolicies, fieldIndex, fieldValues...)
 }
 
+// Transaction applies the policy changes made by fn atomically, see Enforcer.Transaction. The lock is
+// held while fn runs, so fn must not call the enforcer.
+func (e *SyncedEnforcer) Transaction(fn func(tx *Transaction) error) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.Transaction(fn)
+}
+
 // RemovePolicies removes authorization rules from the current policy.
 func (e *SyncedEnforcer) RemovePolicies(rules [][]string) (bool, error) {
 	e.m.Lock()
@@ -554,3 +628,59 @@ func (e *SyncedEnforcer) AddFunction(name string, function govaluate.ExpressionF
 	defer e.m.Unlock()
 	e.Enforcer.AddFunction(name, function)
 }
+
+// AddFunctionWithSignature adds a customized function with its signature.
+func (e *SyncedEnforcer) AddFunctionWithSignature(name string, signature model.Signature, function govaluate.ExpressionFunction) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.AddFunctionWithSignature(name, signature, function)
+}
+
+// ListFunctions returns the functions available to the matchers sorted by name, with their signatures.
+func (e *SyncedEnforcer) ListFunctions() []model.FunctionInfo {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.ListFunctions()
+}
+
+// CheckModel type-checks the matchers of m against the functions SetModel would set along with it.
+func (e *SyncedEnforcer) CheckModel(m model.Model) error {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.CheckModel(m)
+}
+
+// Validate validates the current model with the functions of the enforcer.
+func (e *SyncedEnforcer) Validate() model.Diagnostics {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.Validate()
+}
+
+// EnablePolicyIndex controls whether the policy rules are indexed by the tokens the matchers compare for equality with the request.
+func (e *SyncedEnforcer) EnablePolicyIndex(enable bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.Enforcer.EnablePolicyIndex(enable)
+}
+
+// EnableStrictMode controls whether the models are validated when they are loaded.
+func (e *SyncedEnforcer) EnableStrictMode(enable bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.Enforcer.EnableStrictMode(enable)
+}
+
+// SetClock sets the clock of the time functions of the matchers.
+func (e *SyncedEnforcer) SetClock(clock util.Clock) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.Enforcer.SetClock(clock)
+}
+
+// AddAttributeProvider registers the provider of the attributes of the request token.
+func (e *SyncedEnforcer) AddAttributeProvider(token string, provider AttributeProvider) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.Enforcer.AddAttributeProvider(token, provider)
+}
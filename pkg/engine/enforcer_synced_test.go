@@ -21,6 +21,7 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"strconv"
 	"testing"
 	"time"
 )
@@ -63,3 +64,40 @@ func TestStopAutoLoadPolicy(t *testing.T) {
 		t.Error("auto load is still running")
 	}
 }
+
+func TestSyncedBatchEnforceParallel(t *testing.T) {
+	e, _ := NewSyncedEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+
+	requests := [][]interface{}{{"alice", "data2", "read"}, {"bob", "data2", "write"}, {"bob", "data1", "read"}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			_, _ = e.AddPolicy("carol", "data3", strconv.Itoa(i))
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		res, errs := e.BatchEnforceParallel(requests, 3)
+		if !res[0] || !res[1] || res[2] || errs[0] != nil || errs[1] != nil || errs[2] != nil {
+			t.Fatalf("batch: %v, %v", res, errs)
+		}
+	}
+	<-done
+}
+
+func TestSyncedEnablePolicyIndex(t *testing.T) {
+	e, _ := NewSyncedEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			e.EnablePolicyIndex(i%2 == 0)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		testEnforceSync(t, e, "alice", "data2", "read", true)
+		testEnforceSync(t, e, "bob", "data1", "read", false)
+	}
+	<-done
+}
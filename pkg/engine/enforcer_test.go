@@ -21,10 +21,18 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/bhojpur/policy/pkg/effector"
 	"github.com/bhojpur/policy/pkg/model"
+	"github.com/bhojpur/policy/pkg/persist"
 	fileadapter "github.com/bhojpur/policy/pkg/persist/file-adapter"
 	"github.com/bhojpur/policy/pkg/util"
 )
@@ -517,6 +525,40 @@ func TestBatchEnforce(t *testing.T) {
 	testBatchEnforce(t, e, [][]interface{}{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"jack", "data3", "read"}}, results)
 }
 
+func TestBatchEnforceParallel(t *testing.T) {
+	e, _ := NewEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+
+	var requests [][]interface{}
+	var results []bool
+	for i := 0; i < 100; i++ {
+		requests = append(requests, []interface{}{"alice", "data2", "read"}, []interface{}{"bob", "data1", "read"})
+		results = append(results, true, false)
+	}
+	// a malformed request only fails itself.
+	requests = append(requests, []interface{}{"alice", "data1"})
+	results = append(results, false)
+
+	for _, workers := range []int{0, 1, 4, 1000} {
+		myRes, errs := e.BatchEnforceParallel(requests, workers)
+		if len(myRes) != len(results) || len(errs) != len(results) {
+			t.Fatalf("workers %d: %d results, %d errors, supposed to be %d", workers, len(myRes), len(errs), len(results))
+		}
+		for i := range results {
+			if myRes[i] != results[i] {
+				t.Errorf("workers %d: request %d: %t supposed to be %t", workers, i, myRes[i], results[i])
+			}
+			if (errs[i] != nil) != (i == len(results)-1) {
+				t.Errorf("workers %d: request %d: unexpected error %v", workers, i, errs[i])
+			}
+		}
+	}
+
+	myRes, errs := e.BatchEnforceWithMatcherParallel("r.sub == p.sub && r.obj == p.obj", [][]interface{}{{"alice", "data2", "read"}, {"bob", "data2", "read"}}, 2)
+	if myRes[0] || !myRes[1] || errs[0] != nil || errs[1] != nil {
+		t.Errorf("batch with matcher: %v, %v", myRes, errs)
+	}
+}
+
 func TestSubjectPriority(t *testing.T) {
 	e, _ := NewEnforcer("../../examples/subject_priority_model.conf", "../../examples/subject_priority_policy.csv")
 	testBatchEnforce(t, e, [][]interface{}{
@@ -611,3 +653,366 @@ func TestEvalPriority(t *testing.T) {
 	testEnforce(t, e, "admin", "none", "write", false)
 	testEnforce(t, e, "user", "users", "write", false)
 }
+
+func TestMatcherCache(t *testing.T) {
+	e, _ := NewEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+	testEnforce(t, e, "bob", "data2", "read", false)
+
+	// the role links are looked up by every enforcement, changing them keeps the compiled matcher.
+	matcher := e.GetModel()["m"]["m"].Value
+	_, _ = e.AddGroupingPolicy("bob", "data2_admin")
+	testEnforce(t, e, "bob", "data2", "read", true)
+	_, _ = e.RemoveGroupingPolicy("bob", "data2_admin")
+	testEnforce(t, e, "bob", "data2", "read", false)
+	if _, ok := e.matcherMap.Load(matcher); !ok {
+		t.Error("the compiled matcher should be kept when the role links change")
+	}
+
+	// the g() results are only memorized for a single enforcement, the links changed through the
+	// role manager are seen by the next one.
+	_ = e.GetRoleManager().AddLink("bob", "data2_admin")
+	testEnforce(t, e, "bob", "data2", "read", true)
+	_ = e.GetRoleManager().DeleteLink("bob", "data2_admin")
+	testEnforce(t, e, "bob", "data2", "read", false)
+	_, _ = e.BatchEnforceParallel([][]interface{}{{"bob", "data2", "read"}}, 2)
+	_ = e.GetRoleManager().AddLink("bob", "data2_admin")
+	if res, _ := e.BatchEnforceParallel([][]interface{}{{"bob", "data2", "read"}, {"carol", "data2", "read"}}, 2); !res[0] || res[1] {
+		t.Errorf("parallel enforcement after a role link change: %v, supposed to be [true false]", res)
+	}
+
+	e.AddFunction("isAlice", func(args ...interface{}) (interface{}, error) {
+		return args[0].(string) == "alice", nil
+	})
+	if res, err := e.EnforceWithMatcher("isAlice(r.sub)", "alice", "data2", "read"); err != nil || !res {
+		t.Errorf("custom matcher: %t, %v, supposed to be true", res, err)
+	}
+	if res, _ := e.EnforceWithMatcher("isAlice(r.sub)", "bob", "data2", "read"); res {
+		t.Error("custom matcher: true, supposed to be false")
+	}
+
+	// the compiled matchers are bounded.
+	for i := 0; i <= maxCompiledMatchers; i++ {
+		_, _ = e.EnforceWithMatcher(fmt.Sprintf("isAlice(r.sub) && %d == %d", i, i), "alice", "data2", "read")
+	}
+	if compiled := atomic.LoadInt32(&e.compiled); compiled > maxCompiledMatchers {
+		t.Errorf("compiled matchers: %d, supposed to be at most %d", compiled, maxCompiledMatchers)
+	}
+
+	e, _ = NewEnforcer("../../examples/eval_operator_model.conf", "../../examples/eval_operator_policy.csv")
+	testEnforce(t, e, "admin", "users", "write", true)
+	testEnforce(t, e, "user", "users", "write", false)
+
+	// the compiled eval() rule of an updated row must not be reused.
+	_, _ = e.UpdatePolicy(
+		[]string{"r.sub == 'admin' || false", "r.obj == 'users'", "write"},
+		[]string{"r.sub == 'user'", "r.obj == 'users'", "write"})
+	testEnforce(t, e, "admin", "users", "write", false)
+	testEnforce(t, e, "user", "users", "write", true)
+}
+
+func TestPolicyIndex(t *testing.T) {
+	tests := []struct {
+		model, policy string
+		requests      [][]interface{}
+	}{
+		{"rbac_model.conf", "rbac_policy.csv", [][]interface{}{
+			{"alice", "data1", "read"}, {"alice", "data2", "write"}, {"bob", "data1", "read"},
+			{"bob", "data2", "write"}, {"cathy", "data3", "read"},
+		}},
+		{"rbac_with_deny_model.conf", "rbac_with_deny_policy.csv", [][]interface{}{
+			{"alice", "data1", "read"}, {"alice", "data2", "write"}, {"alice", "data2", "read"},
+			{"bob", "data2", "write"}, {"cathy", "data3", "read"},
+		}},
+		{"priority_model.conf", "priority_policy.csv", [][]interface{}{
+			{"alice", "data1", "read"}, {"alice", "data1", "write"}, {"bob", "data2", "read"},
+			{"bob", "data2", "write"}, {"cathy", "data3", "read"},
+		}},
+		{"rbac_with_domains_model.conf", "rbac_with_domains_policy.csv", [][]interface{}{
+			{"alice", "domain1", "data1", "read"}, {"alice", "domain1", "data2", "read"},
+			{"bob", "domain2", "data2", "write"}, {"bob", "domain1", "data1", "write"},
+		}},
+	}
+
+	for _, test := range tests {
+		e, _ := NewEnforcer("../../examples/"+test.model, "../../examples/"+test.policy)
+		expected, _ := e.BatchEnforce(test.requests)
+		e.EnablePolicyIndex(true)
+		testBatchEnforce(t, e, test.requests, expected)
+
+		// the index is rebuilt when the policy is reloaded.
+		_ = e.LoadPolicy()
+		testBatchEnforce(t, e, test.requests, expected)
+	}
+
+	e, _ := NewEnforcer("../../examples/rbac_with_deny_model.conf", "../../examples/rbac_with_deny_policy.csv")
+	e.EnablePolicyIndex(true)
+	testEnforce(t, e, "alice", "data2", "write", false)
+	_, _ = e.RemovePolicy("alice", "data2", "write", "deny")
+	testEnforce(t, e, "alice", "data2", "write", true)
+	_, _ = e.AddPolicy("alice", "data2", "write", "deny")
+	testEnforce(t, e, "alice", "data2", "write", false)
+	e.EnablePolicyIndex(false)
+	testEnforce(t, e, "alice", "data2", "write", false)
+
+	// rules loaded behind the index are still evaluated, by scanning all the rules.
+	e, _ = NewEnforcer("../../examples/basic_model.conf", "../../examples/basic_policy.csv")
+	e.EnablePolicyIndex(true)
+	persist.LoadPolicyArray([]string{"p", "cathy", "data3", "read"}, e.GetModel())
+	testEnforce(t, e, "cathy", "data3", "read", true)
+	testEnforce(t, e, "alice", "data1", "read", true)
+}
+
+func TestEnforceCtx(t *testing.T) {
+	e, _ := NewEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+
+	if res, err := e.EnforceCtx(context.Background(), "alice", "data2", "read"); err != nil || !res {
+		t.Errorf("alice, data2, read: %t, %v, supposed to be true", res, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := e.EnforceCtx(ctx, "alice", "data2", "read"); err != context.Canceled {
+		t.Errorf("cancelled enforcement: %v, supposed to be %v", err, context.Canceled)
+	}
+	if _, _, err := e.EnforceExCtx(ctx, "alice", "data2", "read"); err != context.Canceled {
+		t.Errorf("cancelled enforcement: %v, supposed to be %v", err, context.Canceled)
+	}
+	if res, err := e.BatchEnforceCtx(ctx, [][]interface{}{{"alice", "data1", "read"}}); err != context.Canceled || len(res) != 0 {
+		t.Errorf("cancelled batch: %v, %v, supposed to be %v", res, err, context.Canceled)
+	}
+
+	// the functions receive the context, and the enforcement gives up once they see it cancelled.
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+[policy_definition]
+p = sub, obj, act
+[policy_effect]
+e = some(where (p.eft == allow))
+[matchers]
+m = cancelMatch(ctx, r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`)
+	e, _ = NewEnforcer(m, fileadapter.NewAdapter("../../examples/basic_policy.csv"))
+	e.AddFunction("cancelMatch", func(args ...interface{}) (interface{}, error) {
+		cancel()
+		return args[1].(string) == args[2].(string), nil
+	})
+	ctx, cancel = context.WithCancel(context.Background())
+	if _, err := e.EnforceCtx(ctx, "bob", "data2", "write"); err != context.Canceled {
+		t.Errorf("enforcement cancelled by a function: %v, supposed to be %v", err, context.Canceled)
+	}
+	cancel = func() {}
+	if res, err := e.EnforceCtx(context.Background(), "bob", "data2", "write"); err != nil || !res {
+		t.Errorf("bob, data2, write: %t, %v, supposed to be true", res, err)
+	}
+
+	// so does the enforcement without any policy rule.
+	e.ClearPolicy()
+	ctx, cancel = context.WithCancel(context.Background())
+	if _, err := e.EnforceCtx(ctx, "bob", "data2", "write"); err != context.Canceled {
+		t.Errorf("enforcement without rules cancelled by a function: %v, supposed to be %v", err, context.Canceled)
+	}
+	cancel()
+}
+
+func TestEnforceWithTrace(t *testing.T) {
+	e, _ := NewEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+
+	res, trace, err := e.EnforceWithTrace("alice", "data2", "read")
+	if err != nil || !res {
+		t.Fatalf("alice, data2, read: %t, %v, supposed to be true", res, err)
+	}
+	if trace.Request["r.sub"] != "alice" || trace.Request["r.obj"] != "data2" || trace.Request["r.act"] != "read" {
+		t.Errorf("trace request: %v", trace.Request)
+	}
+	if trace.Effect != "allow" || !trace.Result || !util.ArrayEquals(trace.Explain, []string{"data2_admin", "data2", "read"}) {
+		t.Errorf("trace effect: %s, %t, %v", trace.Effect, trace.Result, trace.Explain)
+	}
+
+	expected := []RuleTrace{
+		{Index: 0, Rule: []string{"alice", "data1", "read"}, Evaluated: true, Matched: false, Effect: "allow"},
+		{Index: 1, Rule: []string{"bob", "data2", "write"}, Evaluated: true, Matched: false, Effect: "allow"},
+		{Index: 2, Rule: []string{"data2_admin", "data2", "read"}, Evaluated: true, Matched: true, Effect: "allow"},
+		{Index: 3, Rule: []string{"data2_admin", "data2", "write"}},
+	}
+	if !reflect.DeepEqual(trace.Rules, expected) {
+		t.Errorf("trace rules: %v, supposed to be %v", trace.Rules, expected)
+	}
+
+	links := []RoleLinkTrace{
+		{PType: "g", Name1: "alice", Name2: "alice", Result: true},
+		{PType: "g", Name1: "alice", Name2: "bob", Result: false},
+		{PType: "g", Name1: "alice", Name2: "data2_admin", Result: true},
+	}
+	if !reflect.DeepEqual(trace.RoleLinks, links) {
+		t.Errorf("trace role links: %v, supposed to be %v", trace.RoleLinks, links)
+	}
+
+	if _, err := json.Marshal(trace); err != nil {
+		t.Errorf("trace should be serialisable: %v", err)
+	}
+
+	res, trace, _ = e.EnforceWithTrace("bob", "data1", "read")
+	if res || trace.Effect != "indeterminate" || trace.Explain != nil {
+		t.Errorf("bob, data1, read: %t, %s, %v", res, trace.Effect, trace.Explain)
+	}
+
+	// repeated lookups are answered by the memo of the enforcement, not by the role manager.
+	_, trace, _ = e.EnforceWithTrace("alice", "data2", "write")
+	links = []RoleLinkTrace{
+		{PType: "g", Name1: "alice", Name2: "alice", Result: true},
+		{PType: "g", Name1: "alice", Name2: "bob", Result: false},
+		{PType: "g", Name1: "alice", Name2: "data2_admin", Result: true},
+		{PType: "g", Name1: "alice", Name2: "data2_admin", Result: true, Cached: true},
+	}
+	if !reflect.DeepEqual(trace.RoleLinks, links) {
+		t.Errorf("trace role links: %v, supposed to be %v", trace.RoleLinks, links)
+	}
+}
+
+func TestPolicyEffectExpression(t *testing.T) {
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+[policy_definition]
+p = sub, obj, act, eft
+[role_definition]
+g = _, _
+[policy_effect]
+e = some( where(p.eft==allow) ) && !( some(where p.eft == deny) )
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _ := NewEnforcer(m, fileadapter.NewAdapter("../../examples/rbac_with_deny_policy.csv"))
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "alice", "data2", "read", true)
+	testEnforce(t, e, "alice", "data2", "write", false)
+
+	_, err = model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+[policy_definition]
+p = sub, obj, act
+[policy_effect]
+e = some(where (p.eft == allow)) &&
+[matchers]
+m = r.sub == p.sub
+`)
+	if err == nil {
+		t.Error("a malformed policy effect should fail to load")
+	}
+}
+
+func TestCombiningAlgorithmEffect(t *testing.T) {
+	text := `
+[request_definition]
+r = sub, obj, act
+[policy_definition]
+p = sub, obj, act, eft
+[role_definition]
+g = _, _
+[policy_effect]
+e = %s
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`
+	testEffect := func(effect string, sub string, obj string, act string, res string) {
+		t.Helper()
+		m, err := model.NewModelFromString(fmt.Sprintf(text, effect))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, _ := NewEnforcer(m, fileadapter.NewAdapter("../../examples/rbac_with_deny_policy.csv"))
+		ok, trace, err := e.EnforceWithTrace(sub, obj, act)
+		if err != nil || trace.Effect != res || ok != (res == "allow") {
+			t.Errorf("%s: %s, %s, %s: %t, %s, %v, supposed to be %s", effect, sub, obj, act, ok, trace.Effect, err, res)
+		}
+	}
+
+	testEffect("first-applicable", "alice", "data2", "write", "allow")
+	testEffect("ordered-deny-overrides", "alice", "data2", "write", "deny")
+	testEffect("only-one-applicable", "alice", "data2", "write", "indeterminate")
+	testEffect("only-one-applicable", "bob", "data2", "write", "allow")
+	testEffect("first-applicable", "bob", "data1", "read", "not-applicable")
+	testEffect("deny-unless-permit", "bob", "data1", "read", "deny")
+	testEffect("permit-unless-deny", "bob", "data1", "read", "allow")
+}
+
+func testEnforceWithObligations(t *testing.T, e IEnforcer, sub string, obj string, act string, res bool, obligations []string) {
+	t.Helper()
+	myRes, myObligations, err := e.EnforceWithObligations(sub, obj, act)
+	if err != nil || myRes != res || !util.ArrayEquals(myObligations, obligations) {
+		t.Errorf("%s, %s, %s: %t, %v, %v, supposed to be %t, %v", sub, obj, act, myRes, myObligations, err, res, obligations)
+	}
+}
+
+func TestEnforceWithObligations(t *testing.T) {
+	e, _ := NewEnforcer("../../examples/obligations_model.conf", "../../examples/obligations_policy.csv")
+	testEnforceWithObligations(t, e, "alice", "record", "read", true, []string{"mask ssn", "log audit"})
+	testEnforceWithObligations(t, e, "alice", "record", "write", true, []string{"require mfa"})
+	testEnforceWithObligations(t, e, "bob", "record", "read", false, []string{"log audit"})
+	testEnforceWithObligations(t, e, "carol", "record", "read", false, []string{})
+
+	se, _ := NewSyncedEnforcer("../../examples/obligations_model.conf", "../../examples/obligations_policy.csv")
+	testEnforceWithObligations(t, se, "alice", "record", "read", true, []string{"mask ssn", "log audit"})
+
+	// rules without obligations have none.
+	e, _ = NewEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+	testEnforceWithObligations(t, e, "alice", "data1", "read", true, []string{})
+}
+
+func testDecide(t *testing.T, e IEnforcer, sub string, obj string, act string, effect effector.Effect, rule []string) {
+	t.Helper()
+	decision, err := e.Decide(sub, obj, act)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Effect != effect || !util.ArrayEquals(decision.Rule, rule) || decision.Allowed() != (effect == effector.Allow) || decision.Reason == "" {
+		t.Errorf("%s, %s, %s: %s %v %q, supposed to be %s %v", sub, obj, act, decision.Effect, decision.Rule, decision.Reason, effect, rule)
+	}
+}
+
+func TestDecide(t *testing.T) {
+	e, _ := NewEnforcer("../../examples/basic_model.conf", "../../examples/basic_policy.csv")
+	testDecide(t, e, "alice", "data1", "read", effector.Allow, []string{"alice", "data1", "read"})
+	testDecide(t, e, "alice", "data2", "read", effector.NotApplicable, nil)
+
+	// the effects are written by name in JSON.
+	decision, _ := e.Decide("alice", "data1", "read")
+	data, err := json.Marshal(decision)
+	if err != nil || !strings.HasPrefix(string(data), `{"effect":"allow","rule":["alice","data1","read"],`) {
+		t.Errorf("decision JSON: %s, %v", data, err)
+	}
+	var decoded Decision
+	if err := json.Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(decoded, *decision) {
+		t.Errorf("decoded decision: %+v, %v, supposed to be %+v", decoded, err, *decision)
+	}
+	if err := json.Unmarshal([]byte(`{"effect":"maybe"}`), &decoded); err == nil {
+		t.Error("an unknown effect should not be decoded")
+	}
+
+	e, _ = NewEnforcer("../../examples/obligations_model.conf", "../../examples/obligations_policy.csv")
+	testDecide(t, e, "bob", "record", "read", effector.Deny, []string{"bob", "record", "read", "deny", "log audit"})
+	testDecide(t, e, "carol", "record", "read", effector.NotApplicable, nil)
+
+	decision, _ = e.Decide("alice", "record", "read")
+	if len(decision.Matched) != 2 || !util.ArrayEquals(decision.Obligations, []string{"mask ssn", "log audit"}) {
+		t.Errorf("alice, record, read: %v %v", decision.Matched, decision.Obligations)
+	}
+
+	se, _ := NewSyncedEnforcer("../../examples/basic_model.conf", "../../examples/basic_policy.csv")
+	testDecide(t, se, "bob", "data2", "write", effector.Allow, []string{"bob", "data2", "write"})
+
+	e.EnableEnforce(false)
+	testDecide(t, e, "carol", "record", "read", effector.Allow, nil)
+	e.EnableEnforce(true)
+
+	// an error leaves the decision indeterminate.
+	decision, err = e.Decide("alice", "record")
+	if err == nil || decision.Effect != effector.Indeterminate || decision.Reason != err.Error() {
+		t.Errorf("invalid request: %s %q, %v", decision.Effect, decision.Reason, err)
+	}
+}
@@ -134,6 +134,7 @@ func (e *Enforcer) removePolicy(sec string, ptype string, rule []string) (bool,
 	if !ruleRemoved {
 		return ruleRemoved, nil
 	}
+	e.invalidateEvalRules(ptype, [][]string{rule})
 
 	if sec == "g" {
 		err := e.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, [][]string{rule})
@@ -172,6 +173,7 @@ func (e *Enforcer) updatePolicy(sec string, ptype string, oldRule []string, newR
 	if !ruleUpdated {
 		return ruleUpdated, nil
 	}
+	e.invalidateEvalRules(ptype, [][]string{oldRule})
 
 	if sec == "g" {
 		err := e.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, [][]string{oldRule}) // remove the old rule
@@ -214,6 +216,7 @@ func (e *Enforcer) updatePolicies(sec string, ptype string, oldRules [][]string,
 	if !ruleUpdated {
 		return ruleUpdated, nil
 	}
+	e.invalidateEvalRules(ptype, oldRules)
 
 	if sec == "g" {
 		err := e.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, oldRules) // remove the old rules
@@ -261,6 +264,7 @@ func (e *Enforcer) removePolicies(sec string, ptype string, rules [][]string) (b
 	if !rulesRemoved {
 		return rulesRemoved, nil
 	}
+	e.invalidateEvalRules(ptype, rules)
 
 	if sec == "g" {
 		err := e.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, rules)
@@ -304,6 +308,7 @@ func (e *Enforcer) removeFilteredPolicy(sec string, ptype string, fieldIndex int
 	if !ruleRemoved {
 		return ruleRemoved, nil
 	}
+	e.invalidateEvalRules(ptype, effects)
 
 	if sec == "g" {
 		err := e.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, effects)
@@ -343,6 +348,7 @@ func (e *Enforcer) updateFilteredPolicies(sec string, ptype string, newRules [][
 	}
 
 	ruleChanged := e.model.RemovePolicies(sec, ptype, oldRules)
+	e.invalidateEvalRules(ptype, oldRules)
 	e.model.AddPolicies(sec, ptype, newRules)
 	ruleChanged = ruleChanged && len(newRules) != 0
 	if !ruleChanged {
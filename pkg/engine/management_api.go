@@ -20,7 +20,12 @@ package engine
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-import "github.com/Knetic/govaluate"
+import (
+	"sort"
+
+	"github.com/Knetic/govaluate"
+	"github.com/bhojpur/policy/pkg/model"
+)
 
 // GetAllSubjects gets the list of subjects that show up in the current policy.
 func (e *Enforcer) GetAllSubjects() []string {
@@ -342,7 +347,40 @@ func (e *Enforcer) RemoveFilteredNamedGroupingPolicy(ptype string, fieldIndex in
 	return e.removeFilteredPolicy("g", ptype, fieldIndex, fieldValues...)
 }
 
-// AddFunction adds a customized function.
+// AddFunction adds a customized function, it replaces the function of the same name, built-in ones included.
+// The function receives the context of EnforceCtx when the matcher passes it the "ctx" variable.
 func (e *Enforcer) AddFunction(name string, function govaluate.ExpressionFunction) {
 	e.fm.AddFunction(name, function)
+	e.invalidateMatcherMap()
+}
+
+// AddFunctionWithSignature adds a customized function with its signature. The matchers of the current model
+// are type-checked against the signature, and the function is only called with the arguments it declares.
+// It replaces the function of the same name and its signature.
+func (e *Enforcer) AddFunctionWithSignature(name string, signature model.Signature, function govaluate.ExpressionFunction) error {
+	err := e.model.CheckFunctionCalls(func(fn string) (model.Signature, bool) {
+		if fn == name {
+			return signature, true
+		}
+		return e.fm.GetSignature(fn)
+	})
+	if err != nil {
+		return err
+	}
+
+	e.fm.AddFunctionWithSignature(name, signature, function)
+	e.invalidateMatcherMap()
+	return nil
+}
+
+// ListFunctions returns the functions available to the matchers sorted by name, with their signatures,
+// including the g() functions of the role definitions.
+func (e *Enforcer) ListFunctions() []model.FunctionInfo {
+	functions := e.fm.ListFunctions()
+	for name := range e.model["g"] {
+		signature, _ := e.model.GetSignature(name)
+		functions = append(functions, model.FunctionInfo{Name: name, Signature: &signature})
+	}
+	sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+	return functions
 }
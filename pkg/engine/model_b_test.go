@@ -148,6 +148,38 @@ func BenchmarkRBACModelLarge(b *testing.B) {
 	}
 }
 
+func BenchmarkRBACModelLargeWithIndex(b *testing.B) {
+	e, _ := NewEnforcer("../../examples/rbac_model.conf", false)
+	e.EnablePolicyIndex(true)
+
+	// 10000 roles, 1000 resources.
+	pPolicies := make([][]string, 0)
+	for i := 0; i < 10000; i++ {
+		pPolicies = append(pPolicies, []string{fmt.Sprintf("group%d", i), fmt.Sprintf("data%d", i/10), "read"})
+	}
+
+	_, err := e.AddPolicies(pPolicies)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// 100000 users.
+	gPolicies := make([][]string, 0)
+	for i := 0; i < 100000; i++ {
+		gPolicies = append(gPolicies, []string{fmt.Sprintf("user%d", i), fmt.Sprintf("group%d", i/10)})
+	}
+
+	_, err = e.AddGroupingPolicies(gPolicies)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = e.Enforce("user50001", "data999", "read")
+	}
+}
+
 func BenchmarkRBACModelWithResourceRoles(b *testing.B) {
 	e, _ := NewEnforcer("../../examples/rbac_with_resource_roles_model.conf", "../../examples/rbac_with_resource_roles_policy.csv", false)
 
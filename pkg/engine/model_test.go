@@ -21,10 +21,16 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/bhojpur/policy/pkg/effector"
 	"github.com/bhojpur/policy/pkg/log"
+	"github.com/bhojpur/policy/pkg/model"
 	fileadapter "github.com/bhojpur/policy/pkg/persist/file-adapter"
 	"github.com/bhojpur/policy/pkg/rbac"
 	"github.com/bhojpur/policy/pkg/util"
@@ -155,6 +161,41 @@ func TestRBACModel(t *testing.T) {
 	testEnforce(t, e, "bob", "data2", "write", true)
 }
 
+func TestRBACModelFromDocuments(t *testing.T) {
+	for _, modelPath := range []string{"../../examples/rbac_model.json", "../../examples/rbac_model.yaml"} {
+		e, err := NewEnforcer(modelPath, "../../examples/rbac_policy.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testEnforce(t, e, "alice", "data1", "read", true)
+		testEnforce(t, e, "alice", "data1", "write", false)
+		testEnforce(t, e, "alice", "data2", "read", true)
+		testEnforce(t, e, "alice", "data2", "write", true)
+		testEnforce(t, e, "bob", "data1", "read", false)
+		testEnforce(t, e, "bob", "data1", "write", false)
+		testEnforce(t, e, "bob", "data2", "read", false)
+		testEnforce(t, e, "bob", "data2", "write", true)
+	}
+}
+
+func TestRBACModelWithInclude(t *testing.T) {
+	e, err := NewEnforcer("../../examples/rbac_with_include_model.conf", "../../examples/rbac_policy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(e.GetModel()["m"]["m"].Value, "keyMatch") {
+		t.Errorf("the matcher should override the included one, got %s", e.GetModel()["m"]["m"].Value)
+	}
+
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "alice", "data1", "write", false)
+	testEnforce(t, e, "alice", "data2", "read", true)
+	testEnforce(t, e, "alice", "data2", "write", true)
+	testEnforce(t, e, "bob", "data2", "read", false)
+	testEnforce(t, e, "bob", "data2", "write", true)
+}
+
 func TestRBACModelWithResourceRoles(t *testing.T) {
 	e, _ := NewEnforcer("../../examples/rbac_with_resource_roles_model.conf", "../../examples/rbac_with_resource_roles_policy.csv")
 
@@ -442,6 +483,76 @@ func TestKeyMatch2Model(t *testing.T) {
 	testEnforce(t, e, "alice", "/alice_data2/myid/using/res_id", "GET", true)
 }
 
+func TestFunctionSignatures(t *testing.T) {
+	text := `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && %s && r.act == p.act
+`
+	m, _ := model.NewModelFromString(fmt.Sprintf(text, "keyMatch2(r.obj, p.obj, p.act)"))
+	if _, err := NewEnforcer(m); err == nil || !strings.Contains(err.Error(), "keyMatch2 expects 2 arguments, got 3") {
+		t.Errorf("got error %v, want the misused keyMatch2 to be reported", err)
+	}
+
+	e, _ := NewEnforcer("../../examples/keymatch2_model.conf", "../../examples/keymatch2_policy.csv")
+	if err := e.CheckModel(m); err == nil {
+		t.Error("CheckModel should type-check the matchers")
+	}
+	testEnforce(t, e, "alice", "/alice_data/resource", "GET", true)
+
+	m, _ = model.NewModelFromString(fmt.Sprintf(text, "isOwner(r.obj, p.obj)"))
+	e.SetModel(m)
+	_, _ = e.AddPolicy("alice", "/alice_data/resource", "GET")
+	isOwner := func(args ...interface{}) (interface{}, error) {
+		return strings.HasPrefix(args[0].(string), args[1].(string)), nil
+	}
+	err := e.AddFunctionWithSignature("isOwner", model.NewSignature(model.BoolType, model.StringType), isOwner)
+	if err == nil || !strings.Contains(err.Error(), "isOwner expects 1 arguments, got 2") {
+		t.Errorf("got error %v, want the matcher to be checked against the new signature", err)
+	}
+	if err := e.AddFunctionWithSignature("isOwner", model.NewSignature(model.BoolType, model.StringType, model.StringType), isOwner); err != nil {
+		t.Fatal(err)
+	}
+	testEnforce(t, e, "alice", "/alice_data/resource", "GET", true)
+	testEnforce(t, e, "alice", "/bob_data/resource", "GET", false)
+
+	// the function checks its arguments at run-time as well.
+	_, err = e.Enforce("alice", 1, "GET")
+	if err == nil || !strings.Contains(err.Error(), "isOwner: argument 1 must be a string") {
+		t.Errorf("got error %v, want a type error", err)
+	}
+
+	// adding the function again replaces it.
+	isNotOwner := func(args ...interface{}) (interface{}, error) {
+		return !strings.HasPrefix(args[0].(string), args[1].(string)), nil
+	}
+	if err := e.AddFunctionWithSignature("isOwner", model.NewSignature(model.BoolType, model.StringType, model.StringType), isNotOwner); err != nil {
+		t.Fatal(err)
+	}
+	testEnforce(t, e, "alice", "/alice_data/resource", "GET", false)
+
+	listed := make(map[string]string)
+	for _, info := range e.ListFunctions() {
+		listed[info.Name] = info.String()
+	}
+	for name, want := range map[string]string{
+		"isOwner":  "isOwner(string, string) bool",
+		"keyMatch": "keyMatch(string, string) bool",
+	} {
+		if listed[name] != want {
+			t.Errorf("%s: listed as %q, want %q", name, listed[name], want)
+		}
+	}
+}
+
 func CustomFunction(key1 string, key2 string) bool {
 	if key1 == "/alice_data2/myid/using/res_id" && key2 == "/alice_data/:resource" {
 		return true
@@ -468,6 +579,49 @@ func TestKeyMatchCustomModel(t *testing.T) {
 	testEnforce(t, e, "alice", "/alice_data2/myid/using/res_id", "GET", true)
 }
 
+func TestStrictMode(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj2 && r.act == p.act
+`)
+	if _, err := NewEnforcer(m); err != nil {
+		t.Errorf("the model should only be validated in strict mode, got %v", err)
+	}
+	_, err := NewEnforcer(m, Strict)
+	if err == nil || !strings.Contains(err.Error(), "error: matchers::m:28: undeclared token p.obj2") {
+		t.Errorf("got error %v, want the undeclared token to be reported", err)
+	}
+
+	e, err := NewEnforcer("../../examples/keymatch_custom_model.conf", "../../examples/keymatch2_policy.csv", Strict)
+	if err != nil {
+		t.Fatalf("warnings should not fail the strict mode, got %v", err)
+	}
+	if diagnostics := e.Validate(); len(diagnostics) != 1 || diagnostics[0].Severity != model.WarningSeverity {
+		t.Errorf("got diagnostics %v, want keyMatchCustom to be unknown", diagnostics)
+	}
+	e.AddFunction("keyMatchCustom", CustomFunctionWrapper)
+	if diagnostics := e.Validate(); len(diagnostics) != 0 {
+		t.Errorf("got diagnostics %v, want none", diagnostics)
+	}
+	if err := e.CheckModel(m); err == nil {
+		t.Error("CheckModel should validate the model in strict mode")
+	}
+
+	e.EnableStrictMode(false)
+	if err := e.CheckModel(m); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestIPMatchModel(t *testing.T) {
 	e, _ := NewEnforcer("../../examples/ipmatch_model.conf", "../../examples/ipmatch_policy.csv")
 
@@ -593,6 +747,237 @@ func TestABACPolicy(t *testing.T) {
 	testEnforce(t, e, sub3, "/data2", "write", false)
 }
 
+func TestABACMapRequest(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub.department == r.obj.owner.department && r.sub.level >= 2 && r.act == "read"
+`)
+	e, _ := NewEnforcer(m)
+
+	alice := map[string]interface{}{"department": "sales", "level": 3}
+	bob := map[string]interface{}{"department": "sales", "level": 1}
+	carol := map[string]interface{}{"department": "hr", "level": 2}
+	report := map[string]interface{}{"owner": map[string]interface{}{"department": "sales"}}
+
+	testEnforce(t, e, alice, report, "read", true)
+	testEnforce(t, e, alice, report, "write", false)
+	testEnforce(t, e, bob, report, "read", false)
+	testEnforce(t, e, carol, report, "read", false)
+
+	// a missing attribute is nil.
+	testEnforce(t, e, map[string]interface{}{"level": 3}, report, "read", false)
+
+	// attributes of values without attributes are errors.
+	if _, err := e.Enforce("alice", report, "read"); err == nil {
+		t.Error("alice, report, read: an error is expected")
+	}
+}
+
+func TestABACJSONRequest(t *testing.T) {
+	e, _ := NewEnforcer("../../examples/abac_model.conf")
+
+	testEnforce(t, e, "alice", `{"Name": "data1", "Owner": "alice"}`, "read", true)
+	testEnforce(t, e, "alice", json.RawMessage(`{"Name": "data2", "Owner": "bob"}`), "read", false)
+	testEnforce(t, e, "bob", []byte(`{"Name": "data2", "Owner": "bob"}`), "write", true)
+
+	e, _ = NewEnforcer("../../examples/abac_rule_model.conf", "../../examples/abac_rule_policy.csv")
+	testEnforce(t, e, `{"name": "alice", "Age": 20}`, "/data1", "read", true)
+	testEnforce(t, e, `{"name": "alice", "Age": 16}`, "/data1", "read", false)
+	testEnforce(t, e, `{"name": "alice", "Age": 16}`, "/data2", "write", true)
+}
+
+func TestJSONRequestDecodedLazily(t *testing.T) {
+	parameters := enforceParameters{
+		rTokens:  map[string]int{"r_sub": 0, "r_obj": 1},
+		rVals:    []interface{}{`{"Name": "alice"}`, `{"Name": "data1", "Owner": "alice"}`},
+		rObjects: newRequestObjects([]interface{}{`{"Name": "alice"}`, `{"Name": "data1", "Owner": "alice"}`}),
+	}
+
+	// the values compared as a whole aren't decoded.
+	if _, err := parameters.Get("r_sub"); err != nil || len(parameters.rObjects) != 0 {
+		t.Errorf("r_sub: %v, decoded values: %v", err, parameters.rObjects)
+	}
+
+	// a value is decoded on the first access to one of its attributes, and only it.
+	if owner, err := parameters.Get("r_obj.Owner"); err != nil || owner != "alice" {
+		t.Errorf("r_obj.Owner: %v, %v, supposed to be alice", owner, err)
+	}
+	if _, ok := parameters.rObjects["r_obj"]; !ok || len(parameters.rObjects) != 1 {
+		t.Errorf("decoded values: %v, supposed to hold r_obj only", parameters.rObjects)
+	}
+
+	if newRequestObjects([]interface{}{"alice", "data1", "read"}) != nil {
+		t.Error("a request without a JSON value needs no decoded values")
+	}
+}
+
+func TestTimeWindowModel(t *testing.T) {
+	e, _ := NewEnforcer("../../examples/time_window_model.conf", "../../examples/time_window_policy.csv")
+
+	// Monday 09:30 in India.
+	e.SetClock(util.FixedClock(time.Date(2021, 3, 1, 4, 0, 0, 0, time.UTC)))
+	testEnforce(t, e, "alice", "production", "deploy", true)
+	testEnforce(t, e, "bob", "production", "deploy", false)
+
+	// Monday 19:00 in India.
+	e.SetClock(util.FixedClock(time.Date(2021, 3, 1, 13, 30, 0, 0, time.UTC)))
+	testEnforce(t, e, "alice", "production", "deploy", false)
+
+	// Saturday 10:00 in India.
+	e.SetClock(util.FixedClock(time.Date(2021, 3, 6, 4, 30, 0, 0, time.UTC)))
+	testEnforce(t, e, "alice", "production", "deploy", false)
+
+	// the clock outlives the model.
+	_ = e.LoadModel()
+	_ = e.LoadPolicy()
+	testEnforce(t, e, "alice", "production", "deploy", false)
+}
+
+func TestCustomFunctionReplacingBuiltin(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act && before(r.sub)
+`)
+	e, _ := NewEnforcer(m, fileadapter.NewAdapter("../../examples/basic_policy.csv"))
+	e.AddFunction("before", func(args ...interface{}) (interface{}, error) {
+		return args[0] == "alice", nil
+	})
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "bob", "data2", "write", false)
+
+	// the clock doesn't bring back the built-in function.
+	e.SetClock(util.FixedClock(time.Date(2021, 3, 1, 4, 0, 0, 0, time.UTC)))
+	testEnforce(t, e, "alice", "data1", "read", true)
+}
+
+func TestFirewallModel(t *testing.T) {
+	e, _ := NewEnforcer("../../examples/firewall_model.conf", "../../examples/firewall_policy.csv")
+
+	testFirewall := func(sub string, ip string, port string, host string, res bool) {
+		t.Helper()
+		if myRes, err := e.Enforce(sub, ip, port, host); err != nil || myRes != res {
+			t.Errorf("%s, %s, %s, %s: %t %v, supposed to be %t", sub, ip, port, host, myRes, err, res)
+		}
+	}
+
+	testFirewall("payments", "10.1.2.3", "443", "api.payments.svc.cluster.local", true)
+	testFirewall("payments", "192.168.1.1", "8080", "ledger.svc.cluster.local", true)
+	testFirewall("payments", "172.16.0.1", "443", "api.payments.svc.cluster.local", false)
+	testFirewall("payments", "10.1.2.3", "22", "api.payments.svc.cluster.local", false)
+	testFirewall("payments", "10.1.2.3", "443", "api.example.com", false)
+	testFirewall("backup", "10.0.0.42", "22", "backup-1.example.com", true)
+	testFirewall("backup", "fd12::1", "22", "backup-2.example.com", true)
+	testFirewall("backup", "10.0.0.51", "22", "backup-1.example.com", false)
+
+	// malformed input is an error, not a panic.
+	if _, err := e.Enforce("payments", "10.1.2", "443", "api.svc.cluster.local"); err == nil {
+		t.Error("10.1.2: an error is expected")
+	}
+}
+
+func TestSemverAndJSONGetMatchers(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, version, body
+
+[policy_definition]
+p = versions
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = semverMatch(r.version, p.versions) && jsonGet(r.body, "$.owner.id") == r.sub
+`)
+	e, _ := NewEnforcer(m)
+	_, _ = e.AddPolicy(">=2.1 <3")
+
+	doc := `{"owner": {"id": "alice"}}`
+	testEnforce := func(sub string, version string, res bool) {
+		t.Helper()
+		if myRes, err := e.Enforce(sub, version, doc); err != nil || myRes != res {
+			t.Errorf("%s, %s: %t %v, supposed to be %t", sub, version, myRes, err, res)
+		}
+	}
+	testEnforce("alice", "2.4.0", true)
+	testEnforce("alice", "3.0.0", false)
+	testEnforce("bob", "2.4.0", false)
+}
+
+func TestAttributeProvider(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = region, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub.region == p.region && r.obj == p.obj && r.act == p.act && r.sub.active == true
+`)
+	e, _ := NewEnforcer(m)
+	_, _ = e.AddPolicies([][]string{{"eu", "data1", "read"}, {"us", "data2", "read"}, {"eu", "data2", "write"}})
+
+	users := map[string]map[string]interface{}{
+		"alice": {"region": "eu", "active": true},
+		"bob":   {"region": "us", "active": true},
+		"carol": {"region": "eu", "active": false},
+	}
+	fetches := 0
+	e.AddAttributeProvider("sub", func(ctx context.Context, id string) (map[string]interface{}, error) {
+		if ctx == nil {
+			t.Error("the provider should get a context")
+		}
+		fetches++
+		if attributes, ok := users[id]; ok {
+			return attributes, nil
+		}
+		return nil, fmt.Errorf("unknown user %s", id)
+	})
+
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "alice", "data2", "read", false)
+	testEnforce(t, e, "alice", "data2", "write", true)
+	testEnforce(t, e, "bob", "data2", "read", true)
+	testEnforce(t, e, "carol", "data1", "read", false)
+
+	// the attributes are fetched once per call.
+	fetches = 0
+	_, _ = e.Enforce("alice", "data2", "write")
+	if fetches != 1 {
+		t.Errorf("the attributes were fetched %d times, supposed to be once", fetches)
+	}
+
+	// the request attributes take precedence.
+	testEnforce(t, e, map[string]interface{}{"region": "us", "active": true}, "data2", "read", true)
+
+	decision, err := e.Decide("dave", "data1", "read")
+	if err == nil || decision.Effect != effector.Indeterminate || !strings.Contains(decision.Reason, "unknown user dave") {
+		t.Errorf("dave, data1, read: %s %q, %v", decision.Effect, decision.Reason, err)
+	}
+}
+
 func TestCommentModel(t *testing.T) {
 	e, _ := NewEnforcer("../../examples/comment_model.conf", "../../examples/basic_policy.csv")
 	testEnforce(t, e, "alice", "data1", "read", true)
@@ -0,0 +1,204 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bhojpur/policy/pkg/util"
+)
+
+type freeValue struct{}
+
+// Free marks a request value that is left unbound in PartialEnforce.
+var Free interface{} = freeValue{}
+
+// PartialResult is the outcome of a partial enforcement.
+type PartialResult struct {
+	// Allow holds the allow rules that match the bound request values, in policy order.
+	Allow [][]string
+	// Deny holds the deny rules that match the bound request values, they may exclude some of the
+	// values given by Allow depending on the policy effect.
+	Deny [][]string
+	// Values maps every free request token, e.g. "r.obj", to the distinct values of the policy token
+	// it is matched with in the allow rules. Patterns such as "/data/:id" are returned as they are.
+	Values map[string][]string
+}
+
+// PartialEnforce answers which values of the free request tokens could be allowed, e.g.
+// PartialEnforce("alice", Free, "read") returns the objects alice can read. Every condition of the
+// matcher that uses a free token is assumed to hold, so the result may include rules that a full
+// Enforce would still reject, but never misses one. A free token is matched with the policy token
+// it is compared with in the matcher, or else the token of the same name, e.g. "r.obj" with "p.obj".
+//
+// The free tokens are bound to the policy values as they are: with an RBAC matcher such as
+// "g(r.sub, p.sub)", the values of a free "r.sub" are the roles and users of the policy rules, not
+// the users holding these roles. Matchers calling eval() on conditions that don't use a free token
+// are not supported and make PartialEnforce fail.
+func (e *Enforcer) PartialEnforce(rvals ...interface{}) (*PartialResult, error) {
+	rType, pType, mType := "r", "p", "m"
+	if len(rvals) != 0 {
+		if enforceContext, ok := rvals[0].(EnforceContext); ok {
+			rType, pType, mType = enforceContext.RType, enforceContext.PType, enforceContext.MType
+			rvals = rvals[1:]
+		}
+	}
+
+	rTokens := e.model["r"][rType].Tokens
+	if len(rTokens) != len(rvals) {
+		return nil, fmt.Errorf("invalid request size: expected %d, got %d, rvals: %v", len(rTokens), len(rvals), rvals)
+	}
+	pTokens := make(map[string]int, len(e.model["p"][pType].Tokens))
+	for i, token := range e.model["p"][pType].Tokens {
+		pTokens[token] = i
+	}
+
+	var free []string
+	for i, rval := range rvals {
+		if _, ok := rval.(freeValue); ok {
+			free = append(free, rTokens[i])
+		}
+	}
+
+	expString, bindings := partialMatcher(e.model["m"][mType].Value, free, e.model["p"][pType].Tokens)
+	for _, token := range free {
+		if _, ok := bindings[token]; !ok {
+			name := pType + strings.TrimPrefix(token, rType)
+			if _, ok := pTokens[name]; ok {
+				bindings[token] = name
+			}
+		}
+	}
+
+	if util.HasEval(expString) {
+		return nil, errors.New("partial enforcement doesn't support eval() in the matcher")
+	}
+	expression, err := e.getMatcherExpression(expString)
+	if err != nil {
+		return nil, err
+	}
+
+	rTokenMap := make(map[string]int, len(rTokens))
+	for i, token := range rTokens {
+		rTokenMap[token] = i
+	}
+	parameters := enforceParameters{
+		ctx:      context.Background(),
+		gMemo:    util.GMemo{},
+		rTokens:  rTokenMap,
+		rVals:    rvals,
+		rObjects: newRequestObjects(rvals),
+		pTokens:  pTokens,
+	}
+	if len(e.attributeProviders) != 0 {
+		parameters.providers, parameters.fetched = e.attributeProviders, make(map[string]fetchedAttributes)
+	}
+
+	res := &PartialResult{Values: make(map[string][]string, len(free))}
+	seen := make(map[string]map[string]bool, len(free))
+	for _, pvals := range e.model["p"][pType].Policy {
+		parameters.pVals = pvals
+		result, err := expression.Eval(parameters)
+		if err != nil {
+			return nil, err
+		}
+		if matched, ok := result.(bool); !ok || !matched {
+			continue
+		}
+
+		if j, ok := pTokens[pType+"_eft"]; ok && pvals[j] != "allow" {
+			if pvals[j] == "deny" {
+				res.Deny = append(res.Deny, pvals)
+			}
+			continue
+		}
+		res.Allow = append(res.Allow, pvals)
+
+		for _, token := range free {
+			name, ok := bindings[token]
+			if !ok {
+				continue
+			}
+			key := strings.Replace(token, "_", ".", 1)
+			value := pvals[pTokens[name]]
+			if seen[key] == nil {
+				seen[key] = make(map[string]bool)
+			}
+			if !seen[key][value] {
+				seen[key][value] = true
+				res.Values[key] = append(res.Values[key], value)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+var wordReg = regexp.MustCompile(`\w+`)
+
+// partialMatcher replaces by true the conditions of the matcher that use a free request token, and
+// returns the policy token each free token is compared with when a condition uses a single one.
+func partialMatcher(expString string, free []string, pTokens []string) (string, map[string]string) {
+	bindings := make(map[string]string)
+	if len(free) == 0 {
+		return expString, bindings
+	}
+
+	disjuncts := util.SplitTopLevel(expString, "||")
+	for i, disjunct := range disjuncts {
+		conjuncts := util.SplitTopLevel(disjunct, "&&")
+		for j, conjunct := range conjuncts {
+			words := make(map[string]bool)
+			for _, word := range wordReg.FindAllString(conjunct, -1) {
+				words[word] = true
+			}
+
+			var usedFree, usedP []string
+			for _, token := range free {
+				if words[token] {
+					usedFree = append(usedFree, token)
+				}
+			}
+			if len(usedFree) == 0 {
+				conjuncts[j] = "(" + conjunct + ")"
+				continue
+			}
+			for _, token := range pTokens {
+				if words[token] {
+					usedP = append(usedP, token)
+				}
+			}
+			for _, token := range usedFree {
+				if _, ok := bindings[token]; !ok && len(usedP) == 1 {
+					bindings[token] = usedP[0]
+				}
+			}
+			conjuncts[j] = "true"
+		}
+		disjuncts[i] = "(" + strings.Join(conjuncts, " && ") + ")"
+	}
+
+	return strings.Join(disjuncts, " || "), bindings
+}
@@ -0,0 +1,82 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testPartialEnforce(t *testing.T, e *Enforcer, request []interface{}, allow [][]string, deny [][]string, values map[string][]string) {
+	t.Helper()
+	res, err := e.PartialEnforce(request...)
+	if err != nil {
+		t.Fatalf("%v: %v", request, err)
+	}
+	if !reflect.DeepEqual(res.Allow, allow) {
+		t.Errorf("%v: allow %v, supposed to be %v", request, res.Allow, allow)
+	}
+	if !reflect.DeepEqual(res.Deny, deny) {
+		t.Errorf("%v: deny %v, supposed to be %v", request, res.Deny, deny)
+	}
+	if !reflect.DeepEqual(res.Values, values) {
+		t.Errorf("%v: values %v, supposed to be %v", request, res.Values, values)
+	}
+}
+
+func TestPartialEnforce(t *testing.T) {
+	e, _ := NewEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+	testPartialEnforce(t, e, []interface{}{"alice", Free, "read"},
+		[][]string{{"alice", "data1", "read"}, {"data2_admin", "data2", "read"}}, nil,
+		map[string][]string{"r.obj": {"data1", "data2"}})
+	testPartialEnforce(t, e, []interface{}{"bob", Free, Free},
+		[][]string{{"bob", "data2", "write"}}, nil,
+		map[string][]string{"r.obj": {"data2"}, "r.act": {"write"}})
+	testPartialEnforce(t, e, []interface{}{Free, "data2", "write"},
+		[][]string{{"bob", "data2", "write"}, {"data2_admin", "data2", "write"}}, nil,
+		map[string][]string{"r.sub": {"bob", "data2_admin"}})
+	testPartialEnforce(t, e, []interface{}{"carol", Free, "read"}, nil, nil, map[string][]string{})
+
+	// patterns are returned as they are written in the policy.
+	e, _ = NewEnforcer("../../examples/keymatch2_model.conf", "../../examples/keymatch2_policy.csv")
+	testPartialEnforce(t, e, []interface{}{"alice", Free, "GET"},
+		[][]string{{"alice", "/alice_data/:resource", "GET"}, {"alice", "/alice_data2/:id/using/:resId", "GET"}}, nil,
+		map[string][]string{"r.obj": {"/alice_data/:resource", "/alice_data2/:id/using/:resId"}})
+
+	e, _ = NewEnforcer("../../examples/keymatch_model.conf", "../../examples/keymatch_policy.csv")
+	testPartialEnforce(t, e, []interface{}{"bob", Free, "POST"},
+		[][]string{{"bob", "/bob_data/*", "POST"}}, nil,
+		map[string][]string{"r.obj": {"/bob_data/*"}})
+
+	e, _ = NewEnforcer("../../examples/rbac_with_deny_model.conf", "../../examples/rbac_with_deny_policy.csv")
+	testPartialEnforce(t, e, []interface{}{"alice", Free, "write"},
+		[][]string{{"data2_admin", "data2", "write", "allow"}}, [][]string{{"alice", "data2", "write", "deny"}},
+		map[string][]string{"r.obj": {"data2"}})
+
+	if _, err := e.PartialEnforce("alice", Free); err == nil {
+		t.Error("a request of the wrong size should fail")
+	}
+
+	e, _ = NewEnforcer("../../examples/abac_rule_model.conf", "../../examples/abac_rule_policy.csv")
+	if _, err := e.PartialEnforce(struct{ Age int }{30}, Free, "read"); err == nil {
+		t.Error("a matcher evaluating rules should fail")
+	}
+}
@@ -0,0 +1,126 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+	"github.com/bhojpur/policy/pkg/util"
+)
+
+// DecisionTrace records how an enforcement reached its decision. It is filled by EnforceWithTrace
+// and can be serialised to JSON to be shown in tooling.
+type DecisionTrace struct {
+	// Matcher is the matcher expression as it was evaluated.
+	Matcher string `json:"matcher"`
+	// Request binds every request token, e.g. "r.sub", to the value it was given.
+	Request map[string]interface{} `json:"request"`
+	// Rules holds one entry per policy rule in policy order. When the matcher doesn't use the policy,
+	// it holds a single entry with index -1.
+	Rules []RuleTrace `json:"rules"`
+	// RoleLinks lists the g() calls made while evaluating the matcher, in call order.
+	RoleLinks []RoleLinkTrace `json:"roleLinks"`
+	// PolicyEffect is the policy effect expression the rule effects were merged with.
+	PolicyEffect string `json:"policyEffect"`
+	// Effect is the merged effect: "allow", "deny", "indeterminate" or "not-applicable".
+	Effect string `json:"effect"`
+	// Explain is the rule that decided the effect, if any.
+	Explain []string `json:"explain,omitempty"`
+	// Result is the final decision.
+	Result bool `json:"result"`
+}
+
+// RuleTrace is the outcome of a single policy rule.
+type RuleTrace struct {
+	Index int      `json:"index"`
+	Rule  []string `json:"rule,omitempty"`
+	// Evaluated is false for rules that were never matched, because the effect was decided
+	// before reaching them or because the policy index ruled them out.
+	Evaluated bool   `json:"evaluated"`
+	Matched   bool   `json:"matched"`
+	Effect    string `json:"effect,omitempty"`
+}
+
+// RoleLinkTrace is a single g() call. Its result comes from a role-manager HasLink call, or from
+// an earlier call with the same arguments in the same enforcement when it is cached.
+type RoleLinkTrace struct {
+	PType  string   `json:"ptype"`
+	Name1  string   `json:"name1"`
+	Name2  string   `json:"name2"`
+	Domain []string `json:"domain,omitempty"`
+	Result bool     `json:"result"`
+	Cached bool     `json:"cached,omitempty"`
+}
+
+// getTraceFunctions returns the matcher functions with the g() functions recording into trace.
+// The g() functions memorize their results for the enforcement like the untraced ones do.
+func (e *Enforcer) getTraceFunctions(trace *DecisionTrace) map[string]govaluate.ExpressionFunction {
+	functions := e.fm.GetFunctions()
+	for key, ast := range e.model["g"] {
+		ptype := key
+		// without a memo as first argument, the function asks the role manager on every call.
+		gFunc := util.GenerateGFunctionWithMemo(ast.RM)
+		memorized := map[string]bool{}
+		functions[key] = func(args ...interface{}) (interface{}, error) {
+			link := RoleLinkTrace{PType: ptype}
+			if len(args) > 0 {
+				link.Name1 = fmt.Sprint(args[0])
+			}
+			if len(args) > 1 {
+				link.Name2 = fmt.Sprint(args[1])
+			}
+			for i := 2; i < len(args); i++ {
+				link.Domain = append(link.Domain, fmt.Sprint(args[i]))
+			}
+			memoKey := strings.Join(append([]string{link.Name1, link.Name2}, link.Domain...), ";")
+
+			link.Result, link.Cached = memorized[memoKey]
+			if !link.Cached {
+				res, err := gFunc(args...)
+				if err != nil {
+					return res, err
+				}
+				link.Result, _ = res.(bool)
+				memorized[memoKey] = link.Result
+			}
+			trace.RoleLinks = append(trace.RoleLinks, link)
+			return link.Result, nil
+		}
+	}
+	return functions
+}
+
+// begin resets the trace for a request against the given matcher and policy effect.
+func (trace *DecisionTrace) begin(expString string, rTokens []string, rvals []interface{}, policyEffect string) {
+	trace.Matcher = expString
+	trace.PolicyEffect = policyEffect
+	trace.Request = make(map[string]interface{}, len(rTokens))
+	for i, token := range rTokens {
+		if i < len(rvals) {
+			trace.Request[strings.Replace(token, "_", ".", 1)] = rvals[i]
+		}
+	}
+	trace.Rules = nil
+	trace.RoleLinks = nil
+	trace.Explain = nil
+}
@@ -0,0 +1,509 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+
+	Err "github.com/bhojpur/policy/pkg/errors"
+	"github.com/bhojpur/policy/pkg/model"
+	"github.com/bhojpur/policy/pkg/persist"
+	"github.com/bhojpur/policy/pkg/util"
+)
+
+var errTransactionDone = errors.New("the transaction is done")
+
+// Transaction buffers policy changes against a copy of the model, see Enforcer.Transaction. Its
+// methods work like the ones of the management API, without touching the enforcer.
+type Transaction struct {
+	e       *Enforcer
+	model   model.Model
+	changes []*persist.PolicyChange
+	done    bool
+}
+
+// Transaction applies the policy changes made by fn atomically. The changes are buffered against a
+// copy of the model and committed when fn returns nil: they are persisted through the adapter in one
+// batch, the role links are rebuilt incrementally and the watcher or the dispatcher is notified once.
+// Nothing is changed when fn or the commit fails, and the error is returned.
+//
+// fn must only change the policy through tx, the changes made directly to the enforcer meanwhile are
+// not part of the transaction.
+func (e *Enforcer) Transaction(fn func(tx *Transaction) error) error {
+	tx := &Transaction{e: e, model: e.model.Copy()}
+	defer func() { tx.done = true }()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return e.commit(tx.policyChanges())
+}
+
+// HasPolicy determines whether an authorization rule exists in the transaction.
+func (tx *Transaction) HasPolicy(params ...interface{}) bool {
+	return tx.HasNamedPolicy("p", params...)
+}
+
+// HasNamedPolicy determines whether a named authorization rule exists in the transaction.
+func (tx *Transaction) HasNamedPolicy(ptype string, params ...interface{}) bool {
+	return tx.has("p", ptype, params)
+}
+
+// AddPolicy adds an authorization rule to the transaction.
+func (tx *Transaction) AddPolicy(params ...interface{}) (bool, error) {
+	return tx.AddNamedPolicy("p", params...)
+}
+
+// AddPolicies adds authorization rules to the transaction, none is added if one already exists.
+func (tx *Transaction) AddPolicies(rules [][]string) (bool, error) {
+	return tx.AddNamedPolicies("p", rules)
+}
+
+// AddNamedPolicy adds an authorization rule to the named policy of the transaction.
+func (tx *Transaction) AddNamedPolicy(ptype string, params ...interface{}) (bool, error) {
+	return tx.add("p", ptype, [][]string{paramsToRule(params)})
+}
+
+// AddNamedPolicies adds authorization rules to the named policy of the transaction, none is added if
+// one already exists.
+func (tx *Transaction) AddNamedPolicies(ptype string, rules [][]string) (bool, error) {
+	return tx.add("p", ptype, rules)
+}
+
+// RemovePolicy removes an authorization rule from the transaction.
+func (tx *Transaction) RemovePolicy(params ...interface{}) (bool, error) {
+	return tx.RemoveNamedPolicy("p", params...)
+}
+
+// RemovePolicies removes authorization rules from the transaction.
+func (tx *Transaction) RemovePolicies(rules [][]string) (bool, error) {
+	return tx.RemoveNamedPolicies("p", rules)
+}
+
+// RemoveNamedPolicy removes an authorization rule from the named policy of the transaction.
+func (tx *Transaction) RemoveNamedPolicy(ptype string, params ...interface{}) (bool, error) {
+	return tx.remove("p", ptype, [][]string{paramsToRule(params)})
+}
+
+// RemoveNamedPolicies removes authorization rules from the named policy of the transaction.
+func (tx *Transaction) RemoveNamedPolicies(ptype string, rules [][]string) (bool, error) {
+	return tx.remove("p", ptype, rules)
+}
+
+// RemoveFilteredPolicy removes the authorization rules matching the field filters from the transaction.
+func (tx *Transaction) RemoveFilteredPolicy(fieldIndex int, fieldValues ...string) (bool, error) {
+	return tx.RemoveFilteredNamedPolicy("p", fieldIndex, fieldValues...)
+}
+
+// RemoveFilteredNamedPolicy removes the authorization rules matching the field filters from the named
+// policy of the transaction.
+func (tx *Transaction) RemoveFilteredNamedPolicy(ptype string, fieldIndex int, fieldValues ...string) (bool, error) {
+	return tx.removeFiltered("p", ptype, fieldIndex, fieldValues)
+}
+
+// UpdatePolicy updates an authorization rule of the transaction.
+func (tx *Transaction) UpdatePolicy(oldRule []string, newRule []string) (bool, error) {
+	return tx.UpdateNamedPolicy("p", oldRule, newRule)
+}
+
+// UpdateNamedPolicy updates an authorization rule of the named policy of the transaction.
+func (tx *Transaction) UpdateNamedPolicy(ptype string, oldRule []string, newRule []string) (bool, error) {
+	return tx.update("p", ptype, oldRule, newRule)
+}
+
+// UpdateFilteredPolicies replaces the authorization rules matching the field filters of the transaction.
+func (tx *Transaction) UpdateFilteredPolicies(newRules [][]string, fieldIndex int, fieldValues ...string) (bool, error) {
+	return tx.UpdateFilteredNamedPolicies("p", newRules, fieldIndex, fieldValues...)
+}
+
+// UpdateFilteredNamedPolicies replaces the authorization rules matching the field filters of the named
+// policy of the transaction.
+func (tx *Transaction) UpdateFilteredNamedPolicies(ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) (bool, error) {
+	return tx.updateFiltered("p", ptype, newRules, fieldIndex, fieldValues)
+}
+
+// HasGroupingPolicy determines whether a role inheritance rule exists in the transaction.
+func (tx *Transaction) HasGroupingPolicy(params ...interface{}) bool {
+	return tx.HasNamedGroupingPolicy("g", params...)
+}
+
+// HasNamedGroupingPolicy determines whether a named role inheritance rule exists in the transaction.
+func (tx *Transaction) HasNamedGroupingPolicy(ptype string, params ...interface{}) bool {
+	return tx.has("g", ptype, params)
+}
+
+// AddGroupingPolicy adds a role inheritance rule to the transaction.
+func (tx *Transaction) AddGroupingPolicy(params ...interface{}) (bool, error) {
+	return tx.AddNamedGroupingPolicy("g", params...)
+}
+
+// AddGroupingPolicies adds role inheritance rules to the transaction, none is added if one already exists.
+func (tx *Transaction) AddGroupingPolicies(rules [][]string) (bool, error) {
+	return tx.AddNamedGroupingPolicies("g", rules)
+}
+
+// AddNamedGroupingPolicy adds a named role inheritance rule to the transaction.
+func (tx *Transaction) AddNamedGroupingPolicy(ptype string, params ...interface{}) (bool, error) {
+	return tx.add("g", ptype, [][]string{paramsToRule(params)})
+}
+
+// AddNamedGroupingPolicies adds named role inheritance rules to the transaction, none is added if one
+// already exists.
+func (tx *Transaction) AddNamedGroupingPolicies(ptype string, rules [][]string) (bool, error) {
+	return tx.add("g", ptype, rules)
+}
+
+// RemoveGroupingPolicy removes a role inheritance rule from the transaction.
+func (tx *Transaction) RemoveGroupingPolicy(params ...interface{}) (bool, error) {
+	return tx.RemoveNamedGroupingPolicy("g", params...)
+}
+
+// RemoveGroupingPolicies removes role inheritance rules from the transaction.
+func (tx *Transaction) RemoveGroupingPolicies(rules [][]string) (bool, error) {
+	return tx.RemoveNamedGroupingPolicies("g", rules)
+}
+
+// RemoveNamedGroupingPolicy removes a named role inheritance rule from the transaction.
+func (tx *Transaction) RemoveNamedGroupingPolicy(ptype string, params ...interface{}) (bool, error) {
+	return tx.remove("g", ptype, [][]string{paramsToRule(params)})
+}
+
+// RemoveNamedGroupingPolicies removes named role inheritance rules from the transaction.
+func (tx *Transaction) RemoveNamedGroupingPolicies(ptype string, rules [][]string) (bool, error) {
+	return tx.remove("g", ptype, rules)
+}
+
+// RemoveFilteredGroupingPolicy removes the role inheritance rules matching the field filters from the
+// transaction.
+func (tx *Transaction) RemoveFilteredGroupingPolicy(fieldIndex int, fieldValues ...string) (bool, error) {
+	return tx.RemoveFilteredNamedGroupingPolicy("g", fieldIndex, fieldValues...)
+}
+
+// RemoveFilteredNamedGroupingPolicy removes the named role inheritance rules matching the field filters
+// from the transaction.
+func (tx *Transaction) RemoveFilteredNamedGroupingPolicy(ptype string, fieldIndex int, fieldValues ...string) (bool, error) {
+	return tx.removeFiltered("g", ptype, fieldIndex, fieldValues)
+}
+
+// UpdateGroupingPolicy updates a role inheritance rule of the transaction.
+func (tx *Transaction) UpdateGroupingPolicy(oldRule []string, newRule []string) (bool, error) {
+	return tx.UpdateNamedGroupingPolicy("g", oldRule, newRule)
+}
+
+// UpdateNamedGroupingPolicy updates a named role inheritance rule of the transaction.
+func (tx *Transaction) UpdateNamedGroupingPolicy(ptype string, oldRule []string, newRule []string) (bool, error) {
+	return tx.update("g", ptype, oldRule, newRule)
+}
+
+// paramsToRule returns the rule of the parameters, either a []string or strings.
+func paramsToRule(params []interface{}) []string {
+	if strSlice, ok := params[0].([]string); len(params) == 1 && ok {
+		return append(make([]string, 0, len(strSlice)), strSlice...)
+	}
+	rule := make([]string, 0, len(params))
+	for _, param := range params {
+		rule = append(rule, param.(string))
+	}
+	return rule
+}
+
+// check returns an error when the transaction is done or the policy type doesn't exist.
+func (tx *Transaction) check(sec string, ptype string) error {
+	if tx.done {
+		return errTransactionDone
+	}
+	if _, ok := tx.model[sec][ptype]; !ok {
+		return fmt.Errorf("unknown policy type %s", ptype)
+	}
+	return nil
+}
+
+func (tx *Transaction) has(sec string, ptype string, params []interface{}) bool {
+	if _, ok := tx.model[sec][ptype]; !ok {
+		return false
+	}
+	return tx.model.HasPolicy(sec, ptype, paramsToRule(params))
+}
+
+func (tx *Transaction) add(sec string, ptype string, rules [][]string) (bool, error) {
+	if err := tx.check(sec, ptype); err != nil {
+		return false, err
+	}
+	if tx.model.HasPolicies(sec, ptype, rules) {
+		return false, nil
+	}
+
+	effected := tx.model.AddPoliciesWithAffected(sec, ptype, rules)
+	tx.record(sec, ptype, nil, effected)
+	return len(effected) != 0, nil
+}
+
+func (tx *Transaction) remove(sec string, ptype string, rules [][]string) (bool, error) {
+	if err := tx.check(sec, ptype); err != nil {
+		return false, err
+	}
+
+	effected := tx.model.RemovePoliciesWithEffected(sec, ptype, rules)
+	tx.record(sec, ptype, effected, nil)
+	return len(effected) != 0, nil
+}
+
+func (tx *Transaction) removeFiltered(sec string, ptype string, fieldIndex int, fieldValues []string) (bool, error) {
+	if len(fieldValues) == 0 {
+		return false, Err.INVALID_FIELDVAULES_PARAMETER
+	}
+	if err := tx.check(sec, ptype); err != nil {
+		return false, err
+	}
+
+	removed, effected := tx.model.RemoveFilteredPolicy(sec, ptype, fieldIndex, fieldValues...)
+	tx.record(sec, ptype, effected, nil)
+	return removed, nil
+}
+
+func (tx *Transaction) update(sec string, ptype string, oldRule []string, newRule []string) (bool, error) {
+	if err := tx.check(sec, ptype); err != nil {
+		return false, err
+	}
+
+	if !tx.model.UpdatePolicy(sec, ptype, oldRule, newRule) {
+		return false, nil
+	}
+	tx.record(sec, ptype, [][]string{oldRule}, [][]string{newRule})
+	return true, nil
+}
+
+func (tx *Transaction) updateFiltered(sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues []string) (bool, error) {
+	if len(fieldValues) == 0 {
+		return false, Err.INVALID_FIELDVAULES_PARAMETER
+	}
+	if err := tx.check(sec, ptype); err != nil {
+		return false, err
+	}
+
+	removed, oldRules := tx.model.RemoveFilteredPolicy(sec, ptype, fieldIndex, fieldValues...)
+	added := tx.model.AddPoliciesWithAffected(sec, ptype, newRules)
+	tx.record(sec, ptype, oldRules, added)
+	return removed && len(newRules) != 0, nil
+}
+
+// record records the rules removed then added, a rule removed and added back is unchanged.
+func (tx *Transaction) record(sec string, ptype string, removed [][]string, added [][]string) {
+	var change *persist.PolicyChange
+	for _, c := range tx.changes {
+		if c.Sec == sec && c.PType == ptype {
+			change = c
+		}
+	}
+	if change == nil {
+		change = &persist.PolicyChange{Sec: sec, PType: ptype}
+		tx.changes = append(tx.changes, change)
+	}
+
+	for _, rule := range removed {
+		rule = append([]string(nil), rule...)
+		if i := ruleIndex(change.Added, rule); i >= 0 {
+			change.Added = append(change.Added[:i], change.Added[i+1:]...)
+		} else {
+			change.Removed = append(change.Removed, rule)
+		}
+	}
+	for _, rule := range added {
+		rule = append([]string(nil), rule...)
+		if i := ruleIndex(change.Removed, rule); i >= 0 {
+			change.Removed = append(change.Removed[:i], change.Removed[i+1:]...)
+		} else {
+			change.Added = append(change.Added, rule)
+		}
+	}
+}
+
+func ruleIndex(rules [][]string, rule []string) int {
+	for i, r := range rules {
+		if util.ArrayEquals(r, rule) {
+			return i
+		}
+	}
+	return -1
+}
+
+// policyChanges returns the net changes of the transaction, in the order of the policy types changed.
+func (tx *Transaction) policyChanges() []persist.PolicyChange {
+	var changes []persist.PolicyChange
+	for _, c := range tx.changes {
+		if len(c.Removed) != 0 || len(c.Added) != 0 {
+			changes = append(changes, *c)
+		}
+	}
+	return changes
+}
+
+// inverse returns the changes undoing the changes.
+func inverse(changes []persist.PolicyChange) []persist.PolicyChange {
+	res := make([]persist.PolicyChange, len(changes))
+	for i, c := range changes {
+		res[len(changes)-1-i] = persist.PolicyChange{Sec: c.Sec, PType: c.PType, Removed: c.Added, Added: c.Removed}
+	}
+	return res
+}
+
+// commit persists, applies and notifies the changes of a transaction.
+func (e *Enforcer) commit(changes []persist.PolicyChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if e.dispatcher != nil && e.autoNotifyDispatcher {
+		if dispatcher, ok := e.dispatcher.(persist.DispatcherTransactional); ok {
+			return dispatcher.ApplyPolicyChanges(changes)
+		}
+		for _, c := range changes {
+			if len(c.Removed) != 0 {
+				if err := e.dispatcher.RemovePolicies(c.Sec, c.PType, c.Removed); err != nil {
+					return err
+				}
+			}
+			if len(c.Added) != 0 {
+				if err := e.dispatcher.AddPolicies(c.Sec, c.PType, c.Added); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := e.applyPolicyChanges(e.shouldPersist(), changes); err != nil {
+		return err
+	}
+
+	if e.watcher != nil && e.autoNotifyWatcher {
+		if watcher, ok := e.watcher.(persist.WatcherTransactional); ok {
+			return watcher.UpdateForTransaction(changes)
+		}
+		return e.watcher.Update()
+	}
+	return nil
+}
+
+// applyPolicyChanges persists the changes if asked, then applies them to the model and the role links.
+// The stored changes are undone when they can't be applied.
+func (e *Enforcer) applyPolicyChanges(shouldPersist bool, changes []persist.PolicyChange) error {
+	if shouldPersist {
+		if err := e.persistChanges(changes); err != nil {
+			return err
+		}
+	}
+
+	if err := e.applyChanges(changes); err != nil {
+		if shouldPersist {
+			if undoErr := e.persistChanges(inverse(changes)); undoErr != nil {
+				return fmt.Errorf("%v, and cannot restore the stored policy: %w", err, undoErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// persistChanges stores the changes through the adapter. Without a persist.TransactionalAdapter, they are
+// stored as a batch per policy type and the batches already stored are undone when one fails.
+func (e *Enforcer) persistChanges(changes []persist.PolicyChange) error {
+	if adapter, ok := e.adapter.(persist.TransactionalAdapter); ok {
+		return adapter.ApplyPolicyChanges(changes)
+	}
+
+	for i, c := range changes {
+		if err := e.persistChange(c); err != nil {
+			for _, undo := range inverse(changes[:i+1]) {
+				_ = e.persistChange(undo)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// persistChange stores the change of a policy type, the adapters not implementing an operation are
+// ignored like by the management API.
+func (e *Enforcer) persistChange(c persist.PolicyChange) error {
+	batch, isBatch := e.adapter.(persist.BatchAdapter)
+	apply := func(rules [][]string, batchFn func(string, string, [][]string) error, fn func(string, string, []string) error) error {
+		if len(rules) == 0 {
+			return nil
+		}
+		var err error
+		if isBatch {
+			err = batchFn(c.Sec, c.PType, rules)
+		} else {
+			for _, rule := range rules {
+				if err = fn(c.Sec, c.PType, rule); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil && err.Error() != notImplemented {
+			return err
+		}
+		return nil
+	}
+
+	var removePolicies, addPolicies func(string, string, [][]string) error
+	if isBatch {
+		removePolicies, addPolicies = batch.RemovePolicies, batch.AddPolicies
+	}
+	if err := apply(c.Removed, removePolicies, e.adapter.RemovePolicy); err != nil {
+		return err
+	}
+	return apply(c.Added, addPolicies, e.adapter.AddPolicy)
+}
+
+// applyChanges applies the changes to the model and the role links, they are undone when the role links
+// can't be rebuilt.
+func (e *Enforcer) applyChanges(changes []persist.PolicyChange) error {
+	for _, c := range changes {
+		e.model.RemovePolicies(c.Sec, c.PType, c.Removed)
+		e.invalidateEvalRules(c.PType, c.Removed)
+		e.model.AddPolicies(c.Sec, c.PType, c.Added)
+	}
+
+	for _, c := range changes {
+		if c.Sec != "g" {
+			continue
+		}
+		err := e.BuildIncrementalRoleLinks(model.PolicyRemove, c.PType, c.Removed)
+		if err == nil {
+			err = e.BuildIncrementalRoleLinks(model.PolicyAdd, c.PType, c.Added)
+		}
+		if err != nil {
+			for _, undo := range inverse(changes) {
+				e.model.RemovePolicies(undo.Sec, undo.PType, undo.Removed)
+				e.invalidateEvalRules(undo.PType, undo.Removed)
+				e.model.AddPolicies(undo.Sec, undo.PType, undo.Added)
+			}
+			if rebuildErr := e.BuildRoleLinks(); rebuildErr != nil {
+				return fmt.Errorf("%v, and cannot rebuild the role links: %w", err, rebuildErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
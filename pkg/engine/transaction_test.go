@@ -0,0 +1,238 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bhojpur/policy/pkg/persist"
+	fileadapter "github.com/bhojpur/policy/pkg/persist/file-adapter"
+)
+
+// batchAdapter records the batches stored and fails the ones of failOn, like "add p".
+type batchAdapter struct {
+	*fileadapter.Adapter
+	calls  []string
+	failOn string
+}
+
+func (a *batchAdapter) call(op string, ptype string, rules [][]string) error {
+	a.calls = append(a.calls, fmt.Sprintf("%s %s %v", op, ptype, rules))
+	if a.failOn == op+" "+ptype {
+		return errors.New("storage failure")
+	}
+	return nil
+}
+
+func (a *batchAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return a.call("add", ptype, [][]string{rule})
+}
+
+func (a *batchAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	return a.call("remove", ptype, [][]string{rule})
+}
+
+func (a *batchAdapter) AddPolicies(sec string, ptype string, rules [][]string) error {
+	return a.call("add", ptype, rules)
+}
+
+func (a *batchAdapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
+	return a.call("remove", ptype, rules)
+}
+
+type transactionalAdapter struct {
+	*fileadapter.Adapter
+	changes [][]persist.PolicyChange
+}
+
+func (a *transactionalAdapter) ApplyPolicyChanges(changes []persist.PolicyChange) error {
+	a.changes = append(a.changes, changes)
+	return nil
+}
+
+type countingWatcher struct {
+	SampleWatcher
+	updates      int
+	transactions [][]persist.PolicyChange
+}
+
+func (w *countingWatcher) Update() error {
+	w.updates++
+	return nil
+}
+
+type transactionalWatcher struct {
+	countingWatcher
+}
+
+func (w *transactionalWatcher) UpdateForTransaction(changes []persist.PolicyChange) error {
+	w.transactions = append(w.transactions, changes)
+	return nil
+}
+
+func testTransactionEnforce(t *testing.T, e IEnforcer, res map[string]bool) {
+	t.Helper()
+	for request, expected := range res {
+		rvals := strings.Split(request, ", ")
+		if ok, err := e.Enforce(rvals[0], rvals[1], rvals[2]); err != nil || ok != expected {
+			t.Errorf("%s: %t, %v, supposed to be %t", request, ok, err, expected)
+		}
+	}
+}
+
+// grantAdmin moves alice from data2_admin to admin and grants admin 3 permissions.
+func grantAdmin(tx *Transaction) error {
+	if ok, err := tx.RemoveGroupingPolicy("alice", "data2_admin"); !ok || err != nil {
+		return fmt.Errorf("remove: %t, %v", ok, err)
+	}
+	if ok, err := tx.AddGroupingPolicy("alice", "admin"); !ok || err != nil {
+		return fmt.Errorf("add: %t, %v", ok, err)
+	}
+	if ok, err := tx.AddPolicies([][]string{{"admin", "data3", "read"}, {"admin", "data3", "write"}, {"admin", "data4", "read"}}); !ok || err != nil {
+		return fmt.Errorf("add policies: %t, %v", ok, err)
+	}
+	// a rule added and removed is not part of the changes.
+	_, _ = tx.AddPolicy("admin", "data5", "read")
+	_, _ = tx.RemovePolicy("admin", "data5", "read")
+	return nil
+}
+
+func TestTransaction(t *testing.T) {
+	adapter := &batchAdapter{Adapter: fileadapter.NewAdapter("../../examples/rbac_policy.csv")}
+	e, err := NewEnforcer("../../examples/rbac_model.conf", adapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := &countingWatcher{}
+	_ = e.SetWatcher(watcher)
+
+	err = e.Transaction(func(tx *Transaction) error {
+		if err := grantAdmin(tx); err != nil {
+			return err
+		}
+		if !tx.HasGroupingPolicy("alice", "admin") || e.HasGroupingPolicy("alice", "admin") {
+			t.Error("the changes should only be visible in the transaction before the commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTransactionEnforce(t, e, map[string]bool{
+		"alice, data2, read": false, "alice, data3, write": true, "alice, data4, read": true, "alice, data5, read": false,
+	})
+	want := "remove g [[alice data2_admin]],add g [[alice admin]],add p [[admin data3 read] [admin data3 write] [admin data4 read]]"
+	if strings.Join(adapter.calls, ",") != want {
+		t.Errorf("got calls %v, want %s", adapter.calls, want)
+	}
+	if watcher.updates != 1 {
+		t.Errorf("the watcher should be notified once, got %d", watcher.updates)
+	}
+
+	// nothing changes when fn fails, and the transaction can't be used afterwards.
+	adapter.calls = nil
+	var done *Transaction
+	err = e.Transaction(func(tx *Transaction) error {
+		done = tx
+		_, _ = tx.RemoveFilteredGroupingPolicy(0, "alice")
+		return errors.New("cancelled")
+	})
+	if err == nil || err.Error() != "cancelled" || len(adapter.calls) != 0 || watcher.updates != 1 {
+		t.Errorf("the transaction should be rolled back, got %v, %v", err, adapter.calls)
+	}
+	if _, err := done.AddPolicy("bob", "data1", "read"); err != errTransactionDone {
+		t.Errorf("the transaction should be done, got %v", err)
+	}
+	err = e.Transaction(func(tx *Transaction) error {
+		_, err := tx.AddNamedPolicy("p2", "bob", "data1", "read")
+		return err
+	})
+	if err == nil || err.Error() != "unknown policy type p2" {
+		t.Errorf("unknown policy types should be rejected, got %v", err)
+	}
+
+	// the stored batches are undone when one fails.
+	adapter.calls, adapter.failOn = nil, "add p"
+	err = e.Transaction(func(tx *Transaction) error {
+		if _, err := tx.UpdateGroupingPolicy([]string{"alice", "admin"}, []string{"alice", "data2_admin"}); err != nil {
+			return err
+		}
+		_, err := tx.UpdateFilteredPolicies([][]string{{"bob", "data3", "read"}}, 0, "bob")
+		return err
+	})
+	if err == nil || err.Error() != "storage failure" {
+		t.Errorf("the commit should fail, got %v", err)
+	}
+	want = "remove g [[alice admin]],add g [[alice data2_admin]],remove p [[bob data2 write]],add p [[bob data3 read]]," +
+		"remove p [[bob data3 read]],add p [[bob data2 write]],remove g [[alice data2_admin]],add g [[alice admin]]"
+	if strings.Join(adapter.calls, ",") != want {
+		t.Errorf("got calls %v, want %s", adapter.calls, want)
+	}
+	testTransactionEnforce(t, e, map[string]bool{"alice, data3, read": true, "bob, data2, write": true, "bob, data3, read": false})
+	if watcher.updates != 1 {
+		t.Errorf("the watcher should not be notified, got %d", watcher.updates)
+	}
+}
+
+func TestTransactionalAdapterAndWatcher(t *testing.T) {
+	adapter := &transactionalAdapter{Adapter: fileadapter.NewAdapter("../../examples/rbac_policy.csv")}
+	e, err := NewSyncedEnforcer("../../examples/rbac_model.conf", adapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := &transactionalWatcher{}
+	_ = e.SetWatcher(watcher)
+
+	if err := e.Transaction(grantAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if len(adapter.changes) != 1 || len(watcher.transactions) != 1 || watcher.updates != 0 {
+		t.Fatalf("the changes should be stored and notified once, got %v, %v", adapter.changes, watcher.transactions)
+	}
+	changes := watcher.transactions[0]
+	if len(changes) != 2 || changes[0].PType != "g" || len(changes[0].Removed) != 1 || len(changes[0].Added) != 1 ||
+		changes[1].PType != "p" || len(changes[1].Removed) != 0 || len(changes[1].Added) != 3 {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	testTransactionEnforce(t, e, map[string]bool{"alice, data2, read": false, "alice, data4, read": true})
+}
+
+func TestTransactionWrappers(t *testing.T) {
+	snapshot, err := NewSnapshotEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := NewCachedEnforcer("../../examples/rbac_model.conf", "../../examples/rbac_policy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []IEnforcer{snapshot, cached} {
+		testTransactionEnforce(t, e, map[string]bool{"alice, data2, read": true, "alice, data3, read": false})
+		if err := e.Transaction(grantAdmin); err != nil {
+			t.Fatal(err)
+		}
+		testTransactionEnforce(t, e, map[string]bool{"alice, data2, read": false, "alice, data3, read": true})
+	}
+}
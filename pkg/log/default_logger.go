@@ -80,6 +80,41 @@ func (l *DefaultLogger) LogEnforce(matcher string, request []interface{}, result
 	log.Println(reqStr.String())
 }
 
+// DefaultDecisionLogger is a DefaultLogger that logs the full decision of an enforcement with
+// LogDecision, instead of its result with LogEnforce.
+type DefaultDecisionLogger struct {
+	DefaultLogger
+}
+
+func (l *DefaultDecisionLogger) LogDecision(matcher string, request []interface{}, effect string, matched [][]string, reason string) {
+	if !l.enabled {
+		return
+	}
+
+	var reqStr strings.Builder
+	reqStr.WriteString("Request: ")
+	for i, rval := range request {
+		if i != len(request)-1 {
+			reqStr.WriteString(fmt.Sprintf("%v, ", rval))
+		} else {
+			reqStr.WriteString(fmt.Sprintf("%v", rval))
+		}
+	}
+	reqStr.WriteString(fmt.Sprintf(" ---> %s\n", effect))
+
+	reqStr.WriteString("Hit Policy: ")
+	for i, pval := range matched {
+		if i != len(matched)-1 {
+			reqStr.WriteString(fmt.Sprintf("%v, ", pval))
+		} else {
+			reqStr.WriteString(fmt.Sprintf("%v", pval))
+		}
+	}
+	reqStr.WriteString(fmt.Sprintf("\nReason: %s\n", reason))
+
+	log.Println(reqStr.String())
+}
+
 func (l *DefaultLogger) LogPolicy(policy map[string][][]string) {
 	if !l.enabled {
 		return
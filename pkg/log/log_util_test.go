@@ -60,3 +60,12 @@ func TestLog(t *testing.T) {
 	m.EXPECT().LogRole(roles)
 	LogRole(roles)
 }
+
+func TestDecisionLogger(t *testing.T) {
+	if _, ok := interface{}(&DefaultLogger{}).(DecisionLogger); ok {
+		t.Error("the default logger should log the results of the enforcements")
+	}
+	if _, ok := interface{}(&DefaultDecisionLogger{}).(DecisionLogger); !ok {
+		t.Error("the default decision logger should log the decisions of the enforcements")
+	}
+}
@@ -42,3 +42,12 @@ type Logger interface {
 	// LogPolicy log info related to policy.
 	LogPolicy(policy map[string][][]string)
 }
+
+// DecisionLogger is implemented by the loggers that log the full decision of an enforcement,
+// they are called with LogDecision instead of LogEnforce.
+type DecisionLogger interface {
+	Logger
+
+	// LogDecision log info related to the decision of enforce: its effect, the matched policy rules and its reason.
+	LogDecision(matcher string, request []interface{}, effect string, matched [][]string, reason string)
+}
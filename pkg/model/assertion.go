@@ -22,6 +22,8 @@ package model
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/bhojpur/policy/pkg/log"
@@ -40,6 +42,10 @@ type Assertion struct {
 
 	logger        log.Logger
 	priorityIndex int
+
+	// index maps an indexed token to its values, and each value to the keys of the rules holding it.
+	index     map[string]map[string]map[string]int
+	indexSize int
 }
 
 func (ast *Assertion) buildIncrementalRoleLinks(rm rbac.RoleManager, op PolicyOp, rules [][]string) error {
@@ -139,6 +145,147 @@ func (ast *Assertion) copy() *Assertion {
 		Policy:        policy,
 		priorityIndex: ast.priorityIndex,
 	}
+	if ast.index != nil {
+		_ = newAst.BuildIndex(ast.IndexedTokens()...)
+	}
 
 	return newAst
 }
+
+// BuildIndex indexes the policy rules by the exact values of the given tokens, e.g. "p_obj", "p_act".
+// The index is maintained by the policy operations of the model until it is dropped with ClearIndex.
+func (ast *Assertion) BuildIndex(tokens ...string) error {
+	index := make(map[string]map[string]map[string]int, len(tokens))
+	for _, token := range tokens {
+		if ast.tokenIndex(token) == -1 {
+			return fmt.Errorf("token %s is not defined in %s", token, ast.Key)
+		}
+		index[token] = make(map[string]map[string]int)
+	}
+
+	ast.index = index
+	ast.indexSize = 0
+	for _, rule := range ast.Policy {
+		ast.addToIndex(rule)
+	}
+	return nil
+}
+
+// ClearIndex drops the policy index.
+func (ast *Assertion) ClearIndex() {
+	ast.index = nil
+	ast.indexSize = 0
+}
+
+// IndexedTokens returns the tokens the policy rules are indexed by.
+func (ast *Assertion) IndexedTokens() []string {
+	tokens := make([]string, 0, len(ast.index))
+	for token := range ast.index {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// GetIndexedPolicy returns the positions in Policy of the rules holding all the given token values,
+// in policy order. Tokens which are not indexed are ignored; ok is false if none of them is indexed,
+// or if the index is stale because rules were appended directly to Policy, e.g. by an adapter, until
+// it is built again. It doesn't modify the assertion, so that concurrent readers can call it.
+func (ast *Assertion) GetIndexedPolicy(values map[string]string) (positions []int, ok bool) {
+	if ast.index == nil || ast.indexSize != len(ast.Policy) {
+		return nil, false
+	}
+
+	var candidates map[string]int
+	for token, value := range values {
+		valueMap, found := ast.index[token]
+		if !found {
+			continue
+		}
+		keys := valueMap[value]
+		if !ok || len(keys) < len(candidates) {
+			candidates = keys
+		}
+		ok = true
+	}
+	if !ok {
+		return nil, false
+	}
+
+	for key := range candidates {
+		matched := true
+		rule := ast.Policy[ast.PolicyMap[key]]
+		for token, value := range values {
+			if _, found := ast.index[token]; found && rule[ast.tokenIndex(token)] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			positions = append(positions, ast.PolicyMap[key])
+		}
+	}
+	sort.Ints(positions)
+	return positions, true
+}
+
+func (ast *Assertion) tokenIndex(token string) int {
+	for i, t := range ast.Tokens {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ast *Assertion) addToIndex(rule []string) {
+	if ast.index == nil {
+		return
+	}
+	key := strings.Join(rule, DefaultSep)
+	for token, valueMap := range ast.index {
+		i := ast.tokenIndex(token)
+		if i >= len(rule) {
+			continue
+		}
+		keys, ok := valueMap[rule[i]]
+		if !ok {
+			keys = make(map[string]int)
+			valueMap[rule[i]] = keys
+		}
+		keys[key]++
+	}
+	ast.indexSize++
+}
+
+func (ast *Assertion) removeFromIndex(rule []string) {
+	if ast.index == nil {
+		return
+	}
+	key := strings.Join(rule, DefaultSep)
+	for token, valueMap := range ast.index {
+		i := ast.tokenIndex(token)
+		if i >= len(rule) {
+			continue
+		}
+		if keys, ok := valueMap[rule[i]]; ok {
+			if keys[key]--; keys[key] <= 0 {
+				delete(keys, key)
+			}
+			if len(keys) == 0 {
+				delete(valueMap, rule[i])
+			}
+		}
+	}
+	ast.indexSize--
+}
+
+func (ast *Assertion) clearIndexedPolicy() {
+	if ast.index == nil {
+		return
+	}
+	for token := range ast.index {
+		ast.index[token] = make(map[string]map[string]int)
+	}
+	ast.indexSize = 0
+}
@@ -0,0 +1,270 @@
+package model
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChangeKind is the kind of a change between two models.
+type ChangeKind string
+
+const (
+	// Added is an assertion of the new model only.
+	Added ChangeKind = "added"
+	// Removed is an assertion of the old model only.
+	Removed ChangeKind = "removed"
+	// Changed is an assertion defined differently by the two models.
+	Changed ChangeKind = "changed"
+)
+
+// Change is a change of an assertion between two models.
+type Change struct {
+	// Section is the section of the assertion, like "policy_definition".
+	Section string
+	// Key is the key of the assertion, like "p2".
+	Key  string
+	Kind ChangeKind
+	// Old and New are the definitions of the assertion in the old and the new model, as in a CONF file.
+	Old string
+	New string
+	// Breaking is true when the policy rules or the callers of the old model may not work with the new one.
+	Breaking bool
+	// Reason explains why the change is breaking or compatible.
+	Reason string
+	// Migration suggests how to migrate the policy rules or the callers, it is empty when there is nothing to do.
+	Migration string
+	// Rules is the number of policy rules of the assertion, or -1 when the policy is unknown, see CheckPolicy.
+	Rules int
+
+	sec string
+}
+
+// String returns the change like "breaking: policy_definition::p changed from ... to ...: reason".
+func (c Change) String() string {
+	compatibility := "compatible"
+	if c.Breaking {
+		compatibility = "breaking"
+	}
+	var change string
+	switch c.Kind {
+	case Added:
+		change = fmt.Sprintf("added %q", c.New)
+	case Removed:
+		change = fmt.Sprintf("removed %q", c.Old)
+	default:
+		change = fmt.Sprintf("changed %q to %q", c.Old, c.New)
+	}
+	return fmt.Sprintf("%s: %s::%s %s: %s", compatibility, c.Section, c.Key, change, c.Reason)
+}
+
+// ModelDiff is the list of changes between two models.
+type ModelDiff struct {
+	Changes []Change
+}
+
+// Breaking returns whether one of the changes is breaking.
+func (diff *ModelDiff) Breaking() bool {
+	for _, c := range diff.Changes {
+		if c.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the changes of the assertions from the old model to the new one, in section order. The
+// changes that may break the stored policy rules are classified as breaking until CheckPolicy tells which
+// ones have rules.
+func Diff(oldModel Model, newModel Model) *ModelDiff {
+	diff := &ModelDiff{}
+	for _, sec := range []string{"r", "p", "g", "e", "m"} {
+		keys := oldModel.keys(sec)
+		for _, key := range newModel.keys(sec) {
+			if _, ok := oldModel[sec][key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+
+		for _, key := range keys {
+			change := Change{Section: sectionNameMap[sec], Key: key, Rules: -1, sec: sec}
+			oldAst, inOld := oldModel[sec][key]
+			newAst, inNew := newModel[sec][key]
+			switch {
+			case !inNew:
+				change.Kind, change.Old = Removed, oldModel.definition(sec, key)
+			case !inOld:
+				change.Kind, change.New = Added, newModel.definition(sec, key)
+			default:
+				change.Kind, change.Old, change.New = Changed, oldModel.definition(sec, key), newModel.definition(sec, key)
+				if change.Old == change.New {
+					continue
+				}
+			}
+
+			classify(&change, sec, oldAst, newAst)
+			diff.Changes = append(diff.Changes, change)
+		}
+	}
+	return diff
+}
+
+// classify sets the compatibility and the migration of the change.
+func classify(c *Change, sec string, oldAst *Assertion, newAst *Assertion) {
+	switch {
+	case c.Kind == Added:
+		c.Reason = "new definitions are compatible"
+	case c.Kind == Removed && (sec == "p" || sec == "g"):
+		c.Breaking = true
+		c.Reason = fmt.Sprintf("the rules of %s are no longer enforced", c.Key)
+		c.Migration = fmt.Sprintf("remove the rules of %s or move them to another policy type", c.Key)
+	case c.Kind == Removed:
+		c.Breaking = true
+		c.Reason = fmt.Sprintf("the callers using %s must change", c.Key)
+		c.Migration = fmt.Sprintf("update the enforce contexts referencing %s", c.Key)
+	case sec == "r":
+		oldTokens, newTokens := tokenNames(oldAst), tokenNames(newAst)
+		if len(oldTokens) == len(newTokens) {
+			c.Reason = "the request values are positional, only the names changed"
+			return
+		}
+		c.Breaking = true
+		c.Reason = fmt.Sprintf("the requests have %d values instead of %d", len(newTokens), len(oldTokens))
+		c.Migration = fmt.Sprintf("update the callers to pass %s", strings.Join(newTokens, ", "))
+	case sec == "p":
+		classifyTokens(c, tokenNames(oldAst), tokenNames(newAst))
+	case sec == "g":
+		oldArity, newArity := strings.Count(oldAst.Value, "_"), strings.Count(newAst.Value, "_")
+		if oldArity == newArity {
+			c.Reason = "the rules keep the same values"
+			return
+		}
+		c.Breaking = true
+		c.Reason = fmt.Sprintf("the rules of %s have %d values instead of %d", c.Key, newArity, oldArity)
+		if newArity > oldArity {
+			c.Migration = fmt.Sprintf("add %s, like a domain, to every %s rule", count(newArity-oldArity, "value"), c.Key)
+		} else {
+			c.Migration = fmt.Sprintf("remove the last %s of every %s rule", count(oldArity-newArity, "value"), c.Key)
+		}
+	case sec == "e":
+		c.Breaking = true
+		c.Reason = "the decisions of the existing rules may change"
+		c.Migration = "review the rules that allow and deny the same requests"
+	default:
+		c.Reason = "the matchers don't change the stored rules"
+	}
+}
+
+// classifyTokens classifies a change of the tokens of a policy definition.
+func classifyTokens(c *Change, oldTokens []string, newTokens []string) {
+	oldIndex := make(map[string]int, len(oldTokens))
+	for i, token := range oldTokens {
+		oldIndex[token] = i
+	}
+	newIndex := make(map[string]int, len(newTokens))
+	for i, token := range newTokens {
+		newIndex[token] = i
+	}
+
+	var added, removed, moved []string
+	for i, token := range newTokens {
+		j, ok := oldIndex[token]
+		switch {
+		case !ok:
+			added = append(added, fmt.Sprintf("%s at position %d", token, i+1))
+		case j != i:
+			moved = append(moved, token)
+		}
+	}
+	for i, token := range oldTokens {
+		if _, ok := newIndex[token]; !ok {
+			removed = append(removed, fmt.Sprintf("%s at position %d", token, i+1))
+		}
+	}
+
+	switch {
+	case len(oldTokens) != len(newTokens):
+		c.Breaking = true
+		c.Reason = fmt.Sprintf("the rules of %s have %d values, the definition has %d", c.Key, len(oldTokens), len(newTokens))
+	case len(added) == 0 && len(moved) != 0:
+		c.Breaking = true
+		c.Reason = fmt.Sprintf("the values of the rules of %s are reordered", c.Key)
+	case len(moved) != 0:
+		c.Breaking = true
+		c.Reason = fmt.Sprintf("the values of the rules of %s are renamed and reordered", c.Key)
+	default:
+		c.Reason = "the rules are positional, only the names changed"
+		return
+	}
+
+	var steps []string
+	if len(removed) != 0 {
+		steps = append(steps, "remove the value of "+strings.Join(removed, ", "))
+	}
+	if len(added) != 0 {
+		steps = append(steps, "add the value of "+strings.Join(added, ", "))
+	}
+	if len(moved) != 0 {
+		steps = append(steps, "reorder the values as "+strings.Join(newTokens, ", "))
+	}
+	c.Migration = strings.Join(steps, ", then ") + " of every " + c.Key + " rule"
+}
+
+// tokenNames returns the names of the tokens of the definition, like "sub".
+func tokenNames(ast *Assertion) []string {
+	names := make([]string, len(ast.Tokens))
+	for i, token := range ast.Tokens {
+		names[i] = strings.TrimPrefix(token, ast.Key+"_")
+	}
+	return names
+}
+
+// CheckPolicy counts the rules of the policy loaded in the old model, e.g. by an adapter, that the changes
+// concern. A change of the stored rules is then compatible when there are no rules to migrate.
+func (diff *ModelDiff) CheckPolicy(old Model) {
+	for i := range diff.Changes {
+		c := &diff.Changes[i]
+		if c.sec != "p" && c.sec != "g" {
+			continue
+		}
+
+		c.Rules = 0
+		if ast, ok := old[c.sec][c.Key]; ok {
+			c.Rules = len(ast.Policy)
+		}
+		if c.Breaking && c.Rules == 0 {
+			c.Breaking = false
+			c.Reason += fmt.Sprintf(", but there are no rules of %s", c.Key)
+			c.Migration = ""
+		} else if c.Breaking {
+			c.Reason += fmt.Sprintf(", %s to migrate", count(c.Rules, "rule"))
+		}
+	}
+}
+
+// count returns the count of the noun, like "1 rule" or "2 rules".
+func count(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,197 @@
+package model
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Document is the JSON and YAML form of a model. Every section maps the keys of its assertions, like "p"
+// and "p2", to their definitions as written in a CONF file:
+//
+//	request_definition:
+//	  r: sub, obj, act
+//	policy_definition:
+//	  p: sub, obj, act
+//	role_definition:
+//	  g: _, _
+//	policy_effect:
+//	  e: some(where (p.eft == allow))
+//	matchers:
+//	  m: g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+type Document struct {
+	RequestDefinition map[string]string `json:"request_definition" yaml:"request_definition"`
+	PolicyDefinition  map[string]string `json:"policy_definition" yaml:"policy_definition"`
+	RoleDefinition    map[string]string `json:"role_definition,omitempty" yaml:"role_definition,omitempty"`
+	PolicyEffect      map[string]string `json:"policy_effect" yaml:"policy_effect"`
+	Matchers          map[string]string `json:"matchers" yaml:"matchers"`
+}
+
+// sections returns the sections of the document keyed by the section of the model.
+func (doc *Document) sections() map[string]*map[string]string {
+	return map[string]*map[string]string{
+		"r": &doc.RequestDefinition,
+		"p": &doc.PolicyDefinition,
+		"g": &doc.RoleDefinition,
+		"e": &doc.PolicyEffect,
+		"m": &doc.Matchers,
+	}
+}
+
+// NewModelFromJSON creates a model from a JSON document, see Document.
+func NewModelFromJSON(data []byte) (Model, error) {
+	m := NewModel()
+
+	err := m.LoadModelFromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// NewModelFromYAML creates a model from a YAML document, see Document.
+func NewModelFromYAML(data []byte) (Model, error) {
+	m := NewModel()
+
+	err := m.LoadModelFromYAML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// LoadModelFromJSON loads the model from a JSON document.
+func (model Model) LoadModelFromJSON(data []byte) error {
+	var doc Document
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		return fmt.Errorf("invalid JSON model: %w", err)
+	}
+
+	return model.LoadDocument(&doc)
+}
+
+// LoadModelFromYAML loads the model from a YAML document.
+func (model Model) LoadModelFromYAML(data []byte) error {
+	var doc Document
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return fmt.Errorf("invalid YAML model: %w", err)
+	}
+
+	return model.LoadDocument(&doc)
+}
+
+// LoadDocument loads the model from a document.
+func (model Model) LoadDocument(doc *Document) error {
+	for _, sec := range []string{"r", "p", "g", "e", "m"} {
+		for key, value := range *doc.sections()[sec] {
+			if !isAssertionKey(sec, key) {
+				return fmt.Errorf("invalid key %q in section %s", key, sectionNameMap[sec])
+			}
+			if !model.AddDef(sec, key, value) {
+				return fmt.Errorf("empty definition of %s in section %s", key, sectionNameMap[sec])
+			}
+		}
+	}
+
+	return model.checkSections()
+}
+
+// isAssertionKey returns whether the key is a key of the section, like "p" or "p2".
+func isAssertionKey(sec string, key string) bool {
+	if key == sec {
+		return true
+	}
+	i, err := strconv.Atoi(strings.TrimPrefix(key, sec))
+	return strings.HasPrefix(key, sec) && err == nil && i >= 2 && getKeySuffix(i) == key[len(sec):]
+}
+
+// ToDocument returns the document of the model, the definitions are written back as in a CONF file.
+func (model Model) ToDocument() *Document {
+	doc := &Document{}
+	for sec, section := range doc.sections() {
+		for key := range model[sec] {
+			if *section == nil {
+				*section = make(map[string]string)
+			}
+			(*section)[key] = model.definition(sec, key)
+		}
+	}
+	return doc
+}
+
+// ToJSON returns the model as an indented JSON document.
+func (model Model) ToJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(model.ToDocument()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ToYAML returns the model as a YAML document.
+func (model Model) ToYAML() ([]byte, error) {
+	return yaml.Marshal(model.ToDocument())
+}
+
+var (
+	escapedTokenReg     = regexp.MustCompile(`\b([rp][0-9]*)_(\w+)`)
+	escapedAttributeReg = regexp.MustCompile(`\[([rp][0-9]*_\w+(?:\.\w+)+)\]`)
+)
+
+// definition returns the definition of the assertion with the request and policy tokens written with a
+// dot, like "r.sub", and the attributes unbracketed.
+func (model Model) definition(sec string, key string) string {
+	value := model[sec][key].Value
+	if sec == "r" || sec == "p" {
+		tokens := make([]string, len(model[sec][key].Tokens))
+		for i, token := range model[sec][key].Tokens {
+			tokens[i] = strings.TrimPrefix(token, key+"_")
+		}
+		return strings.Join(tokens, ", ")
+	}
+	return model.unescape(value)
+}
+
+// unescape writes the request and policy tokens of an escaped text with a dot and unbrackets the attributes.
+func (model Model) unescape(value string) string {
+	value = escapedAttributeReg.ReplaceAllString(value, "$1")
+	return escapedTokenReg.ReplaceAllStringFunc(value, func(token string) string {
+		key := escapedTokenReg.FindStringSubmatch(token)[1]
+		if model.hasToken(token) || token == key+"_eft" && key[0] == 'p' {
+			return strings.Replace(token, "_", ".", 1)
+		}
+		return token
+	})
+}
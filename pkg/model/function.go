@@ -21,6 +21,7 @@ package model
 // THE SOFTWARE.
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/Knetic/govaluate"
@@ -30,34 +31,111 @@ import (
 // FunctionMap represents the collection of Function.
 type FunctionMap struct {
 	fns *sync.Map
+	// signatures holds the signatures of the functions that declare one.
+	signatures *sync.Map
+	// builtins holds the names of the built-in functions that were not replaced.
+	builtins *sync.Map
 }
 
 // [string]govaluate.ExpressionFunction
 
-// AddFunction adds an expression function.
+// AddFunction adds an expression function, it replaces the function of the same name, built-in ones included.
 func (fm *FunctionMap) AddFunction(name string, function govaluate.ExpressionFunction) {
-	fm.fns.LoadOrStore(name, function)
+	fm.fns.Store(name, function)
+	fm.signatures.Delete(name)
+	fm.builtins.Delete(name)
 }
 
+// AddFunctionWithSignature adds an expression function with its signature, the matchers are type-checked
+// against the signature and the function is only called with the arguments it declares. Like AddFunction,
+// it replaces the function of the same name and its signature.
+func (fm *FunctionMap) AddFunctionWithSignature(name string, signature Signature, function govaluate.ExpressionFunction) {
+	fm.fns.Store(name, signature.Wrap(name, function))
+	fm.signatures.Store(name, signature)
+	fm.builtins.Delete(name)
+}
+
+// GetSignature returns the signature of the function of the given name.
+func (fm *FunctionMap) GetSignature(name string) (Signature, bool) {
+	if signature, ok := fm.signatures.Load(name); ok {
+		return signature.(Signature), true
+	}
+	return Signature{}, false
+}
+
+// ListFunctions returns the functions sorted by name.
+func (fm *FunctionMap) ListFunctions() []FunctionInfo {
+	var functions []FunctionInfo
+	fm.fns.Range(func(k interface{}, v interface{}) bool {
+		info := FunctionInfo{Name: k.(string)}
+		if signature, ok := fm.GetSignature(info.Name); ok {
+			info.Signature = &signature
+		}
+		functions = append(functions, info)
+		return true
+	})
+	sort.Slice(functions, func(i, j int) bool { return functions[i].Name < functions[j].Name })
+	return functions
+}
+
+var (
+	matchSignature = NewSignature(BoolType, StringType, StringType)
+	timeSignatures = map[string]Signature{
+		"now":         NewSignature(NumberType),
+		"timeInRange": {Args: []ArgType{StringType, StringType, StringType}, Optional: 1, Result: BoolType},
+		"dayOfWeek":   {Args: []ArgType{StringType}, Optional: 1, Result: StringType},
+		"before":      NewSignature(BoolType, AnyType),
+		"after":       NewSignature(BoolType, AnyType),
+	}
+)
+
 // LoadFunctionMap loads an initial function map.
 func LoadFunctionMap() FunctionMap {
 	fm := &FunctionMap{}
 	fm.fns = &sync.Map{}
+	fm.signatures = &sync.Map{}
+	fm.builtins = &sync.Map{}
 
-	fm.AddFunction("keyMatch", util.KeyMatchFunc)
-	fm.AddFunction("keyGet", util.KeyGetFunc)
-	fm.AddFunction("keyMatch2", util.KeyMatch2Func)
-	fm.AddFunction("keyGet2", util.KeyGet2Func)
-	fm.AddFunction("keyMatch3", util.KeyMatch3Func)
-	fm.AddFunction("keyMatch4", util.KeyMatch4Func)
-	fm.AddFunction("keyMatch5", util.KeyMatch5Func)
-	fm.AddFunction("regexMatch", util.RegexMatchFunc)
-	fm.AddFunction("ipMatch", util.IPMatchFunc)
-	fm.AddFunction("globMatch", util.GlobMatchFunc)
+	fm.addBuiltin("keyMatch", matchSignature, util.KeyMatchFunc)
+	fm.addBuiltin("keyGet", NewSignature(StringType, StringType, StringType), util.KeyGetFunc)
+	fm.addBuiltin("keyMatch2", matchSignature, util.KeyMatch2Func)
+	fm.addBuiltin("keyGet2", NewSignature(StringType, StringType, StringType, StringType), util.KeyGet2Func)
+	fm.addBuiltin("keyMatch3", matchSignature, util.KeyMatch3Func)
+	fm.addBuiltin("keyMatch4", matchSignature, util.KeyMatch4Func)
+	fm.addBuiltin("keyMatch5", matchSignature, util.KeyMatch5Func)
+	fm.addBuiltin("regexMatch", matchSignature, util.RegexMatchFunc)
+	fm.addBuiltin("ipMatch", matchSignature, util.IPMatchFunc)
+	fm.addBuiltin("ipInAny", matchSignature, util.IPInAnyFunc)
+	fm.addBuiltin("ipRange", matchSignature, util.IPRangeFunc)
+	fm.addBuiltin("portInRange", NewSignature(BoolType, AnyType, StringType), util.PortInRangeFunc)
+	fm.addBuiltin("hostMatch", matchSignature, util.HostMatchFunc)
+	fm.addBuiltin("semverMatch", matchSignature, util.SemverMatchFunc)
+	fm.addBuiltin("jsonGet", NewSignature(AnyType, AnyType, StringType), util.JSONGetFunc)
+	fm.addBuiltin("globMatch", matchSignature, util.GlobMatchFunc)
+	for name, function := range util.GenerateTimeFunctions(util.SystemClock) {
+		fm.addBuiltin(name, timeSignatures[name], function)
+	}
 
 	return *fm
 }
 
+// addBuiltin adds a built-in function, which validates its arguments itself, with its signature.
+func (fm *FunctionMap) addBuiltin(name string, signature Signature, function govaluate.ExpressionFunction) {
+	fm.fns.Store(name, function)
+	fm.signatures.Store(name, signature)
+	fm.builtins.Store(name, true)
+}
+
+// SetClock replaces the built-in time functions by the ones reading the current time from clock.
+// The time functions replaced with AddFunction are kept.
+func (fm *FunctionMap) SetClock(clock util.Clock) {
+	for name, function := range util.GenerateTimeFunctions(clock) {
+		if _, ok := fm.builtins.Load(name); ok {
+			fm.fns.Store(name, function)
+		}
+	}
+}
+
 // GetFunctions return a map with all the functions
 func (fm *FunctionMap) GetFunctions() map[string]govaluate.ExpressionFunction {
 	ret := make(map[string]govaluate.ExpressionFunction)
@@ -24,12 +24,15 @@ import (
 	"container/list"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/bhojpur/policy/pkg/config"
+	"github.com/bhojpur/policy/pkg/effector"
 	"github.com/bhojpur/policy/pkg/log"
 	"github.com/bhojpur/policy/pkg/util"
 )
@@ -85,6 +88,10 @@ func (model Model) AddDef(sec string, key string, value string) bool {
 		ast.Value = strings.Replace(strings.Replace(ast.Value, "[", "(", -1), "]", ")", -1)
 	}
 
+	if sec == "m" {
+		ast.Value = util.EscapeAttributes(ast.Value)
+	}
+
 	_, ok := model[sec]
 	if !ok {
 		model[sec] = make(AssertionMap)
@@ -160,8 +167,21 @@ func NewModelFromString(text string) (Model, error) {
 	return m, nil
 }
 
-// LoadModel loads the model from model CONF file.
+// LoadModel loads the model from model CONF file, or from a JSON or YAML document when the file has
+// a .json, .yaml or .yml extension.
 func (model Model) LoadModel(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".yaml", ".yml":
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(filepath.Ext(path), ".json") {
+			return model.LoadModelFromJSON(data)
+		}
+		return model.LoadModelFromYAML(data)
+	}
+
 	cfg, err := config.NewConfig(path)
 	if err != nil {
 		return err
@@ -184,6 +204,11 @@ func (model Model) loadModelFromConfig(cfg config.ConfigInterface) error {
 	for s := range sectionNameMap {
 		loadSection(model, cfg, s)
 	}
+	return model.checkSections()
+}
+
+// checkSections checks that the model has the required sections and supported policy effects.
+func (model Model) checkSections() error {
 	ms := make([]string, 0)
 	for _, rs := range requiredSections {
 		if !model.hasSection(rs) {
@@ -193,6 +218,11 @@ func (model Model) loadModelFromConfig(cfg config.ConfigInterface) error {
 	if len(ms) > 0 {
 		return fmt.Errorf("missing required sections: %s", strings.Join(ms, ","))
 	}
+	for _, ast := range model["e"] {
+		if _, err := effector.ParseEffect(ast.Value); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -222,7 +252,7 @@ func (model Model) PrintModel() {
 }
 
 func (model Model) SortPoliciesBySubjectHierarchy() error {
-	if model["e"]["e"].Value != "subjectPriority(p_eft) || deny" {
+	if expr, err := effector.ParseEffect(model["e"]["e"].Value); err != nil || !expr.Uses("subjectPriority") {
 		return nil
 	}
 	subIndex := 0
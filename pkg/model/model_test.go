@@ -99,6 +99,21 @@ func TestLoadModelFromConfig(t *testing.T) {
 	}
 }
 
+func TestLoadModelWithInvalidEffect(t *testing.T) {
+	for _, effect := range []string{"some(where (p.eft = allow))", "some(where (p.eft == allow)", "unknownAlgorithm(p.eft)", "allow &&"} {
+		m := NewModel()
+		err := m.loadModelFromConfig(&MockConfig{data: map[string]string{
+			"request_definition::r": "sub, obj, act",
+			"policy_definition::p":  "sub, obj, act",
+			"policy_effect::e":      effect,
+			"matchers::m":           "r.sub == p.sub",
+		}})
+		if err == nil {
+			t.Errorf("policy effect %s should return an error", effect)
+		}
+	}
+}
+
 func TestHasSection(t *testing.T) {
 	m := NewModel()
 	_ = m.loadModelFromConfig(basicConfig)
@@ -164,3 +179,347 @@ func testModelToText(t *testing.T, mData, mExpected string) {
 		}
 	}
 }
+
+func testIndexedPolicy(t *testing.T, ast *Assertion, values map[string]string, res []int) {
+	t.Helper()
+	myRes, ok := ast.GetIndexedPolicy(values)
+	if !ok {
+		t.Errorf("%v: index was expected to be used", values)
+		return
+	}
+	if len(myRes) != len(res) {
+		t.Errorf("%v: %v, supposed to be %v", values, myRes, res)
+		return
+	}
+	for i := range res {
+		if myRes[i] != res[i] {
+			t.Errorf("%v: %v, supposed to be %v", values, myRes, res)
+			return
+		}
+	}
+}
+
+func TestPolicyIndex(t *testing.T) {
+	m, _ := NewModelFromFile(basicExample)
+	m.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+	m.AddPolicy("p", "p", []string{"bob", "data2", "write"})
+	m.AddPolicy("p", "p", []string{"alice", "data2", "read"})
+
+	ast := m["p"]["p"]
+	if err := ast.BuildIndex("p_unknown"); err == nil {
+		t.Error("indexing an undefined token should fail")
+	}
+	if err := ast.BuildIndex("p_obj", "p_act"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ast.GetIndexedPolicy(map[string]string{"p_sub": "alice"}); ok {
+		t.Error("index should not be used for a token which is not indexed")
+	}
+
+	testIndexedPolicy(t, ast, map[string]string{"p_obj": "data2"}, []int{1, 2})
+	testIndexedPolicy(t, ast, map[string]string{"p_obj": "data2", "p_act": "read", "p_sub": "any"}, []int{2})
+	testIndexedPolicy(t, ast, map[string]string{"p_obj": "data3"}, nil)
+
+	m.AddPolicy("p", "p", []string{"cathy", "data2", "read"})
+	testIndexedPolicy(t, ast, map[string]string{"p_obj": "data2", "p_act": "read"}, []int{2, 3})
+
+	m.RemovePolicy("p", "p", []string{"bob", "data2", "write"})
+	testIndexedPolicy(t, ast, map[string]string{"p_obj": "data2"}, []int{1, 2})
+
+	m.UpdatePolicy("p", "p", []string{"alice", "data2", "read"}, []string{"alice", "data3", "read"})
+	testIndexedPolicy(t, ast, map[string]string{"p_obj": "data2"}, []int{2})
+	testIndexedPolicy(t, ast, map[string]string{"p_obj": "data3"}, []int{1})
+
+	m.RemoveFilteredPolicy("p", "p", 2, "read")
+	testIndexedPolicy(t, ast, map[string]string{"p_act": "read"}, nil)
+
+	// rules appended directly to the policy make the index stale until it is built again.
+	ast.Policy = append(ast.Policy, []string{"bob", "data1", "read"})
+	ast.PolicyMap["bob,data1,read"] = len(ast.Policy) - 1
+	if _, ok := ast.GetIndexedPolicy(map[string]string{"p_act": "read"}); ok {
+		t.Error("a stale index should not be used")
+	}
+	if err := ast.BuildIndex(ast.IndexedTokens()...); err != nil {
+		t.Fatal(err)
+	}
+	testIndexedPolicy(t, ast, map[string]string{"p_act": "read"}, []int{0})
+
+	newAst := m.Copy()["p"]["p"]
+	testIndexedPolicy(t, newAst, map[string]string{"p_obj": "data1"}, []int{0})
+
+	m.ClearPolicy()
+	testIndexedPolicy(t, ast, map[string]string{"p_act": "read"}, nil)
+
+	ast.ClearIndex()
+	if _, ok := ast.GetIndexedPolicy(map[string]string{"p_obj": "data1"}); ok {
+		t.Error("index should not be used after being cleared")
+	}
+}
+
+func TestSignatureString(t *testing.T) {
+	tests := []struct {
+		sig  Signature
+		want string
+	}{
+		{NewSignature(BoolType, StringType, StringType), "(string, string) bool"},
+		{NewSignature(NumberType), "() number"},
+		{Signature{Args: []ArgType{StringType, StringType, StringType}, Optional: 1, Result: BoolType}, "(string, string[, string]) bool"},
+		{Signature{Args: []ArgType{StringType}, Optional: 1, Result: StringType}, "([string]) string"},
+		{Signature{Args: []ArgType{StringType, AnyType}, Variadic: true, Result: BoolType}, "(string, ...any) bool"},
+	}
+	for _, test := range tests {
+		if got := test.sig.String(); got != test.want {
+			t.Errorf("%#v: got %q, want %q", test.sig, got, test.want)
+		}
+	}
+}
+
+func TestCheckFunctionCalls(t *testing.T) {
+	fm := LoadFunctionMap()
+	tests := []struct {
+		matcher string
+		err     string
+	}{
+		{"g(r.sub, p.sub) && keyMatch2(r.obj, p.obj) && regexMatch(r.act, p.act)", ""},
+		{"keyGet2(r.obj, p.obj, 'id') == r.sub && timeInRange('09:00', '17:00')", ""},
+		{"myFunction(r.sub, 1, 2) && r.obj == p.obj", ""},
+		{"r.sub == p.sub && keyMatch2(r.obj, p.obj, p.act)", "matcher m: position 19: keyMatch2(r_obj, p_obj, p_act): keyMatch2 expects 2 arguments, got 3"},
+		{"g(r.sub, p.sub, r.obj)", "matcher m: position 1: g(r_sub, p_sub, r_obj): g expects 2 arguments, got 3"},
+		{"keyMatch(r.obj, 12) || keyMatch(r.obj, 1 + 2)", "matcher m: position 1: keyMatch(r_obj, 12): keyMatch argument 2 must be a string, got a number"},
+		{"regexMatch(r.obj, p.obj) && !(r.act == p.act || ipMatch(r.sub))", "ipMatch expects 2 arguments, got 1"},
+	}
+	for _, test := range tests {
+		m, err := NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = ` + test.matcher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.CheckFunctionCalls(fm.GetSignature)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", test.matcher, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got error %v, want %q", test.matcher, err, test.err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	m, err := NewModelFromFile(basicExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diagnostics := m.Validate(); len(diagnostics) != 0 {
+		t.Errorf("basic model should be valid, got %v", diagnostics)
+	}
+
+	m = NewModel()
+	m.AddDef("r", "r", "sub, obj, act")
+	m.AddDef("p", "p", "sub, obj, act, sub_rule, sub")
+	m.AddDef("g", "g", "_")
+	m.AddDef("e", "e", "some(where (p.eft == maybe))")
+	m.AddDef("m", "m", "g(r.sub, p.sub) && r.obj == p.obj2 && isOwner(r.sub, r.obj) && eval(p.rule) && r.act == 'p.act2'")
+	m.AddDef("m", "m2", "eval(r.sub) && r2.sub == p.sub")
+	m.AddDef("m", "m3", "r.sub.Age > 18 && r.obj.Owner == p.obj3 && keyMatch(r.obj.Name)")
+
+	var got []string
+	for _, d := range m.Validate() {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"error: policy_definition::p: duplicate token sub",
+		"error: role_definition::g: \"_\" should have at least 2 _",
+		`error: policy_effect::e: invalid policy effect "some(where (p.eft == maybe))": expected allow or deny, got "maybe"`,
+		"error: matchers::m:1: g(r.sub, p.sub): g expects 1 arguments, got 2",
+		"error: matchers::m:29: undeclared token p.obj2",
+		"warning: matchers::m:39: unknown function isOwner",
+		"error: matchers::m:69: eval() references undeclared policy token p.rule",
+		"error: matchers::m2:6: eval() should reference a policy token, got r.sub",
+		"error: matchers::m2:16: undeclared token r2.sub",
+		"error: matchers::m3:34: undeclared token p.obj3",
+		"error: matchers::m3:44: keyMatch(r.obj.Name): keyMatch expects 2 arguments, got 1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	err = Diagnostics{{Severity: WarningSeverity, Section: "matchers", Key: "m", Message: "unknown function isOwner"}}.Err()
+	if err != nil {
+		t.Errorf("warnings should not be errors, got %v", err)
+	}
+	if NewModel().Validate().Err() == nil {
+		t.Error("missing sections should be errors")
+	}
+}
+
+func TestModelDocuments(t *testing.T) {
+	conf, err := NewModelFromFile(filepath.Join("../..", "examples", "rbac_model.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"rbac_model.json", "rbac_model.yaml"} {
+		m, err := NewModelFromFile(filepath.Join("../..", "examples", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testSameModel(t, name, m, conf)
+	}
+
+	m, err := NewModelFromFile(filepath.Join("../..", "examples", "multiple_policy_definitions_model.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// attributes are written back unbracketed.
+	m.AddDef("m", "m3", "r2.sub.Age > 18 && r2.obj in ('/data1', '/data2') && keyMatch(r2.obj, '/data*')")
+
+	data, err := m.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"m3": "r2.sub.Age > 18 && r2.obj in ('/data1', '/data2') && keyMatch(r2.obj, '/data*')"`) {
+		t.Errorf("unexpected JSON model:\n%s", data)
+	}
+	fromJSON, err := NewModelFromJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSameModel(t, "JSON", fromJSON, m)
+
+	data, err = m.ToYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := NewModelFromYAML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSameModel(t, "YAML", fromYAML, m)
+
+	for _, text := range []string{
+		`{"request_definition": {"r": "sub"}, "policy_definition": {"p": "sub"}, "matchers": {"m": "r.sub == p.sub"}}`,
+		`{"request_definitions": {"r": "sub"}}`,
+		`{"request_definition": {"r1": "sub"}, "policy_definition": {"p": "sub"}, "policy_effect": {"e": "some(where (p.eft == allow))"}, "matchers": {"m": "r.sub == p.sub"}}`,
+	} {
+		if _, err := NewModelFromJSON([]byte(text)); err == nil {
+			t.Errorf("%s: the model should be invalid", text)
+		}
+	}
+	if _, err := NewModelFromYAML([]byte("matcher:\n  m: r.sub == p.sub\n")); err == nil {
+		t.Error("misspelt sections should be rejected")
+	}
+}
+
+func testSameModel(t *testing.T, name string, m Model, expected Model) {
+	t.Helper()
+	for _, sec := range []string{"r", "p", "g", "e", "m"} {
+		if len(m[sec]) != len(expected[sec]) {
+			t.Errorf("%s: section %s has %d assertions, it should have %d", name, sec, len(m[sec]), len(expected[sec]))
+		}
+		for key, ast := range expected[sec] {
+			if m[sec][key] == nil {
+				t.Errorf("%s: %s is missing", name, key)
+			} else if m[sec][key].Value != ast.Value || strings.Join(m[sec][key].Tokens, ",") != strings.Join(ast.Tokens, ",") {
+				t.Errorf("%s: %s is %q, it should be %q", name, key, m[sec][key].Value, ast.Value)
+			}
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	oldModel, err := NewModelFromFile(filepath.Join("../..", "examples", "rbac_model.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := Diff(oldModel, oldModel); len(diff.Changes) != 0 || diff.Breaking() {
+		t.Errorf("a model should not differ from itself, got %v", diff.Changes)
+	}
+
+	newModel := func(p string, g string, e string) Model {
+		m := NewModel()
+		m.AddDef("r", "r", "sub, obj, act")
+		m.AddDef("p", "p", p)
+		m.AddDef("p", "p2", "sub, act")
+		m.AddDef("g", "g", g)
+		m.AddDef("e", "e", e)
+		m.AddDef("m", "m", "r.sub == p.sub && r.obj == p.obj && r.act == p.act")
+		return m
+	}
+	allow := "some(where (p.eft == allow))"
+
+	var got []string
+	diff := Diff(oldModel, newModel("sub, obj, act, tenant", "_, _, _", "!some(where (p.eft == deny))"))
+	for _, c := range diff.Changes {
+		got = append(got, c.String(), c.Migration)
+	}
+	want := []string{
+		`breaking: policy_definition::p changed "sub, obj, act" to "sub, obj, act, tenant": the rules of p have 3 values, the definition has 4`,
+		"add the value of tenant at position 4 of every p rule",
+		`compatible: policy_definition::p2 added "sub, act": new definitions are compatible`,
+		"",
+		`breaking: role_definition::g changed "_, _" to "_, _, _": the rules of g have 3 values instead of 2`,
+		"add 1 value, like a domain, to every g rule",
+		`breaking: policy_effect::e changed "some(where (p.eft == allow))" to "!some(where (p.eft == deny))": the decisions of the existing rules may change`,
+		"review the rules that allow and deny the same requests",
+		`compatible: matchers::m changed "g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act" to "r.sub == p.sub && r.obj == p.obj && r.act == p.act": the matchers don't change the stored rules`,
+		"",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got changes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !diff.Breaking() {
+		t.Error("the diff should be breaking")
+	}
+
+	for _, test := range []struct {
+		p         string
+		breaking  bool
+		migration string
+	}{
+		{"subject, object, action", false, ""},
+		{"sub, act, obj", true, "reorder the values as sub, act, obj of every p rule"},
+		{"sub, obj", true, "remove the value of act at position 3 of every p rule"},
+		{"obj, sub, action", true, "remove the value of act at position 3, then add the value of action at position 3, then reorder the values as obj, sub, action of every p rule"},
+	} {
+		c := Diff(oldModel, newModel(test.p, "_, _", allow)).Changes[0]
+		if c.Key != "p" || c.Breaking != test.breaking || c.Migration != test.migration {
+			t.Errorf("%s: got %v, %q, want %v, %q", test.p, c, c.Migration, test.breaking, test.migration)
+		}
+	}
+
+	removed := NewModel()
+	removed.AddDef("r", "r", "sub, obj")
+	removed.AddDef("p", "p", "sub, obj, act")
+	removed.AddDef("e", "e", allow)
+	removed.AddDef("m", "m", "r.sub == p.sub && r.obj == p.obj")
+	diff = Diff(oldModel, removed)
+	if len(diff.Changes) != 3 || diff.Changes[0].Key != "r" || diff.Changes[1].Kind != Removed || diff.Changes[1].Key != "g" || !diff.Changes[1].Breaking {
+		t.Errorf("unexpected changes %v", diff.Changes)
+	}
+
+	// the changes of the policy types without rules become compatible.
+	oldModel.AddPolicy("p", "p", []string{"alice", "data1", "read"})
+	diff = Diff(oldModel, newModel("sub, obj, act, tenant", "_, _, _", allow))
+	diff.CheckPolicy(oldModel)
+	if c := diff.Changes[0]; !c.Breaking || c.Rules != 1 || !strings.HasSuffix(c.Reason, ", 1 rule to migrate") {
+		t.Errorf("p should be breaking with 1 rule, got %v", c)
+	}
+	if c := diff.Changes[2]; c.Key != "g" || c.Breaking || c.Rules != 0 || c.Migration != "" {
+		t.Errorf("g should be compatible without rules, got %v", c)
+	}
+	if diff.Changes[3].Rules != -1 {
+		t.Error("the rules of matchers should be unknown")
+	}
+}
@@ -97,11 +97,13 @@ func (model Model) ClearPolicy() {
 	for _, ast := range model["p"] {
 		ast.Policy = nil
 		ast.PolicyMap = map[string]int{}
+		ast.clearIndexedPolicy()
 	}
 
 	for _, ast := range model["g"] {
 		ast.Policy = nil
 		ast.PolicyMap = map[string]int{}
+		ast.clearIndexedPolicy()
 	}
 }
 
@@ -153,6 +155,7 @@ func (model Model) AddPolicy(sec string, ptype string, rule []string) {
 	assertion := model[sec][ptype]
 	assertion.Policy = append(assertion.Policy, rule)
 	assertion.PolicyMap[strings.Join(rule, DefaultSep)] = len(model[sec][ptype].Policy) - 1
+	assertion.addToIndex(rule)
 
 	if sec == "p" && assertion.priorityIndex >= 0 {
 		if idxInsert, err := strconv.Atoi(rule[assertion.priorityIndex]); err == nil {
@@ -203,6 +206,7 @@ func (model Model) RemovePolicy(sec string, ptype string, rule []string) bool {
 		return false
 	}
 
+	model[sec][ptype].removeFromIndex(model[sec][ptype].Policy[index])
 	model[sec][ptype].Policy = append(model[sec][ptype].Policy[:index], model[sec][ptype].Policy[index+1:]...)
 	delete(model[sec][ptype].PolicyMap, strings.Join(rule, DefaultSep))
 	for i := index; i < len(model[sec][ptype].Policy); i++ {
@@ -220,7 +224,9 @@ func (model Model) UpdatePolicy(sec string, ptype string, oldRule []string, newR
 		return false
 	}
 
+	model[sec][ptype].removeFromIndex(model[sec][ptype].Policy[index])
 	model[sec][ptype].Policy[index] = newRule
+	model[sec][ptype].addToIndex(newRule)
 	delete(model[sec][ptype].PolicyMap, oldPolicy)
 	model[sec][ptype].PolicyMap[strings.Join(newRule, DefaultSep)] = index
 
@@ -236,7 +242,9 @@ func (model Model) UpdatePolicies(sec string, ptype string, oldRules, newRules [
 	defer func() {
 		if rollbackFlag {
 			for index, oldNewIndex := range modifiedRuleIndex {
+				model[sec][ptype].removeFromIndex(model[sec][ptype].Policy[index])
 				model[sec][ptype].Policy[index] = oldRules[oldNewIndex[0]]
+				model[sec][ptype].addToIndex(oldRules[oldNewIndex[0]])
 				oldPolicy := strings.Join(oldRules[oldNewIndex[0]], DefaultSep)
 				newPolicy := strings.Join(newRules[oldNewIndex[1]], DefaultSep)
 				delete(model[sec][ptype].PolicyMap, newPolicy)
@@ -254,7 +262,9 @@ func (model Model) UpdatePolicies(sec string, ptype string, oldRules, newRules [
 			return false
 		}
 
+		model[sec][ptype].removeFromIndex(model[sec][ptype].Policy[index])
 		model[sec][ptype].Policy[index] = newRules[newIndex]
+		model[sec][ptype].addToIndex(newRules[newIndex])
 		delete(model[sec][ptype].PolicyMap, oldPolicy)
 		model[sec][ptype].PolicyMap[strings.Join(newRules[newIndex], DefaultSep)] = index
 		modifiedRuleIndex[index] = []int{oldIndex, newIndex}
@@ -280,6 +290,7 @@ func (model Model) RemovePoliciesWithEffected(sec string, ptype string, rules []
 		}
 
 		effected = append(effected, rule)
+		model[sec][ptype].removeFromIndex(model[sec][ptype].Policy[index])
 		model[sec][ptype].Policy = append(model[sec][ptype].Policy[:index], model[sec][ptype].Policy[index+1:]...)
 		delete(model[sec][ptype].PolicyMap, strings.Join(rule, DefaultSep))
 		for i := index; i < len(model[sec][ptype].Policy); i++ {
@@ -307,6 +318,7 @@ func (model Model) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int
 
 		if matched {
 			effects = append(effects, rule)
+			model[sec][ptype].removeFromIndex(rule)
 		} else {
 			tmp = append(tmp, rule)
 			model[sec][ptype].PolicyMap[strings.Join(rule, DefaultSep)] = len(tmp) - 1
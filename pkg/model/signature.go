@@ -0,0 +1,372 @@
+package model
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+)
+
+// ArgType is the type of an argument or of the result of a function of the matchers.
+type ArgType string
+
+const (
+	// AnyType accepts any value.
+	AnyType ArgType = "any"
+	// StringType is the type of the strings.
+	StringType ArgType = "string"
+	// NumberType is the type of the numbers, which the expression evaluation handles as float64.
+	NumberType ArgType = "number"
+	// BoolType is the type of the booleans.
+	BoolType ArgType = "bool"
+)
+
+// accepts returns whether a value of type t can be given for an argument of the type.
+func (argType ArgType) accepts(t ArgType) bool {
+	return argType == AnyType || t == AnyType || argType == t
+}
+
+// acceptsValue returns whether the value can be given for an argument of the type.
+func (argType ArgType) acceptsValue(value interface{}) bool {
+	switch argType {
+	case StringType:
+		_, ok := value.(string)
+		return ok
+	case NumberType:
+		_, ok := value.(float64)
+		return ok
+	case BoolType:
+		_, ok := value.(bool)
+		return ok
+	default:
+		return true
+	}
+}
+
+// Signature declares the arguments and the result of a function of the matchers.
+type Signature struct {
+	// Args are the types of the arguments.
+	Args []ArgType
+	// Optional is the number of trailing arguments that can be omitted.
+	Optional int
+	// Variadic tells that the last argument can be repeated.
+	Variadic bool
+	// Result is the type of the result.
+	Result ArgType
+}
+
+// NewSignature returns the signature of a function taking args and returning result.
+func NewSignature(result ArgType, args ...ArgType) Signature {
+	return Signature{Args: args, Result: result}
+}
+
+// String returns the signature like "(string, string[, string]) bool".
+func (sig Signature) String() string {
+	var str strings.Builder
+	str.WriteString("(")
+	required := len(sig.Args) - sig.Optional
+	for i, arg := range sig.Args {
+		switch {
+		case i >= required && i != 0:
+			str.WriteString("[, ")
+		case i >= required:
+			str.WriteString("[")
+		case i != 0:
+			str.WriteString(", ")
+		}
+		if sig.Variadic && i == len(sig.Args)-1 {
+			str.WriteString("...")
+		}
+		str.WriteString(string(arg))
+	}
+	str.WriteString(strings.Repeat("]", sig.Optional))
+	str.WriteString(") ")
+	str.WriteString(string(sig.Result))
+	return str.String()
+}
+
+// checkArgs returns an error when arguments of the given types can't be given to the function.
+func (sig Signature) checkArgs(args []ArgType) error {
+	required := len(sig.Args) - sig.Optional
+	if sig.Variadic && required == len(sig.Args) {
+		required--
+	}
+	switch {
+	case required == len(sig.Args) && len(args) != required:
+		return fmt.Errorf("expects %d arguments, got %d", required, len(args))
+	case len(args) < required:
+		return fmt.Errorf("expects at least %d arguments, got %d", required, len(args))
+	case len(args) > len(sig.Args) && !sig.Variadic:
+		return fmt.Errorf("expects at most %d arguments, got %d", len(sig.Args), len(args))
+	}
+
+	for i, arg := range args {
+		expected := sig.Args[len(sig.Args)-1]
+		if i < len(sig.Args) {
+			expected = sig.Args[i]
+		}
+		if !expected.accepts(arg) {
+			return fmt.Errorf("argument %d must be a %s, got a %s", i+1, expected, arg)
+		}
+	}
+	return nil
+}
+
+// Wrap returns the function checking its arguments against the signature before calling function.
+func (sig Signature) Wrap(name string, function govaluate.ExpressionFunction) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		types := make([]ArgType, len(args))
+		for i, arg := range args {
+			types[i] = AnyType
+			for _, t := range []ArgType{StringType, NumberType, BoolType} {
+				if t.acceptsValue(arg) {
+					types[i] = t
+				}
+			}
+		}
+		if err := sig.checkArgs(types); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		return function(args...)
+	}
+}
+
+// FunctionInfo describes a function of the matchers, the signature is nil when the function doesn't declare one.
+type FunctionInfo struct {
+	Name      string
+	Signature *Signature
+}
+
+// String returns the function like "keyMatch(string, string) bool".
+func (info FunctionInfo) String() string {
+	if info.Signature == nil {
+		return info.Name + "(...)"
+	}
+	return info.Name + info.Signature.String()
+}
+
+// SignatureLookup returns the signature of the function of the given name.
+type SignatureLookup func(name string) (Signature, bool)
+
+// keywords are the identifiers followed by a parenthesis that are not function calls.
+var keywords = map[string]bool{"in": true, "IN": true}
+
+// matcherCall is a function call of a matcher.
+type matcherCall struct {
+	name string
+	pos  int
+	text string
+	args []string
+	// argPos are the positions of the arguments.
+	argPos []int
+}
+
+// CheckFunctionCalls type-checks the function calls of the matchers against the signatures of lookup and of
+// the g() functions of the role definitions. The calls of functions without a signature are not checked.
+func (model Model) CheckFunctionCalls(lookup SignatureLookup) error {
+	signatures := func(name string) (Signature, bool) {
+		if sig, ok := model.GetSignature(name); ok {
+			return sig, true
+		}
+		return lookup(name)
+	}
+
+	var errs []string
+	for _, key := range model.keys("m") {
+		matcher := model["m"][key].Value
+		for _, err := range checkCalls(matcher, 0, signatures) {
+			errs = append(errs, fmt.Sprintf("matcher %s: position %d: %s", key, err.pos, err.msg))
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// GetSignature returns the signature of the functions the model defines: the g() functions of the role
+// definitions and eval().
+func (model Model) GetSignature(name string) (Signature, bool) {
+	if ast, ok := model["g"][name]; ok {
+		args := make([]ArgType, strings.Count(ast.Value, "_"))
+		for i := range args {
+			args[i] = StringType
+		}
+		return NewSignature(BoolType, args...), true
+	}
+	if name == "eval" {
+		return NewSignature(BoolType, AnyType), true
+	}
+	return Signature{}, false
+}
+
+// callError is a misused function call at pos of the matcher, starting at 1.
+type callError struct {
+	pos int
+	msg string
+}
+
+// checkCalls type-checks the function calls of the expression s at offset of the matcher.
+func checkCalls(s string, offset int, signatures SignatureLookup) []callError {
+	var errs []callError
+	for _, call := range findCalls(s) {
+		var types []ArgType
+		for i, arg := range call.args {
+			errs = append(errs, checkCalls(arg, offset+call.argPos[i], signatures)...)
+			types = append(types, exprType(arg, signatures))
+		}
+
+		sig, ok := signatures(call.name)
+		if !ok {
+			continue
+		}
+		if err := sig.checkArgs(types); err != nil {
+			errs = append(errs, callError{offset + call.pos + 1, fmt.Sprintf("%s: %s %s", call.text, call.name, err)})
+		}
+	}
+	return errs
+}
+
+// skip returns the position after the string literal or the bracketed variable starting at i, or i when there is none.
+func skip(s string, i int) int {
+	var end byte
+	switch s[i] {
+	case '\'', '"':
+		end = s[i]
+	case '[':
+		end = ']'
+	default:
+		return i
+	}
+	if j := strings.IndexByte(s[i+1:], end); j != -1 {
+		return i + j + 2
+	}
+	return len(s)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// findCalls returns the outermost function calls of the expression.
+func findCalls(s string) []matcherCall {
+	var calls []matcherCall
+	for i := 0; i < len(s); {
+		if j := skip(s, i); j != i {
+			i = j
+			continue
+		}
+		if !isIdentByte(s[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(s) && isIdentByte(s[i]) {
+			i++
+		}
+		name := s[start:i]
+		open := i
+		for open < len(s) && s[open] == ' ' {
+			open++
+		}
+		if open == len(s) || s[open] != '(' || keywords[name] || (name[0] >= '0' && name[0] <= '9') {
+			continue
+		}
+
+		call := matcherCall{name: name, pos: start}
+		depth, argStart := 0, open+1
+		j := open
+		for j < len(s) && (j == open || depth > 0) {
+			if k := skip(s, j); k != j {
+				j = k
+				continue
+			}
+			switch s[j] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			case ',':
+				if depth == 1 {
+					call.args = append(call.args, s[argStart:j])
+					call.argPos = append(call.argPos, argStart)
+					argStart = j + 1
+				}
+			}
+			j++
+		}
+		end := j
+		if depth == 0 {
+			end--
+		}
+		if last := s[argStart:end]; strings.TrimSpace(last) != "" || len(call.args) != 0 {
+			call.args = append(call.args, last)
+			call.argPos = append(call.argPos, argStart)
+		}
+		call.text = s[start:j]
+		calls = append(calls, call)
+		i = j
+	}
+	return calls
+}
+
+// exprType returns the type of the expression when it is a literal, a policy token or a function call,
+// and AnyType otherwise.
+func exprType(s string, signatures SignatureLookup) ArgType {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return AnyType
+	case s == "true" || s == "false":
+		return BoolType
+	case len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && skip(s, 0) == len(s):
+		return StringType
+	case s[0] >= '0' && s[0] <= '9':
+		for i := 0; i < len(s); i++ {
+			if !(s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+				return AnyType
+			}
+		}
+		return NumberType
+	case s[0] == 'p' && strings.Contains(s, "_") && isIdent(s):
+		return StringType
+	}
+
+	if calls := findCalls(s); len(calls) == 1 && calls[0].text == s {
+		if sig, ok := signatures(calls[0].name); ok {
+			return sig.Result
+		}
+	}
+	return AnyType
+}
+
+func isIdent(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isIdentByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
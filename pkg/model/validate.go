@@ -0,0 +1,310 @@
+package model
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bhojpur/policy/pkg/effector"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity string
+
+const (
+	// ErrorSeverity marks a problem that makes the model fail to enforce.
+	ErrorSeverity Severity = "error"
+	// WarningSeverity marks a problem that may be fixed after the model is loaded, like a function
+	// that is not added yet.
+	WarningSeverity Severity = "warning"
+)
+
+// Diagnostic is a problem found by Validate.
+type Diagnostic struct {
+	Severity Severity
+	// Section is the section of the model, like "matchers".
+	Section string
+	// Key is the key of the assertion, like "m", it is empty for a missing section.
+	Key string
+	// Position is the position in the assertion, starting at 1, or 0 when the whole assertion is concerned.
+	Position int
+	Message  string
+}
+
+// String returns the diagnostic like "error: matchers::m:19: undeclared token p.obj2".
+func (d Diagnostic) String() string {
+	var where strings.Builder
+	where.WriteString(d.Section)
+	if d.Key != "" {
+		where.WriteString("::" + d.Key)
+	}
+	if d.Position != 0 {
+		fmt.Fprintf(&where, ":%d", d.Position)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Severity, where.String(), d.Message)
+}
+
+// Diagnostics are the diagnostics of a model.
+type Diagnostics []Diagnostic
+
+// HasErrors returns whether one of the diagnostics is an error.
+func (diagnostics Diagnostics) HasErrors() bool {
+	for _, d := range diagnostics {
+		if d.Severity == ErrorSeverity {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns an error listing the error diagnostics, or nil when there is none.
+func (diagnostics Diagnostics) Err() error {
+	var errs []string
+	for _, d := range diagnostics {
+		if d.Severity == ErrorSeverity {
+			errs = append(errs, d.String())
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.New("invalid model: " + strings.Join(errs, "; "))
+}
+
+var tokenReg = regexp.MustCompile(`^([rp][0-9]*)_\w+$`)
+
+// Validate checks the model for missing sections, malformed definitions, unsupported effects, tokens
+// and functions the matchers use without them being declared, and misused functions. The functions are
+// the built-in ones, see ValidateWithFunctions to check the customized functions of an enforcer.
+func (model Model) Validate() Diagnostics {
+	return model.ValidateWithFunctions(LoadFunctionMap())
+}
+
+// ValidateWithFunctions validates the model like Validate with the functions of fm.
+func (model Model) ValidateWithFunctions(fm FunctionMap) Diagnostics {
+	var diagnostics Diagnostics
+	report := func(severity Severity, sec string, key string, pos int, format string, a ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: severity,
+			Section:  sectionNameMap[sec],
+			Key:      key,
+			Position: pos,
+			Message:  fmt.Sprintf(format, a...),
+		})
+	}
+
+	for _, sec := range requiredSections {
+		if len(model[sec]) == 0 {
+			report(ErrorSeverity, sec, "", 0, "missing required section")
+		}
+	}
+
+	for _, sec := range []string{"r", "p"} {
+		for _, key := range model.keys(sec) {
+			seen := make(map[string]bool)
+			for _, token := range model[sec][key].Tokens {
+				name := strings.TrimPrefix(token, key+"_")
+				switch {
+				case name == "":
+					report(ErrorSeverity, sec, key, 0, "empty token")
+				case seen[name]:
+					report(ErrorSeverity, sec, key, 0, "duplicate token %s", name)
+				}
+				seen[name] = true
+			}
+		}
+	}
+
+	for _, key := range model.keys("g") {
+		value := model["g"][key].Value
+		fields := strings.Split(value, ",")
+		for _, field := range fields {
+			if strings.TrimSpace(field) != "_" {
+				report(ErrorSeverity, "g", key, 0, "%q should be a list of _", value)
+				break
+			}
+		}
+		if len(fields) < 2 {
+			report(ErrorSeverity, "g", key, 0, "%q should have at least 2 _", value)
+		}
+	}
+
+	for _, key := range model.keys("e") {
+		if _, err := effector.ParseEffect(model["e"][key].Value); err != nil {
+			report(ErrorSeverity, "e", key, 0, "%s", model.unescape(err.Error()))
+		}
+	}
+
+	signatures := func(name string) (Signature, bool) {
+		if sig, ok := model.GetSignature(name); ok {
+			return sig, true
+		}
+		return fm.GetSignature(name)
+	}
+	known := func(name string) bool {
+		if _, ok := signatures(name); ok {
+			return true
+		}
+		_, ok := fm.fns.Load(name)
+		return ok
+	}
+	for _, key := range model.keys("m") {
+		matcher := model["m"][key].Value
+		first := len(diagnostics)
+
+		evalArgs := make(map[int]bool)
+		var walk func(s string, offset int)
+		walk = func(s string, offset int) {
+			for _, call := range findCalls(s) {
+				if !known(call.name) {
+					report(WarningSeverity, "m", key, offset+call.pos+1, "unknown function %s", call.name)
+				}
+				if call.name == "eval" && len(call.args) == 1 {
+					arg := strings.TrimSpace(call.args[0])
+					pos := offset + call.argPos[0] + strings.Index(call.args[0], arg) + 1
+					if !tokenReg.MatchString(arg) || arg[0] != 'p' {
+						report(ErrorSeverity, "m", key, pos, "eval() should reference a policy token, got %s", arg)
+					} else if !model.hasToken(arg) {
+						report(ErrorSeverity, "m", key, pos, "eval() references undeclared policy token %s", displayToken(arg))
+					}
+					evalArgs[pos-1] = true
+				}
+				for i, arg := range call.args {
+					walk(arg, offset+call.argPos[i])
+				}
+			}
+		}
+		walk(matcher, 0)
+
+		for _, err := range checkCalls(matcher, 0, signatures) {
+			report(ErrorSeverity, "m", key, err.pos, "%s", err.msg)
+		}
+
+		for _, ref := range findTokens(matcher) {
+			if !evalArgs[ref.pos] && !model.hasToken(ref.name) {
+				report(ErrorSeverity, "m", key, ref.pos+1, "undeclared token %s", displayToken(ref.name))
+			}
+		}
+
+		// the diagnostics refer to the matcher as it is written, not as it is escaped.
+		brackets := escapedAttributeReg.FindAllStringIndex(matcher, -1)
+		matcherDiagnostics := diagnostics[first:]
+		for i := range matcherDiagnostics {
+			matcherDiagnostics[i].Position = definitionPosition(matcherDiagnostics[i].Position, brackets)
+			matcherDiagnostics[i].Message = model.unescape(matcherDiagnostics[i].Message)
+		}
+		sort.SliceStable(matcherDiagnostics, func(i, j int) bool {
+			return matcherDiagnostics[i].Position < matcherDiagnostics[j].Position
+		})
+	}
+
+	return diagnostics
+}
+
+// definitionPosition returns the position in the definition of the position in the escaped assertion,
+// given the positions of its bracketed attributes.
+func definitionPosition(pos int, brackets [][]int) int {
+	if pos == 0 {
+		return 0
+	}
+	shift := 0
+	for _, bracket := range brackets {
+		if bracket[0] < pos-1 {
+			shift++
+		}
+		if bracket[1]-1 < pos-1 {
+			shift++
+		}
+	}
+	return pos - shift
+}
+
+// keys returns the keys of the section sorted by name.
+func (model Model) keys(sec string) []string {
+	keys := make([]string, 0, len(model[sec]))
+	for key := range model[sec] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hasToken returns whether the request or policy token, like "p2_obj", is declared.
+func (model Model) hasToken(token string) bool {
+	key := tokenReg.FindStringSubmatch(token)[1]
+	ast, ok := model[key[:1]][key]
+	if !ok {
+		return false
+	}
+	for _, t := range ast.Tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// displayToken returns the token as written in the model, like "p.obj".
+func displayToken(token string) string {
+	return strings.Replace(token, "_", ".", 1)
+}
+
+type tokenRef struct {
+	name string
+	pos  int
+}
+
+// findTokens returns the request and policy tokens of the matcher, leaving out the string literals,
+// the attribute names and the function names.
+func findTokens(s string) []tokenRef {
+	var refs []tokenRef
+	for i := 0; i < len(s); {
+		if s[i] == '\'' || s[i] == '"' {
+			i = skip(s, i)
+			continue
+		}
+		if !isIdentByte(s[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(s) && isIdentByte(s[i]) {
+			i++
+		}
+		name := s[start:i]
+		next := i
+		for next < len(s) && s[next] == ' ' {
+			next++
+		}
+		if start > 0 && s[start-1] == '.' || next < len(s) && s[next] == '(' {
+			continue
+		}
+		if tokenReg.MatchString(name) {
+			refs = append(refs, tokenRef{name: name, pos: start})
+		}
+	}
+	return refs
+}
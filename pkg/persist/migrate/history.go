@@ -0,0 +1,107 @@
+package migrate
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+)
+
+// History records the applied migrations so that they run once.
+type History interface {
+	// Applied returns the ids of the applied migrations.
+	Applied() ([]string, error)
+	// Record records the migrations as applied.
+	Record(ids ...string) error
+}
+
+// MemoryHistory is a History kept in memory.
+type MemoryHistory struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+// NewMemoryHistory is the constructor for MemoryHistory.
+func NewMemoryHistory(ids ...string) *MemoryHistory {
+	return &MemoryHistory{ids: ids}
+}
+
+// Applied returns the ids of the applied migrations.
+func (h *MemoryHistory) Applied() ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.ids...), nil
+}
+
+// Record records the migrations as applied.
+func (h *MemoryHistory) Record(ids ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ids = append(h.ids, ids...)
+	return nil
+}
+
+// FileHistory is a History stored in a text file, with the id of an applied migration per line.
+type FileHistory struct {
+	filePath string
+}
+
+// NewFileHistory is the constructor for FileHistory, the file is created by the first record.
+func NewFileHistory(filePath string) *FileHistory {
+	return &FileHistory{filePath: filePath}
+}
+
+// Applied returns the ids of the applied migrations.
+func (h *FileHistory) Applied() ([]string, error) {
+	f, err := os.Open(h.filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, scanner.Err()
+}
+
+// Record records the migrations as applied.
+func (h *FileHistory) Record(ids ...string) error {
+	f, err := os.OpenFile(h.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(strings.Join(ids, "\n") + "\n")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
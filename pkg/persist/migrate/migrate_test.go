@@ -0,0 +1,228 @@
+package migrate
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bhojpur/policy/pkg/engine"
+	"github.com/bhojpur/policy/pkg/model"
+	fileadapter "github.com/bhojpur/policy/pkg/persist/file-adapter"
+	stringadapter "github.com/bhojpur/policy/pkg/persist/string-adapter"
+	"github.com/bhojpur/policy/pkg/util"
+)
+
+func TestMigrationsFromYAML(t *testing.T) {
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "policy.csv")
+	data, err := ioutil.ReadFile("../../../examples/rbac_policy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(policy, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := LoadMigrations("../../../examples/rbac_with_domains_migrations.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := model.NewModelFromFile("../../../examples/rbac_with_domains_model.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := NewFileHistory(filepath.Join(dir, "migrations.txt"))
+	runner := NewRunner(m, fileadapter.NewAdapter(policy), history)
+	if err := runner.Add(migrations...); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file adapter can't update rules, the policy is saved instead.
+	ids, err := runner.Run()
+	if err != nil || strings.Join(ids, ",") != "add-default-domain" {
+		t.Fatalf("Run() = %v, %v", ids, err)
+	}
+	e, err := engine.NewEnforcer("../../../examples/rbac_with_domains_model.conf", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rvals := range [][]interface{}{{"alice", "domain1", "data1", "read"}, {"alice", "domain1", "data2", "write"}} {
+		if ok, err := e.Enforce(rvals...); !ok || err != nil {
+			t.Errorf("%v should be allowed, got %v, %v", rvals, ok, err)
+		}
+	}
+
+	// the applied migrations run once.
+	if ids, err := runner.Run(); len(ids) != 0 || err != nil {
+		t.Errorf("the migrations should run once, got %v, %v", ids, err)
+	}
+	if ids, _ := history.Applied(); strings.Join(ids, ",") != "add-default-domain" {
+		t.Errorf("unexpected history %v", ids)
+	}
+
+	for _, text := range []string{
+		"migrations:\n  - id: a\n    steps:\n      - {op: rename, ptype: p}\n",
+		"migrations:\n  - id: a\n    steps:\n      - {op: split, ptype: p, index: 1}\n",
+		"migrations:\n  - id: a\n    steps:\n      - {op: rewrite, ptype: p}\n",
+		"migrations:\n  - steps:\n      - {op: remove, ptype: p}\n",
+		"migrations:\n  - id: a\n    steps:\n      - {op: remove, ptype: r}\n",
+		"migration:\n  - id: a\n",
+	} {
+		if _, err := ParseMigrations([]byte(text)); err == nil {
+			t.Errorf("%q: the migrations should be invalid", text)
+		}
+	}
+}
+
+const splitModel = `
+[request_definition]
+r = sub, tenant, obj, act
+
+[policy_definition]
+p = sub, tenant, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.tenant == p.tenant && r.obj == p.obj && r.act == p.act
+`
+
+func TestRunner(t *testing.T) {
+	m, err := model.NewModelFromString(splitModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := "p, alice, acme:data1, read\np, bob, data2, write\ng, alice, admin"
+	adapter := stringadapter.NewAdapter(line)
+	history := NewMemoryHistory("old-migration")
+	runner := NewRunner(m, adapter, history)
+
+	err = runner.Add(
+		Migration{ID: "old-migration", Steps: []Step{RemoveColumn("p", 0)}},
+		Migration{ID: "split-tenant", Steps: []Step{SplitColumn("p", 1, ":", 2, "default")}},
+		Migration{ID: "upper-roles", Steps: []Step{RewriteRules("g", func(rule []string) ([]string, error) {
+			rule[1] = strings.ToUpper(rule[1])
+			return rule, nil
+		})}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Add(Migration{ID: "split-tenant", Steps: []Step{RemoveColumn("p", 0)}}); err == nil {
+		t.Error("duplicate migrations should be rejected")
+	}
+
+	// a failing step writes nothing.
+	failing := NewRunner(m, adapter, NewMemoryHistory())
+	_ = failing.Add(
+		Migration{ID: "insert", Steps: []Step{InsertColumn("p", 0, "x")}},
+		Migration{ID: "remove", Steps: []Step{RemoveColumn("p", 5)}},
+	)
+	if _, err := failing.Run(); err == nil || !strings.Contains(err.Error(), "migration remove: step 1: rule") {
+		t.Errorf("the step should fail, got %v", err)
+	}
+	if adapter.Line != line {
+		t.Errorf("the policy should not change, got %q", adapter.Line)
+	}
+
+	ids, err := runner.Run()
+	if err != nil || strings.Join(ids, ",") != "split-tenant,upper-roles" {
+		t.Fatalf("Run() = %v, %v", ids, err)
+	}
+	migrated := m.Copy()
+	migrated.ClearPolicy()
+	if err := adapter.LoadPolicy(migrated); err != nil {
+		t.Fatal(err)
+	}
+	if got := migrated.GetPolicy("p", "p"); !util.Array2DEquals(got, [][]string{{"alice", "acme", "data1", "read"}, {"bob", "default", "data2", "write"}}) {
+		t.Errorf("unexpected p rules %v", got)
+	}
+	if got := migrated.GetPolicy("g", "g"); !util.Array2DEquals(got, [][]string{{"alice", "ADMIN"}}) {
+		t.Errorf("unexpected g rules %v", got)
+	}
+}
+
+type updatableAdapter struct {
+	*stringadapter.Adapter
+	updates []string
+	err     error
+}
+
+func (a *updatableAdapter) UpdatePolicy(sec string, ptype string, oldRule, newRule []string) error {
+	return a.UpdatePolicies(sec, ptype, [][]string{oldRule}, [][]string{newRule})
+}
+
+func (a *updatableAdapter) UpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
+	a.updates = append(a.updates, ptype+": "+oldRules[0][0]+" -> "+newRules[0][0])
+	return a.err
+}
+
+func (a *updatableAdapter) UpdateFilteredPolicies(sec string, ptype string, newPolicies [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	return nil, errors.New("not implemented")
+}
+
+type failingHistory struct {
+	MemoryHistory
+}
+
+func (h *failingHistory) Record(ids ...string) error {
+	return errors.New("read-only history")
+}
+
+func TestRunnerWithUpdatableAdapter(t *testing.T) {
+	m, err := model.NewModelFromString(splitModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapter := &updatableAdapter{Adapter: stringadapter.NewAdapter("p, alice, data1, read\np2, bob, data2, write\ng, alice, admin")}
+	m.AddDef("p", "p2", "sub, obj, act")
+	migration := Migration{ID: "insert", Steps: []Step{InsertColumn("g", 0, "x"), InsertColumn("p", 0, "y")}}
+
+	// the rules are restored when the migration can't be recorded.
+	runner := NewRunner(m, adapter, &failingHistory{})
+	_ = runner.Add(migration)
+	if _, err := runner.Run(); err == nil || !strings.Contains(err.Error(), "the policy is restored") {
+		t.Errorf("the record should fail, got %v", err)
+	}
+	want := "g: alice -> x,p: alice -> y,g: x -> alice,p: y -> alice"
+	if strings.Join(adapter.updates, ",") != want {
+		t.Errorf("got updates %v, want %s", adapter.updates, want)
+	}
+
+	// the policy is saved when the update fails.
+	adapter.updates, adapter.err = nil, errors.New("not implemented")
+	runner = NewRunner(m, adapter, NewMemoryHistory())
+	_ = runner.Add(migration)
+	if _, err := runner.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(adapter.Line, "g, x, alice, admin") || !strings.Contains(adapter.Line, "p2, bob, data2, write") {
+		t.Errorf("unexpected policy %q", adapter.Line)
+	}
+}
@@ -0,0 +1,187 @@
+package migrate
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Op is the operation of a migration step.
+type Op string
+
+const (
+	// Insert inserts a column with a default value in every rule.
+	Insert Op = "insert"
+	// Remove removes a column of every rule.
+	Remove Op = "remove"
+	// Split splits a column of every rule into several columns.
+	Split Op = "split"
+	// Rewrite rewrites every rule with a function, it can only be declared in code.
+	Rewrite Op = "rewrite"
+)
+
+// Step is a transformation of the stored rules of a policy type, like "p" or "g2". The indexes of the
+// columns start at 0 with the first value after the policy type.
+type Step struct {
+	Op    Op     `yaml:"op"`
+	PType string `yaml:"ptype"`
+	Index int    `yaml:"index"`
+	// Value is the value of the inserted column, or the value of the leading columns of a split when the
+	// column has fewer parts than Columns.
+	Value     string `yaml:"value,omitempty"`
+	Separator string `yaml:"separator,omitempty"`
+	Columns   int    `yaml:"columns,omitempty"`
+
+	rewrite func(rule []string) ([]string, error)
+}
+
+// InsertColumn returns a step inserting the value at the index of every rule of the policy type, e.g.
+// InsertColumn("g", 2, "domain1") adds a default domain to the role assignments.
+func InsertColumn(ptype string, index int, value string) Step {
+	return Step{Op: Insert, PType: ptype, Index: index, Value: value}
+}
+
+// RemoveColumn returns a step removing the column at the index of every rule of the policy type.
+func RemoveColumn(ptype string, index int) Step {
+	return Step{Op: Remove, PType: ptype, Index: index}
+}
+
+// SplitColumn returns a step splitting the column at the index of every rule of the policy type into the
+// given number of columns, e.g. SplitColumn("p", 1, ":", 2, "default") splits an object "tenant1:data1"
+// into "tenant1", "data1" and an object "data2" into "default", "data2".
+func SplitColumn(ptype string, index int, separator string, columns int, value string) Step {
+	return Step{Op: Split, PType: ptype, Index: index, Separator: separator, Columns: columns, Value: value}
+}
+
+// RewriteRules returns a step replacing every rule of the policy type by the result of the function.
+func RewriteRules(ptype string, fn func(rule []string) ([]string, error)) Step {
+	return Step{Op: Rewrite, PType: ptype, rewrite: fn}
+}
+
+// check returns an error when the step is incomplete.
+func (s Step) check() error {
+	if s.PType == "" || s.PType[0] != 'p' && s.PType[0] != 'g' {
+		return fmt.Errorf("invalid policy type %q", s.PType)
+	}
+	if s.Index < 0 {
+		return fmt.Errorf("invalid index %d", s.Index)
+	}
+
+	switch s.Op {
+	case Insert, Remove:
+	case Split:
+		if s.Separator == "" || s.Columns < 2 {
+			return errors.New("split needs a separator and at least 2 columns")
+		}
+	case Rewrite:
+		if s.rewrite == nil {
+			return errors.New("rewrite needs a function")
+		}
+	default:
+		return fmt.Errorf("unknown operation %q", s.Op)
+	}
+	return nil
+}
+
+// apply returns the rule transformed by the step, the rule itself is not modified.
+func (s Step) apply(rule []string) ([]string, error) {
+	if s.Op == Rewrite {
+		return s.rewrite(append([]string(nil), rule...))
+	}
+
+	if s.Index > len(rule) || s.Index == len(rule) && s.Op != Insert {
+		return nil, fmt.Errorf("rule %v has no column %d", rule, s.Index)
+	}
+	res := make([]string, 0, len(rule)+s.Columns)
+	res = append(res, rule[:s.Index]...)
+	switch s.Op {
+	case Insert:
+		res = append(res, s.Value)
+		res = append(res, rule[s.Index:]...)
+		return res, nil
+	case Split:
+		parts := strings.SplitN(rule[s.Index], s.Separator, s.Columns)
+		for i := len(parts); i < s.Columns; i++ {
+			res = append(res, s.Value)
+		}
+		res = append(res, parts...)
+	}
+	return append(res, rule[s.Index+1:]...), nil
+}
+
+// Migration is a named list of steps, it is applied once, see Runner.
+type Migration struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description,omitempty"`
+	Steps       []Step `yaml:"steps"`
+}
+
+// check returns an error when the migration is incomplete.
+func (m Migration) check() error {
+	if m.ID == "" {
+		return errors.New("migration without id")
+	}
+	if len(m.Steps) == 0 {
+		return fmt.Errorf("migration %s: no steps", m.ID)
+	}
+	for i, step := range m.Steps {
+		if err := step.check(); err != nil {
+			return fmt.Errorf("migration %s: step %d: %w", m.ID, i+1, err)
+		}
+	}
+	return nil
+}
+
+// LoadMigrations loads the migrations of a YAML file like:
+//
+//	migrations:
+//	  - id: add-domains
+//	    steps:
+//	      - {op: insert, ptype: g, index: 2, value: domain1}
+//	      - {op: split, ptype: p, index: 1, separator: ":", columns: 2, value: domain1}
+func LoadMigrations(path string) ([]Migration, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMigrations(data)
+}
+
+// ParseMigrations parses the migrations of a YAML document, see LoadMigrations.
+func ParseMigrations(data []byte) ([]Migration, error) {
+	var doc struct {
+		Migrations []Migration `yaml:"migrations"`
+	}
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid migrations: %w", err)
+	}
+	for _, m := range doc.Migrations {
+		if err := m.check(); err != nil {
+			return nil, err
+		}
+	}
+	return doc.Migrations, nil
+}
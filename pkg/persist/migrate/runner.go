@@ -0,0 +1,215 @@
+package migrate
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bhojpur/policy/pkg/model"
+	"github.com/bhojpur/policy/pkg/persist"
+	"github.com/bhojpur/policy/pkg/util"
+)
+
+// Runner applies the migrations to the policy rules stored by an adapter. The migrations are applied in
+// the order they are added, and recorded in the history so that they run once.
+type Runner struct {
+	model      model.Model
+	adapter    persist.Adapter
+	history    History
+	migrations []Migration
+}
+
+// NewRunner is the constructor for Runner. The model declares the policy types of the stored rules, the
+// number of their tokens doesn't matter.
+func NewRunner(m model.Model, adapter persist.Adapter, history History) *Runner {
+	return &Runner{model: m, adapter: adapter, history: history}
+}
+
+// Add adds migrations after the ones already added.
+func (r *Runner) Add(migrations ...Migration) error {
+	for _, m := range migrations {
+		if err := m.check(); err != nil {
+			return err
+		}
+		for _, added := range r.migrations {
+			if added.ID == m.ID {
+				return fmt.Errorf("duplicate migration %s", m.ID)
+			}
+		}
+		r.migrations = append(r.migrations, m)
+	}
+	return nil
+}
+
+// Pending returns the migrations not applied yet.
+func (r *Runner) Pending() ([]Migration, error) {
+	ids, err := r.history.Applied()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the migration history: %w", err)
+	}
+	applied := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		applied[id] = true
+	}
+
+	var pending []Migration
+	for _, m := range r.migrations {
+		if !applied[m.ID] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Run applies the pending migrations and returns their ids. The rules are transformed in memory first, so
+// that nothing is written when a step fails. They are then updated with UpdatePolicies when the adapter is
+// a persist.UpdatableAdapter, or saved with SavePolicy otherwise or when the update fails. The previous
+// rules are restored when the migrations can't be recorded.
+func (r *Runner) Run() ([]string, error) {
+	pending, err := r.Pending()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	current := r.model.Copy()
+	current.ClearPolicy()
+	if err := r.adapter.LoadPolicy(current); err != nil {
+		return nil, fmt.Errorf("cannot load the policy: %w", err)
+	}
+
+	rules := make(map[string][][]string)
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range current[sec] {
+			rules[ptype] = ast.Policy
+		}
+	}
+	ids := make([]string, len(pending))
+	for i, m := range pending {
+		ids[i] = m.ID
+		for j, step := range m.Steps {
+			if err := migrate(rules, step); err != nil {
+				return nil, fmt.Errorf("migration %s: step %d: %w", m.ID, j+1, err)
+			}
+		}
+	}
+
+	undo, err := r.write(current, rules)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.history.Record(ids...); err != nil {
+		if undoErr := undo(); undoErr != nil {
+			return nil, fmt.Errorf("cannot record the migrations: %v, and cannot restore the policy: %w", err, undoErr)
+		}
+		return nil, fmt.Errorf("cannot record the migrations, the policy is restored: %w", err)
+	}
+	return ids, nil
+}
+
+// migrate applies the step to the rules of its policy type.
+func migrate(rules map[string][][]string, step Step) error {
+	policy, ok := rules[step.PType]
+	if !ok {
+		return fmt.Errorf("unknown policy type %s", step.PType)
+	}
+
+	migrated := make([][]string, len(policy))
+	for i, rule := range policy {
+		res, err := step.apply(rule)
+		if err != nil {
+			return err
+		}
+		if len(res) == 0 {
+			return fmt.Errorf("rule %v is rewritten as an empty rule", rule)
+		}
+		migrated[i] = res
+	}
+	rules[step.PType] = migrated
+	return nil
+}
+
+// write writes the migrated rules and returns the function restoring the current ones.
+func (r *Runner) write(current model.Model, rules map[string][][]string) (func() error, error) {
+	var ptypes []string
+	for ptype, policy := range rules {
+		if !util.Array2DEquals(current[ptype[:1]][ptype].Policy, policy) {
+			ptypes = append(ptypes, ptype)
+		}
+	}
+	sort.Strings(ptypes)
+
+	if adapter, ok := r.adapter.(persist.UpdatableAdapter); ok {
+		undo, err := update(adapter, current, rules, ptypes)
+		if err == nil {
+			return undo, nil
+		}
+		if undoErr := undo(); undoErr != nil {
+			return nil, fmt.Errorf("cannot update the policy: %v, and cannot restore it: %w", err, undoErr)
+		}
+	}
+
+	migrated := current.Copy()
+	migrated.ClearPolicy()
+	for ptype, policy := range rules {
+		for _, rule := range policy {
+			persist.LoadPolicyArray(append([]string{ptype}, rule...), migrated)
+		}
+	}
+	if err := r.adapter.SavePolicy(migrated); err != nil {
+		if undoErr := r.adapter.SavePolicy(current); undoErr != nil {
+			return nil, fmt.Errorf("cannot save the policy: %v, and cannot restore it: %w", err, undoErr)
+		}
+		return nil, fmt.Errorf("cannot save the policy: %w", err)
+	}
+	return func() error {
+		return r.adapter.SavePolicy(current)
+	}, nil
+}
+
+// update updates the rules of the policy types, the returned function restores the updated ones.
+func update(adapter persist.UpdatableAdapter, current model.Model, rules map[string][][]string, ptypes []string) (func() error, error) {
+	var updated []string
+	undo := func() error {
+		var errs []string
+		for _, ptype := range updated {
+			sec := ptype[:1]
+			if err := adapter.UpdatePolicies(sec, ptype, rules[ptype], current[sec][ptype].Policy); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", ptype, err))
+			}
+		}
+		if len(errs) != 0 {
+			return errors.New(strings.Join(errs, "; "))
+		}
+		return nil
+	}
+
+	for _, ptype := range ptypes {
+		sec := ptype[:1]
+		if err := adapter.UpdatePolicies(sec, ptype, current[sec][ptype].Policy, rules[ptype]); err != nil {
+			return undo, fmt.Errorf("%s: %w", ptype, err)
+		}
+		updated = append(updated, ptype)
+	}
+	return undo, nil
+}
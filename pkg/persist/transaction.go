@@ -0,0 +1,53 @@
+package persist
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// PolicyChange is the net change of the rules of a policy type made by an enforcer transaction.
+type PolicyChange struct {
+	Sec     string
+	PType   string
+	Removed [][]string
+	Added   [][]string
+}
+
+// TransactionalAdapter is the interface for Bhojpur Policy adapters storing the changes of an
+// enforcer transaction at once.
+type TransactionalAdapter interface {
+	Adapter
+	// ApplyPolicyChanges removes and adds the rules of the changes atomically, in that order.
+	// This is part of the Auto-Save feature.
+	ApplyPolicyChanges(changes []PolicyChange) error
+}
+
+// WatcherTransactional is the strengthen for Bhojpur Policy watchers notified of transactions.
+type WatcherTransactional interface {
+	Watcher
+	// UpdateForTransaction calls the update callback of other instances to synchronize their policy.
+	// It is called after Enforcer.Transaction()
+	UpdateForTransaction(changes []PolicyChange) error
+}
+
+// DispatcherTransactional is the strengthen for Bhojpur Policy dispatchers applying transactions.
+type DispatcherTransactional interface {
+	Dispatcher
+	// ApplyPolicyChanges applies the changes of a transaction to all instances.
+	ApplyPolicyChanges(changes []PolicyChange) error
+}
@@ -90,6 +90,38 @@ func (rm *RoleManager) Clear() error {
 	return nil
 }
 
+// Copy returns a deep copy of the role manager with its links and matching functions,
+// later changes to either role manager are not seen by the other.
+func (rm *RoleManager) Copy() *RoleManager {
+	newRM := NewRoleManager(rm.maxHierarchyLevel)
+	newRM.logger = rm.logger
+	newRM.hasPattern = rm.hasPattern
+	newRM.matchingFunc = rm.matchingFunc
+	newRM.matchingFuncCache = &sync.Map{}
+	newRM.hasDomainPattern = rm.hasDomainPattern
+	newRM.domainMatchingFunc = rm.domainMatchingFunc
+	newRM.domainMatchingFuncCache = &sync.Map{}
+
+	// roles may be linked across domains by domain patterns, so they are mapped by identity.
+	roleMap := make(map[*Role]*Role)
+	rm.allDomains.Range(func(key, value interface{}) bool {
+		newRoles := &Roles{}
+		value.(*Roles).Range(func(name, role interface{}) bool {
+			roleMap[role.(*Role)] = newRoles.createRole(name.(string))
+			return true
+		})
+		newRM.allDomains.Store(key, newRoles)
+		return true
+	})
+	for role, copied := range roleMap {
+		for _, r := range role.roles {
+			copied.roles = append(copied.roles, roleMap[r])
+		}
+	}
+
+	return newRM
+}
+
 // AddLink adds the inheritance link between role: name1 and role: name2.
 // aka role: name1 inherits role: name2.
 func (rm *RoleManager) AddLink(name1 string, name2 string, domains ...string) error {
@@ -237,6 +237,30 @@ func TestClear(t *testing.T) {
 	testRole(t, rm, "u4", "g3", false)
 }
 
+func TestCopy(t *testing.T) {
+	rm := NewRoleManager(3)
+	_ = rm.AddLink("u1", "g1")
+	_ = rm.AddLink("g1", "g2")
+	_ = rm.AddLink("u2", "g3", "domain1")
+
+	rm2 := rm.Copy()
+	_ = rm.DeleteLink("g1", "g2")
+	_ = rm2.AddLink("u3", "g1")
+
+	testRole(t, rm, "u1", "g2", false)
+	testRole(t, rm, "u3", "g1", false)
+	testRole(t, rm2, "u1", "g1", true)
+	testRole(t, rm2, "u1", "g2", true)
+	testRole(t, rm2, "u3", "g2", true)
+	testDomainRole(t, rm2, "u2", "g3", "domain1", true)
+	testDomainRole(t, rm2, "u2", "g3", "domain2", false)
+
+	rm = NewRoleManager(10)
+	rm.AddMatchingFunc("keyMatch2", util.KeyMatch2)
+	_ = rm.AddLink("/book/:id", "book_group")
+	testRole(t, rm.Copy(), "/book/1", "book_group", true)
+}
+
 func TestDomainPatternRole(t *testing.T) {
 	rm := NewRoleManager(10)
 	rm.AddDomainMatchingFunc("keyMatch2", util.KeyMatch2)
@@ -21,18 +21,26 @@ package util
 // THE SOFTWARE.
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Knetic/govaluate"
 	"github.com/bhojpur/policy/pkg/rbac"
 )
 
 var (
+	keyMatch2Re *regexp.Regexp = regexp.MustCompile(`:[^/]+`)
+	keyMatch3Re *regexp.Regexp = regexp.MustCompile(`\{[^/]+\}`)
 	keyMatch4Re *regexp.Regexp = regexp.MustCompile(`{([^/]+)}`)
 )
 
@@ -106,15 +114,25 @@ func KeyGetFunc(args ...interface{}) (interface{}, error) {
 	return KeyGet(name1, name2), nil
 }
 
+// tokenPattern is a compiled key pattern with the names of its tokens.
+type tokenPattern struct {
+	re     *regexp.Regexp
+	tokens []string
+}
+
 // KeyMatch2 determines whether key1 matches the pattern of key2 (similar to RESTful path), key2 can contain a *.
 // For example, "/foo/bar" matches "/foo/*", "/resource1" matches "/:resource"
 func KeyMatch2(key1 string, key2 string) bool {
-	key2 = strings.Replace(key2, "/*", "/.*", -1)
-
-	re := regexp.MustCompile(`:[^/]+`)
-	key2 = re.ReplaceAllString(key2, "$1[^/]+$2")
+	re, err := compiledPatterns.get("keyMatch2", key2, func(key2 string) (interface{}, error) {
+		key2 = strings.Replace(key2, "/*", "/.*", -1)
+		key2 = keyMatch2Re.ReplaceAllString(key2, "$1[^/]+$2")
+		return regexp.Compile("^" + key2 + "$")
+	})
+	if err != nil {
+		panic(err)
+	}
 
-	return RegexMatch(key1, "^"+key2+"$")
+	return re.(*regexp.Regexp).MatchString(key1)
 }
 
 // KeyMatch2Func is the wrapper for KeyMatch2.
@@ -133,18 +151,23 @@ func KeyMatch2Func(args ...interface{}) (interface{}, error) {
 // For example, "/resource1" matches "/:resource"
 // if the pathVar == "resource", then "resource1" will be returned
 func KeyGet2(key1, key2 string, pathVar string) string {
-	key2 = strings.Replace(key2, "/*", "/.*", -1)
-
-	re := regexp.MustCompile(`:[^/]+`)
-	keys := re.FindAllString(key2, -1)
-	key2 = re.ReplaceAllString(key2, "$1([^/]+)$2")
-	key2 = "^" + key2 + "$"
-	re2 := regexp.MustCompile(key2)
-	values := re2.FindAllStringSubmatch(key1, -1)
+	compiled, err := compiledPatterns.get("keyGet2", key2, func(key2 string) (interface{}, error) {
+		key2 = strings.Replace(key2, "/*", "/.*", -1)
+		keys := keyMatch2Re.FindAllString(key2, -1)
+		key2 = keyMatch2Re.ReplaceAllString(key2, "$1([^/]+)$2")
+		re, err := regexp.Compile("^" + key2 + "$")
+		return tokenPattern{re, keys}, err
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	pattern := compiled.(tokenPattern)
+	values := pattern.re.FindAllStringSubmatch(key1, -1)
 	if len(values) == 0 {
 		return ""
 	}
-	for i, key := range keys {
+	for i, key := range pattern.tokens {
 		if pathVar == key[1:] {
 			return values[0][i+1]
 		}
@@ -168,12 +191,16 @@ func KeyGet2Func(args ...interface{}) (interface{}, error) {
 // KeyMatch3 determines whether key1 matches the pattern of key2 (similar to RESTful path), key2 can contain a *.
 // For example, "/foo/bar" matches "/foo/*", "/resource1" matches "/{resource}"
 func KeyMatch3(key1 string, key2 string) bool {
-	key2 = strings.Replace(key2, "/*", "/.*", -1)
-
-	re := regexp.MustCompile(`\{[^/]+\}`)
-	key2 = re.ReplaceAllString(key2, "$1[^/]+$2")
+	re, err := compiledPatterns.get("keyMatch3", key2, func(key2 string) (interface{}, error) {
+		key2 = strings.Replace(key2, "/*", "/.*", -1)
+		key2 = keyMatch3Re.ReplaceAllString(key2, "$1[^/]+$2")
+		return regexp.Compile("^" + key2 + "$")
+	})
+	if err != nil {
+		panic(err)
+	}
 
-	return RegexMatch(key1, "^"+key2+"$")
+	return re.(*regexp.Regexp).MatchString(key1)
 }
 
 // KeyMatch3Func is the wrapper for KeyMatch3.
@@ -194,18 +221,25 @@ func KeyMatch3Func(args ...interface{}) (interface{}, error) {
 // "/parent/123/child/456" does not match "/parent/{id}/child/{id}"
 // But KeyMatch3 will match both.
 func KeyMatch4(key1 string, key2 string) bool {
-	key2 = strings.Replace(key2, "/*", "/.*", -1)
+	compiled, err := compiledPatterns.get("keyMatch4", key2, func(key2 string) (interface{}, error) {
+		key2 = strings.Replace(key2, "/*", "/.*", -1)
 
-	tokens := []string{}
+		tokens := []string{}
+		key2 = keyMatch4Re.ReplaceAllStringFunc(key2, func(s string) string {
+			tokens = append(tokens, s[1:len(s)-1])
+			return "([^/]+)"
+		})
 
-	re := keyMatch4Re
-	key2 = re.ReplaceAllStringFunc(key2, func(s string) string {
-		tokens = append(tokens, s[1:len(s)-1])
-		return "([^/]+)"
+		re, err := regexp.Compile("^" + key2 + "$")
+		return tokenPattern{re, tokens}, err
 	})
+	if err != nil {
+		panic(err)
+	}
 
-	re = regexp.MustCompile("^" + key2 + "$")
-	matches := re.FindStringSubmatch(key1)
+	pattern := compiled.(tokenPattern)
+	tokens := pattern.tokens
+	matches := pattern.re.FindStringSubmatch(key1)
 	if matches == nil {
 		return false
 	}
@@ -266,11 +300,11 @@ func KeyMatch5Func(args ...interface{}) (interface{}, error) {
 
 // RegexMatch determines whether key1 matches the pattern of key2 in regular expression.
 func RegexMatch(key1 string, key2 string) bool {
-	res, err := regexp.MatchString(key2, key1)
+	re, err := compileRegexp("regexMatch", key2)
 	if err != nil {
 		panic(err)
 	}
-	return res
+	return re.MatchString(key1)
 }
 
 // RegexMatchFunc is the wrapper for RegexMatch.
@@ -318,6 +352,589 @@ func IPMatchFunc(args ...interface{}) (interface{}, error) {
 	return bool(IPMatch(ip1, ip2)), nil
 }
 
+// network is a pre-parsed network of the network functions: a CIDR, an IP address or an inclusive range of IP addresses.
+type network struct {
+	cidr        *net.IPNet
+	first, last net.IP
+}
+
+func (n network) contains(ip net.IP) bool {
+	if n.cidr != nil {
+		return n.cidr.Contains(ip)
+	}
+	if (ip.To4() == nil) != (n.first.To4() == nil) {
+		return false
+	}
+	ip = ip.To16()
+	return bytes.Compare(ip, n.first) >= 0 && bytes.Compare(ip, n.last) <= 0
+}
+
+// splitList splits a list separated by ";" or ",".
+func splitList(s string) []string {
+	items := strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == ',' })
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+// parseNetworks parses a list of networks separated by ";" or ",", each a CIDR, an IP address or a range like "10.0.0.5-10.0.0.50".
+func parseNetworks(s string) (interface{}, error) {
+	var networks []network
+	for _, item := range splitList(s) {
+		if _, cidr, err := net.ParseCIDR(item); err == nil {
+			networks = append(networks, network{cidr: cidr})
+			continue
+		}
+
+		first, last := item, item
+		if i := strings.Index(item, "-"); i != -1 {
+			first, last = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		}
+		ip1, ip2 := net.ParseIP(first), net.ParseIP(last)
+		if ip1 == nil || ip2 == nil || (ip1.To4() == nil) != (ip2.To4() == nil) {
+			return nil, fmt.Errorf("invalid network %q, expected a CIDR, an IP address or a range of IP addresses", item)
+		}
+		if bytes.Compare(ip1.To16(), ip2.To16()) > 0 {
+			return nil, fmt.Errorf("invalid range %q, the first IP address is after the last one", item)
+		}
+		networks = append(networks, network{first: ip1.To16(), last: ip2.To16()})
+	}
+	return networks, nil
+}
+
+// IPInAny determines whether IP address ip is in any of the networks, a list separated by ";" or "," of CIDRs,
+// IP addresses and inclusive ranges like "10.0.0.5-10.0.0.50", for IPv4 and IPv6.
+// For example, "192.168.2.123" is in "10.0.0.0/8;192.168.0.0/16"
+func IPInAny(ip string, networks string) (bool, error) {
+	objIP := net.ParseIP(ip)
+	if objIP == nil {
+		return false, fmt.Errorf("invalid IP address %q", ip)
+	}
+
+	nets, err := compiledPatterns.get("ipInAny", networks, parseNetworks)
+	if err != nil {
+		return false, err
+	}
+	for _, n := range nets.([]network) {
+		if n.contains(objIP) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// IPInAnyFunc is the wrapper for IPInAny.
+func IPInAnyFunc(args ...interface{}) (interface{}, error) {
+	if err := validateVariadicArgs(2, args...); err != nil {
+		return false, fmt.Errorf("%s: %s", "ipInAny", err)
+	}
+
+	res, err := IPInAny(args[0].(string), args[1].(string))
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", "ipInAny", err)
+	}
+	return res, nil
+}
+
+// IPRange determines whether IP address ip is in the inclusive range of IP addresses ipRange, like "10.0.0.5-10.0.0.50".
+func IPRange(ip string, ipRange string) (bool, error) {
+	if !strings.Contains(ipRange, "-") {
+		return false, fmt.Errorf("invalid range %q, expected a range like 10.0.0.5-10.0.0.50", ipRange)
+	}
+	return IPInAny(ip, ipRange)
+}
+
+// IPRangeFunc is the wrapper for IPRange.
+func IPRangeFunc(args ...interface{}) (interface{}, error) {
+	if err := validateVariadicArgs(2, args...); err != nil {
+		return false, fmt.Errorf("%s: %s", "ipRange", err)
+	}
+
+	res, err := IPRange(args[0].(string), args[1].(string))
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", "ipRange", err)
+	}
+	return res, nil
+}
+
+type portRange struct {
+	first, last int
+}
+
+// parsePort parses a port number.
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// parsePortRanges parses a list of ports and inclusive port ranges separated by ";" or ",", like "80;443;8000-8080".
+func parsePortRanges(s string) (interface{}, error) {
+	var ranges []portRange
+	for _, item := range splitList(s) {
+		first, last := item, item
+		if i := strings.Index(item, "-"); i != -1 {
+			first, last = item[:i], item[i+1:]
+		}
+		from, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parsePort(last)
+		if err != nil {
+			return nil, err
+		}
+		if from > to {
+			return nil, fmt.Errorf("invalid range %q, the first port is after the last one", item)
+		}
+		ranges = append(ranges, portRange{from, to})
+	}
+	return ranges, nil
+}
+
+// PortInRange determines whether port is in ranges, a list of ports and inclusive port ranges separated by ";" or ",".
+// For example, "8080" is in "80;443;8000-8080"
+func PortInRange(port string, ranges string) (bool, error) {
+	p, err := parsePort(port)
+	if err != nil {
+		return false, err
+	}
+
+	portRanges, err := compiledPatterns.get("portInRange", ranges, parsePortRanges)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range portRanges.([]portRange) {
+		if r.first <= p && p <= r.last {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PortInRangeFunc is the wrapper for PortInRange, the port can also be a number.
+func PortInRangeFunc(args ...interface{}) (interface{}, error) {
+	if len(args) == 2 {
+		if port, ok := args[0].(float64); ok && port == math.Trunc(port) {
+			args = []interface{}{strconv.Itoa(int(port)), args[1]}
+		}
+	}
+	if err := validateVariadicArgs(2, args...); err != nil {
+		return false, fmt.Errorf("%s: %s", "portInRange", err)
+	}
+
+	res, err := PortInRange(args[0].(string), args[1].(string))
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", "portInRange", err)
+	}
+	return res, nil
+}
+
+// parseHostPatterns parses a list of host name patterns separated by ";" or "," into their lower case labels.
+func parseHostPatterns(s string) (interface{}, error) {
+	var patterns [][]string
+	for _, item := range splitList(s) {
+		labels := strings.Split(strings.ToLower(strings.TrimSuffix(item, ".")), ".")
+		for _, label := range labels {
+			if _, err := path.Match(label, ""); label == "" || err != nil {
+				return nil, fmt.Errorf("invalid host name pattern %q", item)
+			}
+		}
+		patterns = append(patterns, labels)
+	}
+	return patterns, nil
+}
+
+// matchLabels determines whether the labels of a host name match the labels of a pattern.
+func matchLabels(labels []string, pattern []string) bool {
+	if len(pattern) == 0 {
+		return len(labels) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 1; i <= len(labels); i++ {
+			if matchLabels(labels[i:], pattern[1:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(labels) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], labels[0]); !ok {
+		return false
+	}
+	return matchLabels(labels[1:], pattern[1:])
+}
+
+// HostMatch determines whether host name host matches any of the patterns, a list separated by ";" or ",".
+// The labels of the patterns are globs matching a single label, like "*" or "web-*", and "**" matches one or more labels.
+// The names are case insensitive. For example, "db.svc.cluster.local" matches "*.svc.cluster.local"
+// and "api.default.svc.cluster.local" matches "**.svc.cluster.local".
+func HostMatch(host string, patterns string) (bool, error) {
+	hostPatterns, err := compiledPatterns.get("hostMatch", patterns, parseHostPatterns)
+	if err != nil {
+		return false, err
+	}
+
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(host, ".")), ".")
+	for _, pattern := range hostPatterns.([][]string) {
+		if matchLabels(labels, pattern) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// HostMatchFunc is the wrapper for HostMatch.
+func HostMatchFunc(args ...interface{}) (interface{}, error) {
+	if err := validateVariadicArgs(2, args...); err != nil {
+		return false, fmt.Errorf("%s: %s", "hostMatch", err)
+	}
+
+	res, err := HostMatch(args[0].(string), args[1].(string))
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", "hostMatch", err)
+	}
+	return res, nil
+}
+
+// version is a semantic version.
+type version struct {
+	numbers [3]uint64
+	pre     []string
+}
+
+var versionRe *regexp.Regexp = regexp.MustCompile(`^v?(\d+|[xX*])(?:\.(\d+|[xX*]))?(?:\.(\d+|[xX*]))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// parseVersion parses a semantic version like "v2.1.0-rc.1+build", it also returns how many numbers the version has.
+// The missing numbers are 0, and so are the wildcards "x" and "*", which end the numbers.
+func parseVersion(s string) (version, int, error) {
+	subs := versionRe.FindStringSubmatch(s)
+	if subs == nil {
+		return version{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+
+	var v version
+	n := 0
+	for ; n < 3; n++ {
+		number := subs[n+1]
+		if number == "" || number == "x" || number == "X" || number == "*" {
+			break
+		}
+		v.numbers[n], _ = strconv.ParseUint(number, 10, 64)
+	}
+	if subs[4] != "" {
+		v.pre = strings.Split(subs[4], ".")
+	}
+	return v, n, nil
+}
+
+// compareVersions compares the precedence of two versions, a pre-release version precedes its release.
+func compareVersions(a version, b version) int {
+	for i := range a.numbers {
+		if a.numbers[i] != b.numbers[i] {
+			if a.numbers[i] < b.numbers[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(a.pre) == 0 && len(b.pre) == 0:
+		return 0
+	case len(a.pre) == 0:
+		return 1
+	case len(b.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.pre) && i < len(b.pre); i++ {
+		n1, err1 := strconv.ParseUint(a.pre[i], 10, 64)
+		n2, err2 := strconv.ParseUint(b.pre[i], 10, 64)
+		switch {
+		case err1 == nil && err2 == nil && n1 != n2:
+			if n1 < n2 {
+				return -1
+			}
+			return 1
+		case err1 == nil && err2 != nil:
+			return -1
+		case err1 != nil && err2 == nil:
+			return 1
+		case err1 != nil && err2 != nil && a.pre[i] != b.pre[i]:
+			if a.pre[i] < b.pre[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a.pre) < len(b.pre):
+		return -1
+	case len(a.pre) > len(b.pre):
+		return 1
+	}
+	return 0
+}
+
+// versionComparator compares a version with its version.
+type versionComparator struct {
+	op string
+	v  version
+}
+
+func (c versionComparator) matches(v version) bool {
+	res := compareVersions(v, c.v)
+	switch c.op {
+	case ">":
+		return res > 0
+	case ">=":
+		return res >= 0
+	case "<":
+		return res < 0
+	case "<=":
+		return res <= 0
+	case "!=":
+		return res != 0
+	default:
+		return res == 0
+	}
+}
+
+// bump returns the smallest release version after all the versions starting with the first n numbers of v.
+func bump(v version, n int) version {
+	next := version{}
+	copy(next.numbers[:], v.numbers[:n])
+	next.numbers[n-1]++
+	return next
+}
+
+// parseVersionComparators parses a comparator like ">=2.1", "~1.2" or "2.x" into primitive comparators.
+func parseVersionComparators(op string, s string) ([]versionComparator, error) {
+	v, n, err := parseVersion(s)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		if op == "" || op == "=" || op == "==" || op == ">=" || op == "<=" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid constraint %q", op+s)
+	}
+
+	switch op {
+	case "~":
+		if n == 1 {
+			return []versionComparator{{">=", v}, {"<", bump(v, 1)}}, nil
+		}
+		return []versionComparator{{">=", v}, {"<", bump(v, 2)}}, nil
+	case "^":
+		// bump the first non-zero number, or the last given one.
+		i := 1
+		for i < n && v.numbers[i-1] == 0 {
+			i++
+		}
+		return []versionComparator{{">=", v}, {"<", bump(v, i)}}, nil
+	case "", "=", "==":
+		if n < 3 {
+			return []versionComparator{{">=", v}, {"<", bump(v, n)}}, nil
+		}
+		return []versionComparator{{"=", v}}, nil
+	case ">":
+		if n < 3 {
+			return []versionComparator{{">=", bump(v, n)}}, nil
+		}
+	case "<=":
+		if n < 3 {
+			return []versionComparator{{"<", bump(v, n)}}, nil
+		}
+	}
+	return []versionComparator{{op, v}}, nil
+}
+
+var versionOpRe *regexp.Regexp = regexp.MustCompile(`^(>=|<=|!=|==|>|<|=|~|\^)?\s*(\S*)$`)
+
+// parseVersionConstraint parses a constraint like ">=2.1 <3 || ^4.0" into the alternatives of the comparators
+// that must all match. The comparators are separated by spaces or commas.
+func parseVersionConstraint(s string) (interface{}, error) {
+	var alternatives [][]versionComparator
+	for _, alternative := range strings.Split(s, "||") {
+		fields := strings.FieldsFunc(alternative, func(r rune) bool { return r == ' ' || r == ',' })
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid constraint %q", s)
+		}
+
+		var comparators []versionComparator
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			// an operator separated from its version.
+			if strings.Trim(field, "<>=!~^") == "" && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			subs := versionOpRe.FindStringSubmatch(field)
+			if subs == nil {
+				return nil, fmt.Errorf("invalid constraint %q", field)
+			}
+			parsed, err := parseVersionComparators(subs[1], subs[2])
+			if err != nil {
+				return nil, err
+			}
+			comparators = append(comparators, parsed...)
+		}
+		alternatives = append(alternatives, comparators)
+	}
+	return alternatives, nil
+}
+
+// SemverMatch determines whether the semantic version v matches the constraint, like ">=2.1 <3".
+// The comparators are =, !=, >, >=, <, <=, ~ (patch updates), ^ (compatible updates) and versions with wildcards
+// like "2.x". The comparators separated by spaces or commas must all match, and "||" separates alternatives.
+func SemverMatch(v string, constraint string) (bool, error) {
+	ver, n, err := parseVersion(v)
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, fmt.Errorf("invalid version %q", v)
+	}
+
+	alternatives, err := compiledPatterns.get("semverMatch", constraint, parseVersionConstraint)
+	if err != nil {
+		return false, err
+	}
+	for _, comparators := range alternatives.([][]versionComparator) {
+		matched := true
+		for _, c := range comparators {
+			if !c.matches(ver) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SemverMatchFunc is the wrapper for SemverMatch.
+func SemverMatchFunc(args ...interface{}) (interface{}, error) {
+	if err := validateVariadicArgs(2, args...); err != nil {
+		return false, fmt.Errorf("%s: %s", "semverMatch", err)
+	}
+
+	res, err := SemverMatch(args[0].(string), args[1].(string))
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", "semverMatch", err)
+	}
+	return res, nil
+}
+
+var jsonPathRe *regexp.Regexp = regexp.MustCompile(`^(?:\.([^.\[\]]+)|\[(\d+)\]|\['([^']*)'\]|\["([^"]*)"\])`)
+
+// parseJSONPath parses a path like "$.owner.id", "$.items[0].name" or "$['a key']" into its keys and indexes.
+// The leading "$" is optional.
+func parseJSONPath(s string) (interface{}, error) {
+	rest := s
+	if strings.HasPrefix(s, "$") {
+		rest = s[1:]
+	} else if s != "" && s[0] != '[' {
+		rest = "." + s
+	}
+
+	var steps []interface{}
+	for rest != "" {
+		subs := jsonPathRe.FindStringSubmatch(rest)
+		if subs == nil {
+			return nil, fmt.Errorf("invalid path %q", s)
+		}
+		switch {
+		case subs[2] != "":
+			index, _ := strconv.Atoi(subs[2])
+			steps = append(steps, index)
+		case subs[1] != "":
+			steps = append(steps, subs[1])
+		case subs[3] != "":
+			steps = append(steps, subs[3])
+		default:
+			steps = append(steps, subs[4])
+		}
+		rest = rest[len(subs[0]):]
+	}
+	return steps, nil
+}
+
+// JSONGet returns the value at path of the JSON document doc, or nil when there is none.
+// The path is like "$.owner.id", "$.items[0].name" or "$['a key']".
+func JSONGet(doc string, path string) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(doc), &value); err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %s", err)
+	}
+	return jsonGet(value, path)
+}
+
+// jsonGet returns the value at path of the decoded JSON document value.
+func jsonGet(value interface{}, path string) (interface{}, error) {
+	steps, err := compiledPatterns.get("jsonGet", path, parseJSONPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, step := range steps.([]interface{}) {
+		switch typedValue := value.(type) {
+		case map[string]interface{}:
+			key, ok := step.(string)
+			if !ok {
+				return nil, nil
+			}
+			value = typedValue[key]
+		case []interface{}:
+			index, ok := step.(int)
+			if !ok || index >= len(typedValue) {
+				return nil, nil
+			}
+			value = typedValue[index]
+		default:
+			return nil, nil
+		}
+	}
+	return value, nil
+}
+
+// JSONGetFunc is the wrapper for JSONGet, the document can also be a decoded JSON document.
+func JSONGetFunc(args ...interface{}) (interface{}, error) {
+	if len(args) == 2 {
+		switch doc := args[0].(type) {
+		case map[string]interface{}, []interface{}:
+			if err := validateVariadicArgs(1, args[1]); err != nil {
+				return nil, fmt.Errorf("%s: %s", "jsonGet", err)
+			}
+			res, err := jsonGet(doc, args[1].(string))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", "jsonGet", err)
+			}
+			return res, nil
+		case []byte:
+			args = []interface{}{string(doc), args[1]}
+		}
+	}
+	if err := validateVariadicArgs(2, args...); err != nil {
+		return nil, fmt.Errorf("%s: %s", "jsonGet", err)
+	}
+
+	res, err := JSONGet(args[0].(string), args[1].(string))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", "jsonGet", err)
+	}
+	return res, nil
+}
+
 // GlobMatch determines whether key1 matches the pattern of key2 using glob pattern
 func GlobMatch(key1 string, key2 string) (bool, error) {
 	return path.Match(key2, key1)
@@ -340,29 +957,230 @@ func GenerateGFunction(rm rbac.RoleManager) govaluate.ExpressionFunction {
 	memorized := map[string]bool{}
 
 	return func(args ...interface{}) (interface{}, error) {
-		name1 := args[0].(string)
-		name2 := args[1].(string)
-
-		key := ""
-		for index := 0; index < len(args); index++ {
-			key += ";" + fmt.Sprintf("%v", args[index])
-		}
-
+		key := gKey(args)
 		v, found := memorized[key]
 		if found {
 			return v, nil
 		}
 
-		if rm == nil {
-			v = name1 == name2
-		} else if len(args) == 2 {
-			v, _ = rm.HasLink(name1, name2)
-		} else {
-			domain := args[2].(string)
-			v, _ = rm.HasLink(name1, name2, domain)
+		v = hasLink(rm, args)
+		memorized[key] = v
+		return v, nil
+	}
+}
+
+// GMemo memorizes the results of the g(_, _) functions generated by GenerateGFunctionWithMemo.
+type GMemo map[string]bool
+
+// GenerateGFunctionWithMemo is the factory method of a g(_, _) function taking its memo as first
+// argument, so that an expression compiled once memorizes the results of a single evaluation only.
+// The results are not memorized when the first argument is not a GMemo.
+func GenerateGFunctionWithMemo(rm rbac.RoleManager) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		memo, ok := args[0].(GMemo)
+		if !ok {
+			return hasLink(rm, args), nil
 		}
 
-		memorized[key] = v
+		args = args[1:]
+		key := gKey(args)
+		if v, found := memo[key]; found {
+			return v, nil
+		}
+		v := hasLink(rm, args)
+		memo[key] = v
 		return v, nil
 	}
 }
+
+// gKey returns the memo key of the arguments of a g(_, _) function.
+func gKey(args []interface{}) string {
+	key := ""
+	for index := 0; index < len(args); index++ {
+		key += ";" + fmt.Sprintf("%v", args[index])
+	}
+	return key
+}
+
+// hasLink returns whether the role manager links the names of the arguments of a g(_, _) function.
+func hasLink(rm rbac.RoleManager, args []interface{}) bool {
+	name1 := args[0].(string)
+	name2 := args[1].(string)
+
+	var v bool
+	if rm == nil {
+		v = name1 == name2
+	} else if len(args) == 2 {
+		v, _ = rm.HasLink(name1, name2)
+	} else {
+		domain := args[2].(string)
+		v, _ = rm.HasLink(name1, name2, domain)
+	}
+	return v
+}
+
+// Clock tells the current time to the time functions.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the clock of the system, the default clock of the time functions.
+var SystemClock Clock = systemClock{}
+
+// FixedClock is a clock frozen at its time.
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+var locations = &sync.Map{}
+
+// loadLocation loads the time zone location of the given name once.
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// parseTimeOfDay parses a time of day as "15:04" or "15:04:05" into the seconds since midnight.
+func parseTimeOfDay(s string) (int, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Hour()*3600 + t.Minute()*60 + t.Second(), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time of day %q, expected 15:04 or 15:04:05", s)
+}
+
+// TimeInRange determines whether the time of day of t is between start and end, given as "15:04" or "15:04:05".
+// The range includes start and end, and wraps around midnight when end is before start, like "22:00" to "06:00".
+func TimeInRange(t time.Time, start string, end string) (bool, error) {
+	from, err := parseTimeOfDay(start)
+	if err != nil {
+		return false, err
+	}
+	to, err := parseTimeOfDay(end)
+	if err != nil {
+		return false, err
+	}
+
+	now := t.Hour()*3600 + t.Minute()*60 + t.Second()
+	if from <= to {
+		return from <= now && now <= to, nil
+	}
+	return now >= from || now <= to, nil
+}
+
+// ToTime converts the time arguments of the time functions to a time: times, unix seconds, which the dates
+// of the matchers are parsed to, and strings in RFC 3339 or "2006-01-02" format.
+func ToTime(value interface{}) (time.Time, error) {
+	switch typedValue := value.(type) {
+	case time.Time:
+		return typedValue, nil
+	case float64:
+		sec, frac := math.Modf(typedValue)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			if t, err := time.Parse(layout, typedValue); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or 2006-01-02", typedValue)
+	default:
+		return time.Time{}, fmt.Errorf("invalid time %v", value)
+	}
+}
+
+// GenerateTimeFunctions is the factory method of the time functions, which read the current time from clock.
+//
+//	now()                                 the current time in unix seconds, comparable to the dates of the matcher
+//	timeInRange(start, end[, location])   whether the current time of day is between start and end, like "09:00"
+//	dayOfWeek([location])                 the current day of the week, "Mon" to "Sun"
+//	before(t), after(t)                   whether the current time is before or after t
+//
+// The times of day and days are in the time zone location of the given name, like "Asia/Kolkata", or in UTC.
+func GenerateTimeFunctions(clock Clock) map[string]govaluate.ExpressionFunction {
+	nowIn := func(name string, args []interface{}, maxArgs int) (time.Time, error) {
+		if len(args) > maxArgs {
+			return time.Time{}, fmt.Errorf("%s: Expected at most %d arguments, but got %d", name, maxArgs, len(args))
+		}
+		now := clock.Now().UTC()
+		if len(args) == maxArgs && maxArgs > 0 {
+			location, ok := args[maxArgs-1].(string)
+			if !ok {
+				return time.Time{}, fmt.Errorf("%s: %s", name, "Location must be a string")
+			}
+			loc, err := loadLocation(location)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("%s: %s", name, err)
+			}
+			now = now.In(loc)
+		}
+		return now, nil
+	}
+
+	compare := func(name string, before bool) govaluate.ExpressionFunction {
+		return func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return false, fmt.Errorf("%s: Expected 1 arguments, but got %d", name, len(args))
+			}
+			t, err := ToTime(args[0])
+			if err != nil {
+				return false, fmt.Errorf("%s: %s", name, err)
+			}
+			if before {
+				return clock.Now().Before(t), nil
+			}
+			return clock.Now().After(t), nil
+		}
+	}
+
+	return map[string]govaluate.ExpressionFunction{
+		"now": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 0 {
+				return nil, fmt.Errorf("%s: Expected 0 arguments, but got %d", "now", len(args))
+			}
+			return float64(clock.Now().UnixNano()) / 1e9, nil
+		},
+		"timeInRange": func(args ...interface{}) (interface{}, error) {
+			if len(args) < 2 {
+				return false, fmt.Errorf("%s: Expected 2 or 3 arguments, but got %d", "timeInRange", len(args))
+			}
+			if err := validateVariadicArgs(2, args[:2]...); err != nil {
+				return false, fmt.Errorf("%s: %s", "timeInRange", err)
+			}
+			now, err := nowIn("timeInRange", args[2:], 1)
+			if err != nil {
+				return false, err
+			}
+			ok, err := TimeInRange(now, args[0].(string), args[1].(string))
+			if err != nil {
+				return false, fmt.Errorf("%s: %s", "timeInRange", err)
+			}
+			return ok, nil
+		},
+		"dayOfWeek": func(args ...interface{}) (interface{}, error) {
+			now, err := nowIn("dayOfWeek", args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return now.Weekday().String()[:3], nil
+		},
+		"before": compare("before", true),
+		"after":  compare("after", false),
+	}
+}
@@ -22,6 +22,7 @@ package util
 
 import (
 	"testing"
+	"time"
 )
 
 func testKeyMatch(t *testing.T, key1 string, key2 string, res bool) {
@@ -498,3 +499,265 @@ func TestGlobMatch(t *testing.T) {
 	testGlobMatch(t, "/prefix/subprefix/foobar", "*/foo*", false)
 	testGlobMatch(t, "/prefix/subprefix/foobar", "*/foo/*", false)
 }
+
+func testTimeInRange(t *testing.T, now string, start string, end string, res bool) {
+	t.Helper()
+	tm, _ := time.Parse("15:04", now)
+	myRes, err := TimeInRange(tm, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if myRes != res {
+		t.Errorf("%s in %s-%s: %t, supposed to be %t", now, start, end, myRes, res)
+	}
+}
+
+func TestTimeInRange(t *testing.T) {
+	testTimeInRange(t, "09:00", "09:00", "18:00", true)
+	testTimeInRange(t, "12:30", "09:00", "18:00", true)
+	testTimeInRange(t, "18:01", "09:00", "18:00", false)
+	testTimeInRange(t, "08:59", "09:00", "18:00", false)
+	testTimeInRange(t, "23:00", "22:00", "06:00", true)
+	testTimeInRange(t, "05:00", "22:00", "06:00", true)
+	testTimeInRange(t, "12:00", "22:00", "06:00", false)
+
+	if _, err := TimeInRange(time.Now(), "9am", "18:00"); err == nil {
+		t.Error("9am: an error is expected")
+	}
+}
+
+func TestTimeFunctions(t *testing.T) {
+	// Monday 2021-03-01 04:00 UTC, 09:30 in India.
+	clock := FixedClock(time.Date(2021, 3, 1, 4, 0, 0, 0, time.UTC))
+	fns := GenerateTimeFunctions(clock)
+
+	call := func(name string, args ...interface{}) interface{} {
+		t.Helper()
+		res, err := fns[name](args...)
+		if err != nil {
+			t.Fatalf("%s%v: %s", name, args, err)
+		}
+		return res
+	}
+
+	if res := call("now"); res != float64(1614571200) {
+		t.Errorf("now(): %v", res)
+	}
+	if res := call("timeInRange", "09:00", "18:00"); res != false {
+		t.Errorf("timeInRange in UTC: %v", res)
+	}
+	if res := call("timeInRange", "09:00", "18:00", "Asia/Kolkata"); res != true {
+		t.Errorf("timeInRange in India: %v", res)
+	}
+	if res := call("dayOfWeek"); res != "Mon" {
+		t.Errorf("dayOfWeek(): %v", res)
+	}
+	if res := call("dayOfWeek", "America/Los_Angeles"); res != "Sun" {
+		t.Errorf("dayOfWeek in Los Angeles: %v", res)
+	}
+	if res := call("before", "2021-03-02"); res != true {
+		t.Errorf("before(2021-03-02): %v", res)
+	}
+	if res := call("after", float64(1614571200-1)); res != true {
+		t.Errorf("after(now - 1s): %v", res)
+	}
+	if res := call("after", "2021-03-01T05:00:00Z"); res != false {
+		t.Errorf("after(2021-03-01T05:00:00Z): %v", res)
+	}
+
+	if _, err := fns["dayOfWeek"]("Nowhere/City"); err == nil {
+		t.Error("dayOfWeek(Nowhere/City): an error is expected")
+	}
+}
+
+func testNetworkFunc(t *testing.T, name string, fn func(string, string) (bool, error), value string, pattern string, res bool) {
+	t.Helper()
+	myRes, err := fn(value, pattern)
+	if err != nil {
+		t.Errorf("%s(%s, %s): %s", name, value, pattern, err)
+		return
+	}
+
+	if myRes != res {
+		t.Errorf("%s(%s, %s): %t, supposed to be %t", name, value, pattern, myRes, res)
+	}
+}
+
+func TestIPInAny(t *testing.T) {
+	testNetworkFunc(t, "ipInAny", IPInAny, "10.1.2.3", "10.0.0.0/8;192.168.0.0/16", true)
+	testNetworkFunc(t, "ipInAny", IPInAny, "192.168.2.123", "10.0.0.0/8;192.168.0.0/16", true)
+	testNetworkFunc(t, "ipInAny", IPInAny, "172.16.0.1", "10.0.0.0/8;192.168.0.0/16", false)
+	testNetworkFunc(t, "ipInAny", IPInAny, "172.16.0.1", "10.0.0.0/8, 172.16.0.1", true)
+	testNetworkFunc(t, "ipInAny", IPInAny, "2001:db8::1", "10.0.0.0/8;2001:db8::/32", true)
+	testNetworkFunc(t, "ipInAny", IPInAny, "10.0.0.7", "10.0.0.5-10.0.0.50", true)
+
+	if _, err := IPInAny("10.0.0.256", "10.0.0.0/8"); err == nil {
+		t.Error("10.0.0.256: an error is expected")
+	}
+	if _, err := IPInAny("10.0.0.1", "10.0.0.0/8;intranet"); err == nil {
+		t.Error("intranet: an error is expected")
+	}
+}
+
+func TestIPRange(t *testing.T) {
+	testNetworkFunc(t, "ipRange", IPRange, "10.0.0.5", "10.0.0.5-10.0.0.50", true)
+	testNetworkFunc(t, "ipRange", IPRange, "10.0.0.50", "10.0.0.5-10.0.0.50", true)
+	testNetworkFunc(t, "ipRange", IPRange, "10.0.0.51", "10.0.0.5-10.0.0.50", false)
+	testNetworkFunc(t, "ipRange", IPRange, "10.0.1.6", "10.0.0.5-10.0.0.50", false)
+	testNetworkFunc(t, "ipRange", IPRange, "2001:db8::ff", "2001:db8::1-2001:db8::1:0", true)
+	testNetworkFunc(t, "ipRange", IPRange, "2001:db8::2:0", "2001:db8::1-2001:db8::1:0", false)
+	testNetworkFunc(t, "ipRange", IPRange, "::ffff:10.0.0.7", "10.0.0.5-10.0.0.50", true)
+	testNetworkFunc(t, "ipRange", IPRange, "2001:db8::1", "10.0.0.5-10.0.0.50", false)
+
+	if _, err := IPRange("10.0.0.7", "10.0.0.50-10.0.0.5"); err == nil {
+		t.Error("10.0.0.50-10.0.0.5: an error is expected")
+	}
+	if _, err := IPRange("10.0.0.7", "10.0.0.5-2001:db8::1"); err == nil {
+		t.Error("10.0.0.5-2001:db8::1: an error is expected")
+	}
+}
+
+func TestPortInRange(t *testing.T) {
+	testNetworkFunc(t, "portInRange", PortInRange, "80", "80;443;8000-8080", true)
+	testNetworkFunc(t, "portInRange", PortInRange, "8080", "80;443;8000-8080", true)
+	testNetworkFunc(t, "portInRange", PortInRange, "8081", "80;443;8000-8080", false)
+	testNetworkFunc(t, "portInRange", PortInRange, "22", "1024-65535", false)
+
+	if res, err := PortInRangeFunc(float64(443), "80;443"); res != true || err != nil {
+		t.Errorf("portInRange(443, 80;443): %v, %v", res, err)
+	}
+	if _, err := PortInRange("70000", "80"); err == nil {
+		t.Error("70000: an error is expected")
+	}
+	if _, err := PortInRange("80", "http"); err == nil {
+		t.Error("http: an error is expected")
+	}
+}
+
+func TestHostMatch(t *testing.T) {
+	testNetworkFunc(t, "hostMatch", HostMatch, "db.svc.cluster.local", "*.svc.cluster.local", true)
+	testNetworkFunc(t, "hostMatch", HostMatch, "api.default.svc.cluster.local", "*.svc.cluster.local", false)
+	testNetworkFunc(t, "hostMatch", HostMatch, "api.default.svc.cluster.local", "**.svc.cluster.local", true)
+	testNetworkFunc(t, "hostMatch", HostMatch, "svc.cluster.local", "**.svc.cluster.local", false)
+	testNetworkFunc(t, "hostMatch", HostMatch, "Web-1.Example.com.", "web-*.example.com", true)
+	testNetworkFunc(t, "hostMatch", HostMatch, "example.org", "*.example.com;example.org", true)
+
+	if _, err := HostMatch("example.com", "[.example.com"); err == nil {
+		t.Error("[.example.com: an error is expected")
+	}
+}
+
+func testSemverMatch(t *testing.T, v string, constraint string, res bool) {
+	t.Helper()
+	myRes, err := SemverMatch(v, constraint)
+	if err != nil {
+		t.Errorf("%s, %s: %s", v, constraint, err)
+		return
+	}
+	t.Logf("%s, %s: %t", v, constraint, myRes)
+
+	if myRes != res {
+		t.Errorf("%s, %s: %t, supposed to be %t", v, constraint, myRes, res)
+	}
+}
+
+func TestSemverMatch(t *testing.T) {
+	testSemverMatch(t, "2.1.0", ">=2.1 <3", true)
+	testSemverMatch(t, "v2.9.12", ">=2.1 <3", true)
+	testSemverMatch(t, "3.0.0", ">=2.1 <3", false)
+	testSemverMatch(t, "2.0.9", ">=2.1 <3", false)
+	testSemverMatch(t, "3.0.0-rc.1", ">=2.1 <3", true)
+	testSemverMatch(t, "2.1.0", ">= 2.1, < 3", true)
+	testSemverMatch(t, "1.2.5", "~1.2.3", true)
+	testSemverMatch(t, "1.3.0", "~1.2.3", false)
+	testSemverMatch(t, "1.9.0", "^1.2.3", true)
+	testSemverMatch(t, "2.0.0", "^1.2.3", false)
+	testSemverMatch(t, "0.2.9", "^0.2.3", true)
+	testSemverMatch(t, "0.3.0", "^0.2.3", false)
+	testSemverMatch(t, "2.4.1", "2.x", true)
+	testSemverMatch(t, "2.4.1", "2.4", true)
+	testSemverMatch(t, "2.5.0", "2.4", false)
+	testSemverMatch(t, "2.4.1", "*", true)
+	testSemverMatch(t, "2.2.0", ">2.1", true)
+	testSemverMatch(t, "2.1.5", ">2.1", false)
+	testSemverMatch(t, "2.1.5", "<=2.1", true)
+	testSemverMatch(t, "2.1.5", "!=2.1.5", false)
+	testSemverMatch(t, "4.1.0", "<2 || ^4.0", true)
+	testSemverMatch(t, "1.0.0", "=1.0.0+build.5", true)
+	testSemverMatch(t, "1.0.0-alpha", "<1.0.0-alpha.1", true)
+	testSemverMatch(t, "1.0.0-alpha.beta", ">1.0.0-alpha.1", true)
+	testSemverMatch(t, "1.0.0-rc.1", "<1.0.0-rc.11", true)
+}
+
+func testSemverMatchFunc(t *testing.T, res bool, err string, args ...interface{}) {
+	t.Helper()
+	myRes, myErr := SemverMatchFunc(args...)
+	myErrStr := ""
+
+	if myErr != nil {
+		myErrStr = myErr.Error()
+	}
+
+	if myRes != res || err != myErrStr {
+		t.Errorf("%v returns %v %v, supposed to be %v %v", args, myRes, myErr, res, err)
+	}
+}
+
+func TestSemverMatchFunc(t *testing.T) {
+	testSemverMatchFunc(t, false, "semverMatch: Expected 2 arguments, but got 1", "2.1.0")
+	testSemverMatchFunc(t, false, "semverMatch: Argument must be a string", "2.1.0", 2)
+	testSemverMatchFunc(t, false, "semverMatch: invalid version \"latest\"", "latest", ">=2.1")
+	testSemverMatchFunc(t, false, "semverMatch: invalid version \"two\"", "2.1.0", ">=two")
+	testSemverMatchFunc(t, true, "", "2.1.0", ">=2.1 <3")
+}
+
+func testJSONGet(t *testing.T, doc string, path string, res interface{}) {
+	t.Helper()
+	myRes, err := JSONGet(doc, path)
+	if err != nil {
+		t.Errorf("%s, %s: %s", doc, path, err)
+		return
+	}
+	t.Logf("%s, %s: %v", doc, path, myRes)
+
+	if myRes != res {
+		t.Errorf("%s, %s: %v, supposed to be %v", doc, path, myRes, res)
+	}
+}
+
+func TestJSONGet(t *testing.T) {
+	doc := `{"owner": {"id": "alice", "age": 30}, "items": [{"name": "a"}, {"name": "b"}], "a key": true}`
+	testJSONGet(t, doc, "$.owner.id", "alice")
+	testJSONGet(t, doc, "owner.age", float64(30))
+	testJSONGet(t, doc, "$.items[1].name", "b")
+	testJSONGet(t, doc, "$['a key']", true)
+	testJSONGet(t, doc, `$["owner"]["id"]`, "alice")
+	testJSONGet(t, doc, "$.owner.name", nil)
+	testJSONGet(t, doc, "$.items[2].name", nil)
+	testJSONGet(t, doc, "$.owner.id.first", nil)
+}
+
+func testJSONGetFunc(t *testing.T, res interface{}, err string, args ...interface{}) {
+	t.Helper()
+	myRes, myErr := JSONGetFunc(args...)
+	myErrStr := ""
+
+	if myErr != nil {
+		myErrStr = myErr.Error()
+	}
+
+	if myRes != res || err != myErrStr {
+		t.Errorf("%v returns %v %v, supposed to be %v %v", args, myRes, myErr, res, err)
+	}
+}
+
+func TestJSONGetFunc(t *testing.T) {
+	testJSONGetFunc(t, nil, "jsonGet: Expected 2 arguments, but got 1", `{}`)
+	testJSONGetFunc(t, nil, "jsonGet: Argument must be a string", `{}`, 1)
+	testJSONGetFunc(t, nil, "jsonGet: invalid path \"$owner\"", `{}`, "$owner")
+	testJSONGetFunc(t, nil, "jsonGet: invalid JSON document: unexpected end of JSON input", `{"owner":`, "$.owner")
+	testJSONGetFunc(t, "alice", "", `{"owner": {"id": "alice"}}`, "$.owner.id")
+	testJSONGetFunc(t, "alice", "", []byte(`{"owner": {"id": "alice"}}`), "$.owner.id")
+	testJSONGetFunc(t, "alice", "", map[string]interface{}{"owner": map[string]interface{}{"id": "alice"}}, "$.owner.id")
+}
@@ -0,0 +1,150 @@
+package util
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultPatternCacheSize is the default number of compiled patterns kept by the built-in functions.
+const DefaultPatternCacheSize = 4096
+
+// PatternCacheStats are the counters of the cache of the compiled patterns of the built-in functions.
+type PatternCacheStats struct {
+	Hits     uint64
+	Misses   uint64
+	Size     int
+	Capacity int
+}
+
+// patternCache is a least recently used cache of compiled patterns, safe for concurrent use.
+type patternCache struct {
+	m        sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+
+	hits   uint64
+	misses uint64
+}
+
+type patternEntry struct {
+	key   string
+	value interface{}
+}
+
+var compiledPatterns = newPatternCache(DefaultPatternCacheSize)
+
+func newPatternCache(capacity int) *patternCache {
+	return &patternCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the pattern compiled by the function, compiling it with compile on a miss.
+// The errors of compile are not cached.
+func (c *patternCache) get(function string, pattern string, compile func(string) (interface{}, error)) (interface{}, error) {
+	key := function + "$$" + pattern
+
+	c.m.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.m.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		return elem.Value.(*patternEntry).value, nil
+	}
+	c.m.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	value, err := compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		return elem.Value.(*patternEntry).value, nil
+	}
+	if c.capacity > 0 {
+		c.entries[key] = c.order.PushFront(&patternEntry{key: key, value: value})
+		c.evict()
+	}
+	return value, nil
+}
+
+// evict removes the least recently used patterns above the capacity.
+func (c *patternCache) evict() {
+	for c.order.Len() > c.capacity {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.entries, elem.Value.(*patternEntry).key)
+	}
+}
+
+// GetPatternCacheStats returns the counters of the cache of the compiled patterns of the built-in functions.
+func GetPatternCacheStats() PatternCacheStats {
+	compiledPatterns.m.Lock()
+	defer compiledPatterns.m.Unlock()
+	return PatternCacheStats{
+		Hits:     atomic.LoadUint64(&compiledPatterns.hits),
+		Misses:   atomic.LoadUint64(&compiledPatterns.misses),
+		Size:     compiledPatterns.order.Len(),
+		Capacity: compiledPatterns.capacity,
+	}
+}
+
+// SetPatternCacheSize sets the number of compiled patterns kept by the built-in functions, 0 disables the cache.
+func SetPatternCacheSize(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	compiledPatterns.m.Lock()
+	defer compiledPatterns.m.Unlock()
+	compiledPatterns.capacity = capacity
+	compiledPatterns.evict()
+}
+
+// ResetPatternCache removes the compiled patterns and resets the counters.
+func ResetPatternCache() {
+	compiledPatterns.m.Lock()
+	defer compiledPatterns.m.Unlock()
+	compiledPatterns.entries = make(map[string]*list.Element)
+	compiledPatterns.order.Init()
+	atomic.StoreUint64(&compiledPatterns.hits, 0)
+	atomic.StoreUint64(&compiledPatterns.misses, 0)
+}
+
+// compileRegexp returns the compiled regular expression of the pattern of the function.
+func compileRegexp(function string, pattern string) (*regexp.Regexp, error) {
+	re, err := compiledPatterns.get(function, pattern, func(pattern string) (interface{}, error) {
+		return regexp.Compile(pattern)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return re.(*regexp.Regexp), nil
+}
@@ -0,0 +1,114 @@
+package util
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestPatternCache(t *testing.T) {
+	ResetPatternCache()
+	defer ResetPatternCache()
+
+	KeyMatch2("/foo/bar", "/foo/:id")
+	KeyMatch2("/foo/baz", "/foo/:id")
+	KeyMatch2("/foo/bar", "/bar/:id")
+	RegexMatch("/foo/bar", "/foo/:id")
+
+	stats := GetPatternCacheStats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Size != 3 || stats.Capacity != DefaultPatternCacheSize {
+		t.Errorf("stats: %+v", stats)
+	}
+
+	// the least recently used patterns are evicted.
+	SetPatternCacheSize(2)
+	defer SetPatternCacheSize(DefaultPatternCacheSize)
+	if stats := GetPatternCacheStats(); stats.Size != 2 {
+		t.Errorf("stats: %+v", stats)
+	}
+	KeyMatch2("/foo/bar", "/bar/:id")
+	if stats := GetPatternCacheStats(); stats.Hits != 2 {
+		t.Errorf("/bar/:id should be cached: %+v", stats)
+	}
+	KeyMatch2("/foo/bar", "/foo/:id")
+	if stats := GetPatternCacheStats(); stats.Misses != 4 || stats.Size != 2 {
+		t.Errorf("/foo/:id should be evicted: %+v", stats)
+	}
+
+	// invalid patterns are not cached.
+	func() {
+		defer func() { _ = recover() }()
+		RegexMatch("foo", "(")
+	}()
+	if stats := GetPatternCacheStats(); stats.Size != 2 || stats.Misses != 5 {
+		t.Errorf("stats: %+v", stats)
+	}
+}
+
+func TestPatternCacheResults(t *testing.T) {
+	defer SetPatternCacheSize(DefaultPatternCacheSize)
+
+	keys := []string{"/foo", "/foo/bar", "/foo/bar/baz", "/parent/123/child/123", "/parent/123/child/456", "/resource1", "/proxy/myid/res/res2"}
+	patterns := []string{"/foo", "/foo/*", "/foo/:id", "/foo/{id}", "/:resource", "/{resource}", "/parent/{id}/child/{id}", "/proxy/:id/*", "^/foo/[a-z]+$"}
+
+	results := func() []string {
+		var res []string
+		for _, key := range keys {
+			for _, pattern := range patterns {
+				res = append(res, fmt.Sprint(KeyMatch2(key, pattern), KeyMatch3(key, pattern), KeyMatch4(key, pattern),
+					KeyGet2(key, pattern, "id"), KeyGet2(key, pattern, "resource"), RegexMatch(key, pattern)))
+			}
+		}
+		return res
+	}
+
+	SetPatternCacheSize(0)
+	uncached := results()
+	SetPatternCacheSize(DefaultPatternCacheSize)
+	results()
+	cached := results()
+	if !ArrayEquals(uncached, cached) {
+		t.Errorf("the cached results %v differ from %v", cached, uncached)
+	}
+}
+
+func TestPatternCacheConcurrent(t *testing.T) {
+	defer SetPatternCacheSize(DefaultPatternCacheSize)
+	SetPatternCacheSize(8)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				pattern := fmt.Sprintf("/res%d/:id", (i+j)%16)
+				if !KeyMatch2(fmt.Sprintf("/res%d/1", (i+j)%16), pattern) {
+					t.Errorf("%s should match", pattern)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -28,6 +28,10 @@ import (
 
 var evalReg *regexp.Regexp = regexp.MustCompile(`\beval\((?P<rule>[^)]*)\)`)
 
+var equalityReg *regexp.Regexp = regexp.MustCompile(`^([rp][0-9]*_\w+)\s*==\s*([rp][0-9]*_\w+)$`)
+
+var attributeReg *regexp.Regexp = regexp.MustCompile(`(^|[^\w.\[])(r[0-9]*_\w+(?:\.\w+)+)`)
+
 // EscapeAssertion escapes the dots in the assertion, because the expression evaluation doesn't support such variable names.
 func EscapeAssertion(s string) string {
 	//Replace the first dot, because it can't be recognized by the regexp.
@@ -41,6 +45,31 @@ func EscapeAssertion(s string) string {
 	return s
 }
 
+// EscapeAttributes brackets the attribute references of the escaped assertion, like "r_sub.department",
+// so that the expression evaluation reads them as a single variable named "r_sub.department".
+// The string literals of the assertion are left untouched.
+func EscapeAttributes(s string) string {
+	var res strings.Builder
+	start := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == 0 && (s[i] == '\'' || s[i] == '"'):
+			res.WriteString(attributeReg.ReplaceAllString(s[start:i], "$1[$2]"))
+			start, quote = i, s[i]
+		case quote != 0 && s[i] == quote:
+			res.WriteString(s[start : i+1])
+			start, quote = i+1, 0
+		}
+	}
+	if quote != 0 {
+		res.WriteString(s[start:])
+	} else {
+		res.WriteString(attributeReg.ReplaceAllString(s[start:], "$1[$2]"))
+	}
+	return res.String()
+}
+
 // RemoveComments removes the comments starting with # in the text.
 func RemoveComments(s string) string {
 	pos := strings.Index(s, "#")
@@ -203,3 +232,88 @@ func RemoveDuplicateElement(s []string) []string {
 	}
 	return result
 }
+
+// SplitTopLevel splits the matcher s by the operator op, ignoring occurrences that are
+// nested in parentheses or quoted strings. The outermost parentheses enclosing the whole
+// matcher are removed first.
+func SplitTopLevel(s string, op string) []string {
+	s = strings.TrimSpace(s)
+	for len(s) > 1 && s[0] == '(' && closingParen(s, 0) == len(s)-1 {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], op):
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			i += len(op) - 1
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// closingParen returns the position of the parenthesis closing the one at position open, or -1.
+func closingParen(s string, open int) int {
+	depth := 0
+	var quote byte
+	for i := open; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// GetEqualityTokens returns the policy tokens of pType that the escaped matcher s requires to be
+// equal to a request token of rType, mapped to that request token.
+// Only comparisons which are top-level conjuncts of the matcher are returned, e.g. for
+// "g(r_sub, p_sub) && r_obj == p_obj" the result is {"p_obj": "r_obj"}.
+func GetEqualityTokens(s string, rType string, pType string) map[string]string {
+	if len(SplitTopLevel(s, "||")) != 1 {
+		return nil
+	}
+
+	tokens := make(map[string]string)
+	for _, conjunct := range SplitTopLevel(s, "&&") {
+		subs := equalityReg.FindStringSubmatch(conjunct)
+		if subs == nil {
+			continue
+		}
+		left, right := subs[1], subs[2]
+		if strings.HasPrefix(left, pType+"_") {
+			left, right = right, left
+		}
+		if strings.HasPrefix(left, rType+"_") && strings.HasPrefix(right, pType+"_") {
+			tokens[right] = left
+		}
+	}
+	return tokens
+}
@@ -50,6 +50,26 @@ func TestEscapeAssertion(t *testing.T) {
 	testEscapeAssertion(t, "(r.attp.value || p.attr)p.u", "(r_attp.value || p_attr)p_u")
 }
 
+func testEscapeAttributes(t *testing.T, s string, res string) {
+	t.Helper()
+	myRes := EscapeAttributes(s)
+	t.Logf("%s: %s", s, myRes)
+
+	if myRes != res {
+		t.Errorf("%s: %s, supposed to be %s", s, myRes, res)
+	}
+}
+
+func TestEscapeAttributes(t *testing.T) {
+	testEscapeAttributes(t, "r_sub == r_obj.Owner", "r_sub == [r_obj.Owner]")
+	testEscapeAttributes(t, "r_sub.department.name == p_dept", "[r_sub.department.name] == p_dept")
+	testEscapeAttributes(t, "(r_sub.Age>18)&&r2_obj.a", "([r_sub.Age]>18)&&[r2_obj.a]")
+	testEscapeAttributes(t, "keyMatch(r_obj.path, '/r_obj.path') && r_sub.level > 1.5", "keyMatch([r_obj.path], '/r_obj.path') && [r_sub.level] > 1.5")
+	testEscapeAttributes(t, "[r_sub.Age] > 18", "[r_sub.Age] > 18")
+	testEscapeAttributes(t, "r_sub == p_sub", "r_sub == p_sub")
+	testEscapeAttributes(t, "attr_sub.name == \"r_sub.name\"", "attr_sub.name == \"r_sub.name\"")
+}
+
 func testRemoveComments(t *testing.T, s string, res string) {
 	t.Helper()
 	myRes := RemoveComments(s)
@@ -187,3 +207,27 @@ func TestReplaceEvalWithMap(t *testing.T) {
 	testReplaceEvalWithMap(t, "eval(rule1) || eval(rule2)", nil, "eval(rule1) || eval(rule2)")
 	testReplaceEvalWithMap(t, "eval(rule1) || eval(rule2) && c && d", nil, "eval(rule1) || eval(rule2) && c && d")
 }
+
+func testGetEqualityTokens(t *testing.T, s string, res map[string]string) {
+	t.Helper()
+	myRes := GetEqualityTokens(s, "r", "p")
+	t.Logf("%s: %v", s, myRes)
+
+	if len(myRes) != len(res) {
+		t.Errorf("%s: %v, supposed to be %v", s, myRes, res)
+		return
+	}
+	for k, v := range res {
+		if myRes[k] != v {
+			t.Errorf("%s: %v, supposed to be %v", s, myRes, res)
+		}
+	}
+}
+
+func TestGetEqualityTokens(t *testing.T) {
+	testGetEqualityTokens(t, "r_sub == p_sub && r_obj == p_obj && r_act == p_act", map[string]string{"p_sub": "r_sub", "p_obj": "r_obj", "p_act": "r_act"})
+	testGetEqualityTokens(t, "g(r_sub, p_sub) && p_obj == r_obj && keyMatch(r_act, p_act)", map[string]string{"p_obj": "r_obj"})
+	testGetEqualityTokens(t, "(r_obj == p_obj && (r_act == p_act || p_act == '*'))", map[string]string{"p_obj": "r_obj"})
+	testGetEqualityTokens(t, "r_obj == p_obj || r_sub == 'root'", map[string]string{})
+	testGetEqualityTokens(t, "r_sub == 'a && b' && r2_obj == p_obj && r_obj == p2_obj", map[string]string{})
+}